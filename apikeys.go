@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/store"
+)
+
+const apiKeysUsage = `Usage: formfling api-keys <command> [arguments]
+
+Commands:
+  list                                  List API keys
+  create -scopes a,b <name>             Create a key and print it once
+  revoke <id>                           Revoke a key
+
+Scopes are submissions:read, submissions:write, submissions:delete,
+submissions:replay, forms:read and forms:write.
+
+The server must be stopped while the database is changed, as only one process
+can open it.
+`
+
+// runAPIKeys manages API keys from the command line and returns the exit code
+func runAPIKeys(cfg *config.Config, args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, apiKeysUsage)
+		return 2
+	}
+	if args[0] != "list" && args[0] != "create" && args[0] != "revoke" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], apiKeysUsage)
+		return 2
+	}
+	s, err := openStore(cfg, "manage API keys")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "list":
+		err = listAPIKeys(s)
+	case "create":
+		err = createAPIKey(s, args[1:])
+	case "revoke":
+		err = revokeAPIKey(s, args[1:])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func listAPIKeys(s *store.Store) error {
+	keys, err := s.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tLAST USED")
+	for _, key := range keys {
+		lastUsed := "never"
+		if !key.LastUsedAt.IsZero() {
+			lastUsed = key.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s…\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.CreatedAt.Format("2006-01-02 15:04"), lastUsed)
+	}
+	return w.Flush()
+}
+
+func createAPIKey(s *store.Store, args []string) error {
+	flags := flag.NewFlagSet("api-keys create", flag.ContinueOnError)
+	scopeList := flags.String("scopes", "", "comma-separated scopes the key grants")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("create needs exactly one name")
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(*scopeList, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !validScope(scope) {
+			return fmt.Errorf("unknown scope %q (expected %s)", scope, strings.Join(models.Scopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return fmt.Errorf("-scopes needs at least one of %s", strings.Join(models.Scopes, ", "))
+	}
+
+	key, plain, err := s.CreateAPIKey(flags.Arg(0), scopes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created key %s. It is shown only once:\n%s\n", key.ID, plain)
+	return nil
+}
+
+func revokeAPIKey(s *store.Store, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("revoke needs exactly one key ID")
+	}
+	err := s.DeleteAPIKey(args[0])
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("no API key with ID %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %s\n", args[0])
+	return nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+
+	"formfling/internal/config"
+	"formfling/internal/handlers"
+	"formfling/internal/inbound"
+	"formfling/internal/models"
+	"formfling/internal/services"
+)
+
+// runCheckConfig validates the configuration without starting the server and
+// returns the exit code
+func runCheckConfig(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	dev := flags.Bool("dev", false, "check the settings of development mode")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dev {
+		cfg.UseDevMode()
+	}
+
+	errs := checkConfig(cfg)
+	if len(errs) == 0 {
+		fmt.Println("Configuration OK")
+		return 0
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "- %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(errs))
+	return 1
+}
+
+// checkConfig returns every problem with the settings, templates and forms
+// that would stop the server from starting or break a page later
+func checkConfig(cfg *config.Config) []error {
+	errs := cfg.Validate()
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, err := services.NewOIDCService(cfg, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.InboundAddr != "" {
+		if _, err := inbound.NewServer(cfg, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.TLSEnabled() {
+		if certificates, err := services.LoadCertificate(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			errs = append(errs, err)
+		} else if _, err := services.NewTLSConfig(cfg, certificates); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Templates are executed with sample data, so missing fields show up too
+	if emailService, err := services.LoadEmailService(cfg); err != nil {
+		fail("EMAIL_TEMPLATE: %v", err)
+	} else {
+		for _, plain := range []bool{false, true} {
+			if _, err := emailService.RenderEmail(io.Discard, sampleFormData(), "https://example.com", models.EmailOptions{PlainText: plain}); err != nil {
+				fail("EMAIL_TEMPLATE: %v", err)
+			}
+		}
+	}
+	if statusTemplate, err := template.ParseFiles(cfg.StatusTemplate); err != nil {
+		fail("STATUS_TEMPLATE: %v", err)
+	} else if err := statusTemplate.Execute(io.Discard, sampleStatusPage(cfg, "success")); err != nil {
+		fail("STATUS_TEMPLATE: %v", err)
+	}
+	checkTemplate := func(name, path string) {
+		if _, err := template.ParseFiles(path); err != nil {
+			fail("%s: %v", name, err)
+		}
+	}
+	checkTemplate("HOSTED_FORM_TEMPLATE", cfg.HostedFormTemplate)
+	if cfg.EnableTestForm {
+		checkTemplate("TEST_FORM_TEMPLATE", cfg.TestFormTemplate)
+	}
+	if cfg.DevMode {
+		checkTemplate("DEV_MAIL_TEMPLATE", cfg.DevMailTemplate)
+	}
+	if cfg.StorePath != "" {
+		if _, err := handlers.ParseAdminTemplate(cfg); err != nil {
+			fail("ADMIN_TEMPLATE: %v", err)
+		}
+	}
+
+	// Forms in the database are validated when they are saved
+	if cfg.FormsFile != "" {
+		forms, err := config.LoadForms(cfg.FormsFile)
+		if err != nil {
+			fail("FORMS_FILE: %v", err)
+		}
+		for slug, form := range forms {
+			if form.Template == "" {
+				continue
+			}
+			if _, err := template.ParseFiles(form.Template); err != nil {
+				fail("FORMS_FILE: template of form %q: %v", slug, err)
+			}
+		}
+	}
+	return errs
+}
+
+// runPrintConfig writes the resolved settings with their sources and returns
+// the exit code
+func runPrintConfig(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	dev := flags.Bool("dev", false, "print the settings of development mode")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dev {
+		cfg.UseDevMode()
+	}
+	if err := cfg.Dump(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
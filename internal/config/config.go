@@ -1,11 +1,36 @@
 package config
 
 import (
+	"crypto/tls"
+	"log/slog"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"formfling/internal/logging"
+)
+
+// Form backends for Config.FormsBackend
+const (
+	FormsBackendFile = "file"
+	FormsBackendDB   = "db"
+)
+
+// Protocols of the inbound mail listener for Config.InboundProtocol
+const (
+	InboundSMTP = "smtp"
+	InboundLMTP = "lmtp"
 )
 
+// TLSVersions are the values of TLS_MIN_VERSION
+var TLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
 type Config struct {
 	Timezone           string
 	Port               string
@@ -28,76 +53,264 @@ type Config struct {
 	RecaptchaSecretKey string
 	RecaptchaMinScore  float64
 	RecaptchaAction    string
+	PowDifficulty      int
+	PowSecret          string
+	CSRFSecret         string
+	HostedFormTemplate string
+	AdminTemplate      string
+	DevMailTemplate    string
+	AdminUsername      string
+	AdminPassword      string
+	StorePath          string
+	AllowedCC          []string
+	AllowedNext        []string
+	FormsFile          string
+	FormsBackend       string
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCScopes         []string
+	OIDCAPIAudience    string
+	OIDCUsernameClaim  string
+	OIDCRoleClaim      string
+	OIDCRoleMapping    []string
+	OIDCDefaultRole    string
+	OIDCFormsClaim     string
+	InboundAddr        string
+	InboundProtocol    string
+	InboundDomain      string
+	InboundSecret      string
+	InboundMaxBytes    int
+	WatchInterval      time.Duration
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	ShutdownTimeout    time.Duration
+	MaxBodyBytes       int
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSMinVersion      string
+	TLSClientCAFile    string
+	HTTPRedirectPort   string
+	Listen             []string
+	UnixSocketMode     string
+	UnixSocketGroup    string
+	AdminListen        []string
+	AdminAllowedIPs    []string
+	TrustedProxies     []string
+	EnablePprof        bool
+	EnableMetrics      bool
+	LogFormat          string
+	LogLevel           string
+	AccessLog          bool
+	OTLPEndpoint       string
+	ReadyCheckInterval time.Duration
+	ReadyMaxInFlight   int
+	ReadyMaxFailed     int
+	Forms              map[string]*Form
+
+	// DevMode captures email instead of sending it; see UseDevMode
+	DevMode bool
+
+	// sources records where each setting came from, for Dump
+	sources map[string]string
+	// files are the config file and secret files the settings were read from
+	files []string
+
+	// formsMu guards Forms, which the API may change while serving requests
+	formsMu sync.RWMutex
 }
 
-func Load() *Config {
-	config := &Config{
-		Timezone:           getEnv("TZ", "UTC"),
-		Port:               getEnv("PORT", "8080"),
-		SMTPHost:           getEnv("SMTP_HOST", "smtp.gmail.com"),
-		SMTPPort:           getEnvAsInt("SMTP_PORT", 587),
-		SMTPUsername:       getEnv("SMTP_USERNAME", ""),
-		SMTPPassword:       getEnv("SMTP_PASSWORD", ""),
-		FromEmail:          getEnv("FROM_EMAIL", ""),
-		FromName:           getEnv("FROM_NAME", "FormFling"),
-		ToEmail:            getEnv("TO_EMAIL", ""),
-		ToName:             getEnv("TO_NAME", ""),
-		FormTitle:          getEnv("FORM_TITLE", "Contact Me"),
-		EmailTemplate:      getEnv("EMAIL_TEMPLATE", "./web/templates/email_template.html"),
-		StatusTemplate:     getEnv("STATUS_TEMPLATE", "./web/templates/status_template.html"),
-		TestFormTemplate:   getEnv("TEST_FORM_TEMPLATE", "./web/templates/test_form_template.html"),
-		EnableTestForm:     getEnvAsBool("ENABLE_TEST_FORM", false),
-		RecaptchaSiteKey:   getEnv("RECAPTCHA_SITE_KEY", ""),
-		RecaptchaSecretKey: getEnv("RECAPTCHA_SECRET_KEY", ""),
-		RecaptchaMinScore:  getEnvAsFloat("RECAPTCHA_MIN_SCORE", 0.5),
-		RecaptchaAction:    getEnv("RECAPTCHA_ACTION", "submit"),
-	}
+// setting describes one configuration value. Its environment variable is
+// Env and its key in the config file and in -set flags is Env in lower case.
+type setting struct {
+	Env     string
+	Default string
+	Secret  bool
+	field   func(c *Config) interface{}
+}
 
-	// Enable reCAPTCHA if secret key is provided
-	config.RecaptchaEnabled = config.RecaptchaSecretKey != ""
+// Key is the name of the setting in the config file
+func (s setting) Key() string {
+	return strings.ToLower(s.Env)
+}
+
+// settings is the schema of the configuration. The field functions return a
+// pointer to a string, int, float64, bool, time.Duration or []string field;
+// lists are comma separated in the environment and in flags.
+var settings = []setting{
+	{"TZ", "UTC", false, func(c *Config) interface{} { return &c.Timezone }},
+	{"PORT", "8080", false, func(c *Config) interface{} { return &c.Port }},
+	{"SMTP_HOST", "smtp.gmail.com", false, func(c *Config) interface{} { return &c.SMTPHost }},
+	{"SMTP_PORT", "587", false, func(c *Config) interface{} { return &c.SMTPPort }},
+	{"SMTP_USERNAME", "", false, func(c *Config) interface{} { return &c.SMTPUsername }},
+	{"SMTP_PASSWORD", "", true, func(c *Config) interface{} { return &c.SMTPPassword }},
+	{"FROM_EMAIL", "", false, func(c *Config) interface{} { return &c.FromEmail }},
+	{"FROM_NAME", "FormFling", false, func(c *Config) interface{} { return &c.FromName }},
+	{"TO_EMAIL", "", false, func(c *Config) interface{} { return &c.ToEmail }},
+	{"TO_NAME", "", false, func(c *Config) interface{} { return &c.ToName }},
+	{"ALLOWED_ORIGINS", "*", false, func(c *Config) interface{} { return &c.AllowedOrigins }},
+	{"FORM_TITLE", "Contact Me", false, func(c *Config) interface{} { return &c.FormTitle }},
+	{"EMAIL_TEMPLATE", "./web/templates/email_template.html", false, func(c *Config) interface{} { return &c.EmailTemplate }},
+	{"STATUS_TEMPLATE", "./web/templates/status_template.html", false, func(c *Config) interface{} { return &c.StatusTemplate }},
+	{"TEST_FORM_TEMPLATE", "./web/templates/test_form_template.html", false, func(c *Config) interface{} { return &c.TestFormTemplate }},
+	{"ENABLE_TEST_FORM", "false", false, func(c *Config) interface{} { return &c.EnableTestForm }},
+	{"RECAPTCHA_SITE_KEY", "", false, func(c *Config) interface{} { return &c.RecaptchaSiteKey }},
+	{"RECAPTCHA_SECRET_KEY", "", true, func(c *Config) interface{} { return &c.RecaptchaSecretKey }},
+	{"RECAPTCHA_MIN_SCORE", "0.5", false, func(c *Config) interface{} { return &c.RecaptchaMinScore }},
+	{"RECAPTCHA_ACTION", "submit", false, func(c *Config) interface{} { return &c.RecaptchaAction }},
+	{"POW_DIFFICULTY", "0", false, func(c *Config) interface{} { return &c.PowDifficulty }},
+	{"POW_SECRET", "", true, func(c *Config) interface{} { return &c.PowSecret }},
+	{"CSRF_SECRET", "", true, func(c *Config) interface{} { return &c.CSRFSecret }},
+	{"HOSTED_FORM_TEMPLATE", "./web/templates/form_template.html", false, func(c *Config) interface{} { return &c.HostedFormTemplate }},
+	{"ADMIN_TEMPLATE", "./web/templates/admin_template.html", false, func(c *Config) interface{} { return &c.AdminTemplate }},
+	{"DEV_MAIL_TEMPLATE", "./web/templates/devmail_template.html", false, func(c *Config) interface{} { return &c.DevMailTemplate }},
+	{"ADMIN_USERNAME", "admin", false, func(c *Config) interface{} { return &c.AdminUsername }},
+	{"ADMIN_PASSWORD", "", true, func(c *Config) interface{} { return &c.AdminPassword }},
+	{"STORE_PATH", "", false, func(c *Config) interface{} { return &c.StorePath }},
+	{"ALLOWED_CC", "", false, func(c *Config) interface{} { return &c.AllowedCC }},
+	{"ALLOWED_NEXT", "", false, func(c *Config) interface{} { return &c.AllowedNext }},
+	{"FORMS_FILE", "", false, func(c *Config) interface{} { return &c.FormsFile }},
+	{"FORMS_BACKEND", FormsBackendFile, false, func(c *Config) interface{} { return &c.FormsBackend }},
+	{"OIDC_ISSUER", "", false, func(c *Config) interface{} { return &c.OIDCIssuer }},
+	{"OIDC_CLIENT_ID", "", false, func(c *Config) interface{} { return &c.OIDCClientID }},
+	{"OIDC_CLIENT_SECRET", "", true, func(c *Config) interface{} { return &c.OIDCClientSecret }},
+	{"OIDC_REDIRECT_URL", "", false, func(c *Config) interface{} { return &c.OIDCRedirectURL }},
+	{"OIDC_SCOPES", "openid,profile,email", false, func(c *Config) interface{} { return &c.OIDCScopes }},
+	{"OIDC_API_AUDIENCE", "", false, func(c *Config) interface{} { return &c.OIDCAPIAudience }},
+	{"OIDC_USERNAME_CLAIM", "email", false, func(c *Config) interface{} { return &c.OIDCUsernameClaim }},
+	{"OIDC_ROLE_CLAIM", "groups", false, func(c *Config) interface{} { return &c.OIDCRoleClaim }},
+	{"OIDC_ROLE_MAPPING", "", false, func(c *Config) interface{} { return &c.OIDCRoleMapping }},
+	{"OIDC_DEFAULT_ROLE", "", false, func(c *Config) interface{} { return &c.OIDCDefaultRole }},
+	{"OIDC_FORMS_CLAIM", "", false, func(c *Config) interface{} { return &c.OIDCFormsClaim }},
+	{"INBOUND_ADDR", "", false, func(c *Config) interface{} { return &c.InboundAddr }},
+	{"INBOUND_PROTOCOL", InboundSMTP, false, func(c *Config) interface{} { return &c.InboundProtocol }},
+	{"INBOUND_DOMAIN", "", false, func(c *Config) interface{} { return &c.InboundDomain }},
+	{"INBOUND_SECRET", "", true, func(c *Config) interface{} { return &c.InboundSecret }},
+	{"INBOUND_MAX_BYTES", "10485760", false, func(c *Config) interface{} { return &c.InboundMaxBytes }},
+	{"WATCH_INTERVAL", "0s", false, func(c *Config) interface{} { return &c.WatchInterval }},
+	{"READ_HEADER_TIMEOUT", "5s", false, func(c *Config) interface{} { return &c.ReadHeaderTimeout }},
+	{"READ_TIMEOUT", "30s", false, func(c *Config) interface{} { return &c.ReadTimeout }},
+	{"WRITE_TIMEOUT", "60s", false, func(c *Config) interface{} { return &c.WriteTimeout }},
+	{"IDLE_TIMEOUT", "120s", false, func(c *Config) interface{} { return &c.IdleTimeout }},
+	{"SHUTDOWN_TIMEOUT", "30s", false, func(c *Config) interface{} { return &c.ShutdownTimeout }},
+	{"MAX_BODY_BYTES", "1048576", false, func(c *Config) interface{} { return &c.MaxBodyBytes }},
+	{"TLS_CERT_FILE", "", false, func(c *Config) interface{} { return &c.TLSCertFile }},
+	{"TLS_KEY_FILE", "", false, func(c *Config) interface{} { return &c.TLSKeyFile }},
+	{"TLS_MIN_VERSION", "1.2", false, func(c *Config) interface{} { return &c.TLSMinVersion }},
+	{"TLS_CLIENT_CA_FILE", "", false, func(c *Config) interface{} { return &c.TLSClientCAFile }},
+	{"HTTP_REDIRECT_PORT", "", false, func(c *Config) interface{} { return &c.HTTPRedirectPort }},
+	{"LISTEN", "", false, func(c *Config) interface{} { return &c.Listen }},
+	{"UNIX_SOCKET_MODE", "0660", false, func(c *Config) interface{} { return &c.UnixSocketMode }},
+	{"UNIX_SOCKET_GROUP", "", false, func(c *Config) interface{} { return &c.UnixSocketGroup }},
+	{"ADMIN_LISTEN", "", false, func(c *Config) interface{} { return &c.AdminListen }},
+	{"ADMIN_ALLOWED_IPS", "", false, func(c *Config) interface{} { return &c.AdminAllowedIPs }},
+	{"TRUSTED_PROXIES", "", false, func(c *Config) interface{} { return &c.TrustedProxies }},
+	{"ENABLE_PPROF", "false", false, func(c *Config) interface{} { return &c.EnablePprof }},
+	{"ENABLE_METRICS", "false", false, func(c *Config) interface{} { return &c.EnableMetrics }},
+	{"LOG_FORMAT", logging.FormatText, false, func(c *Config) interface{} { return &c.LogFormat }},
+	{"LOG_LEVEL", "info", false, func(c *Config) interface{} { return &c.LogLevel }},
+	{"ACCESS_LOG", "true", false, func(c *Config) interface{} { return &c.AccessLog }},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "", false, func(c *Config) interface{} { return &c.OTLPEndpoint }},
+	{"READY_CHECK_INTERVAL", "60s", false, func(c *Config) interface{} { return &c.ReadyCheckInterval }},
+	{"READY_MAX_IN_FLIGHT", "50", false, func(c *Config) interface{} { return &c.ReadyMaxInFlight }},
+	{"READY_MAX_FAILED", "0", false, func(c *Config) interface{} { return &c.ReadyMaxFailed }},
+}
 
-	// Parse allowed origins
-	allowedOriginsStr := getEnv("ALLOWED_ORIGINS", "*")
-	if allowedOriginsStr != "*" && allowedOriginsStr != "" {
-		config.AllowedOrigins = strings.Split(allowedOriginsStr, ",")
-		for i := range config.AllowedOrigins {
-			config.AllowedOrigins[i] = strings.TrimSpace(config.AllowedOrigins[i])
+// secretFileSuffix marks the variant of a secret setting that names a file
+// holding the secret, such as SMTP_PASSWORD_FILE
+const secretFileSuffix = "_FILE"
+
+// lookupSetting finds a setting by its file key or environment variable.
+// fromFile reports that the name is the *_FILE variant of a secret.
+func lookupSetting(name string) (setting, bool, bool) {
+	for _, s := range settings {
+		if strings.EqualFold(s.Env, name) {
+			return s, false, true
+		}
+		if s.Secret && strings.EqualFold(s.Env+secretFileSuffix, name) {
+			return s, true, true
 		}
 	}
+	return setting{}, false, false
+}
 
-	return config
+// TLSEnabled reports whether the HTTP server terminates TLS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// ListenAddrs are the addresses the HTTP server listens on: LISTEN, or
+// every interface on PORT
+func (c *Config) ListenAddrs() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
 	}
-	return defaultValue
+	return []string{":" + c.Port}
+}
+
+// AdminNetworks are the networks of ADMIN_ALLOWED_IPS. A single address
+// is a network of its own.
+func (c *Config) AdminNetworks() []netip.Prefix {
+	return parseNetworks(c.AdminAllowedIPs)
+}
+
+// ProxyNetworks are the networks of TRUSTED_PROXIES, whose X-Forwarded-For
+// headers are believed
+func (c *Config) ProxyNetworks() []netip.Prefix {
+	return parseNetworks(c.TrustedProxies)
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+func parseNetworks(entries []string) []netip.Prefix {
+	var networks []netip.Prefix
+	for _, entry := range entries {
+		if network, err := parseNetwork(entry); err == nil {
+			networks = append(networks, network)
 		}
 	}
-	return defaultValue
+	return networks
 }
 
-func getEnvAsFloat(key string, defaultValue float64) float64 {
-	if value := os.Getenv(key); value != "" {
-		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
-			return floatVal
-		}
+func parseNetwork(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		network, err := netip.ParsePrefix(entry)
+		return network.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
 	}
-	return defaultValue
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// SocketMode is UNIX_SOCKET_MODE as a file mode
+func (c *Config) SocketMode() os.FileMode {
+	mode, _ := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	return os.FileMode(mode)
 }
 
-func getEnvAsBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if boolVal, err := strconv.ParseBool(value); err == nil {
-			return boolVal
+// Level returns LOG_LEVEL as a slog level
+func (c *Config) Level() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// WatchedFiles lists the files the configuration was built from: the config
+// file, secret files, the forms file and every template. A change to any of
+// them calls for a reload.
+func (c *Config) WatchedFiles() []string {
+	files := append([]string{}, c.files...)
+	if c.FormsFile != "" {
+		files = append(files, c.FormsFile)
+	}
+	files = append(files, c.EmailTemplate, c.StatusTemplate, c.TestFormTemplate, c.HostedFormTemplate, c.AdminTemplate, c.DevMailTemplate)
+	for _, form := range c.AllForms() {
+		if form.Template != "" {
+			files = append(files, form.Template)
 		}
 	}
-	return defaultValue
+	return files
 }
@@ -2,7 +2,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -35,7 +38,10 @@ func TestLoad(t *testing.T) {
 	os.Unsetenv("RECAPTCHA_MIN_SCORE")
 	os.Unsetenv("RECAPTCHA_ACTION")
 
-	cfg := Load()
+	cfg, errs := Load(LoadOptions{})
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
 
 	if cfg.Port != "8080" {
 		t.Errorf("Expected port 8080, got %s", cfg.Port)
@@ -49,6 +55,14 @@ func TestLoad(t *testing.T) {
 		t.Errorf("Expected SMTP port 587, got %d", cfg.SMTPPort)
 	}
 
+	if cfg.ReadHeaderTimeout != 5*time.Second || cfg.WriteTimeout != time.Minute || cfg.ShutdownTimeout != 30*time.Second {
+		t.Errorf("Unexpected default timeouts %v, %v, %v", cfg.ReadHeaderTimeout, cfg.WriteTimeout, cfg.ShutdownTimeout)
+	}
+
+	if cfg.MaxBodyBytes != 1<<20 {
+		t.Errorf("Expected a default body limit of 1 MiB, got %d", cfg.MaxBodyBytes)
+	}
+
 	if cfg.EmailTemplate != "./web/templates/email_template.html" {
 		t.Errorf("Expected email template './web/templates/email_template.html', got %s", cfg.EmailTemplate)
 	}
@@ -80,7 +94,10 @@ func TestLoad(t *testing.T) {
 	os.Setenv("RECAPTCHA_MIN_SCORE", "0.8")
 	os.Setenv("RECAPTCHA_ACTION", "contact")
 
-	cfg = Load()
+	cfg, errs = Load(LoadOptions{})
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
 
 	if cfg.Port != "3000" {
 		t.Errorf("Expected port 3000, got %s", cfg.Port)
@@ -124,65 +141,277 @@ func TestLoad(t *testing.T) {
 	}
 }
 
-func TestGetEnv(t *testing.T) {
-	result := getEnv("NONEXISTENT_VAR", "default")
-	if result != "default" {
-		t.Errorf("Expected 'default', got %s", result)
+func TestLoad_InvalidValues(t *testing.T) {
+	t.Setenv("SMTP_PORT", "58x7")
+	t.Setenv("RECAPTCHA_MIN_SCORE", "high")
+	t.Setenv("ENABLE_TEST_FORM", "yes please")
+
+	_, errs := Load(LoadOptions{})
+	want := []string{
+		`SMTP_PORT: expected a whole number, got "58x7"`,
+		`ENABLE_TEST_FORM: expected true or false, got "yes please"`,
+		`RECAPTCHA_MIN_SCORE: expected a number, got "high"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %v", len(want), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("Expected error %q, got %q", want[i], err)
+		}
 	}
+}
 
-	os.Setenv("TEST_VAR", "test_value")
-	defer os.Unsetenv("TEST_VAR")
+func TestLoad_File(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"formfling.yaml": `
+smtp_host: smtp.example.com
+smtp_port: 2525
+from_email: forms@example.com
+allowed_origins:
+  - https://example.com
+  - https://www.example.com
+enable_test_form: true
+`,
+		"formfling.toml": `
+smtp_host = "smtp.example.com"
+smtp_port = 2525
+from_email = "forms@example.com"
+allowed_origins = ["https://example.com", "https://www.example.com"]
+enable_test_form = true
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("SMTP_HOST", "")
+			t.Setenv("FROM_EMAIL", "env@example.com")
+
+			cfg, errs := Load(LoadOptions{File: path, Flags: map[string]string{"from_email": "flag@example.com"}})
+			if len(errs) > 0 {
+				t.Fatalf("Load returned errors: %v", errs)
+			}
+			if cfg.SMTPHost != "smtp.example.com" || cfg.SMTPPort != 2525 || !cfg.EnableTestForm {
+				t.Errorf("Expected the file's SMTP settings, got %s:%d", cfg.SMTPHost, cfg.SMTPPort)
+			}
+			if len(cfg.AllowedOrigins) != 2 || cfg.AllowedOrigins[1] != "https://www.example.com" {
+				t.Errorf("Expected the file's origins, got %v", cfg.AllowedOrigins)
+			}
+			if cfg.FromEmail != "flag@example.com" {
+				t.Errorf("Expected the flag to win over the environment and file, got %s", cfg.FromEmail)
+			}
+			if cfg.FormTitle != "Contact Me" {
+				t.Errorf("Expected the default form title, got %s", cfg.FormTitle)
+			}
+		})
+	}
+
+	t.Run("environment over file", func(t *testing.T) {
+		path := filepath.Join(dir, "env.yaml")
+		if err := os.WriteFile(path, []byte("from_email: file@example.com\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("FROM_EMAIL", "env@example.com")
+		t.Setenv(ConfigFileEnv, path)
+
+		cfg, errs := Load(LoadOptions{})
+		if len(errs) > 0 || cfg.FromEmail != "env@example.com" {
+			t.Errorf("Expected the environment to win over %s, got %s %v", ConfigFileEnv, cfg.FromEmail, errs)
+		}
+	})
+}
+
+func TestLoad_FileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"errors.yaml", "smtp_port: abc\nsmtp_prot: 25\nfrom_email:\n  - a@example.com\n", []string{
+			`errors.yaml:2:1: smtp_prot: unknown setting`,
+			`errors.yaml:1:1: smtp_port: expected a whole number, got "abc"`,
+			`errors.yaml:3:1: from_email: expected a single value, not a list`,
+		}},
+		{"errors.toml", "from_name = \"Forms\"\nsmtp_port = \"abc\"\nSMTP_HOST = \"x\"\n", []string{
+			`errors.toml:3: SMTP_HOST: unknown setting`,
+			`errors.toml:2: smtp_port: expected a whole number, got "abc"`,
+		}},
+		{"syntax.toml", "smtp_port = \n", []string{"syntax.toml: line 2"}},
+		{"formfling.json", "{}", []string{"config files must end in .yaml, .yml or .toml"}},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, errs := Load(LoadOptions{File: path})
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Expected %d errors, got %v", len(tt.want), errs)
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), tt.want[i]) {
+					t.Errorf("Expected error containing %q, got %q", tt.want[i], err)
+				}
+			}
+		})
+	}
 
-	result = getEnv("TEST_VAR", "default")
-	if result != "test_value" {
-		t.Errorf("Expected 'test_value', got %s", result)
+	_, errs := Load(LoadOptions{Flags: map[string]string{"smtp_prot": "25"}})
+	if len(errs) != 1 || errs[0].Error() != "-set smtp_prot: unknown setting" {
+		t.Errorf("Expected an unknown flag setting to be reported, got %v", errs)
 	}
 }
 
-func TestGetEnvAsInt(t *testing.T) {
-	result := getEnvAsInt("NONEXISTENT_VAR", 123)
-	if result != 123 {
-		t.Errorf("Expected 123, got %d", result)
+func TestDump(t *testing.T) {
+	t.Setenv("SMTP_PASSWORD", "hunter2")
+	t.Setenv("SMTP_PORT", "465")
+
+	cfg, errs := Load(LoadOptions{Flags: map[string]string{"to_email": "team@example.com"}})
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	var out strings.Builder
+	if err := cfg.Dump(&out); err != nil {
+		t.Fatal(err)
+	}
+	dump := out.String()
+
+	for _, want := range []string{
+		`smtp_password: "<redacted>" # env SMTP_PASSWORD`,
+		`smtp_port: 465 # env SMTP_PORT`,
+		`to_email: "team@example.com" # flag -set`,
+		`csrf_secret: "" # default`,
+		`oidc_scopes: ["openid","profile","email"] # default`,
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("Expected the dump to contain %q, got:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "hunter2") {
+		t.Error("Expected the secret to be redacted")
 	}
+}
 
-	os.Setenv("TEST_INT_VAR", "456")
-	defer os.Unsetenv("TEST_INT_VAR")
+func TestLoad_SecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	smtpPassword := write("smtp_password", "hunter2\n")
+	csrfSecret := write("csrf_secret", "from-a-mount")
+	configFile := write("formfling.yaml", "csrf_secret_file: "+csrfSecret+"\nrecaptcha_secret_key: from-the-file\n")
+
+	t.Setenv("SMTP_PASSWORD_FILE", smtpPassword)
+	cfg, errs := Load(LoadOptions{File: configFile, Flags: map[string]string{"recaptcha_secret_key_file": smtpPassword}})
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	if cfg.SMTPPassword != "hunter2" {
+		t.Errorf("Expected the password without its newline, got %q", cfg.SMTPPassword)
+	}
+	if cfg.CSRFSecret != "from-a-mount" {
+		t.Errorf("Expected the CSRF secret from the file named in the config file, got %q", cfg.CSRFSecret)
+	}
+	if cfg.RecaptchaSecretKey != "hunter2" || !cfg.RecaptchaEnabled {
+		t.Errorf("Expected the flag's secret file to win over the config file, got %q", cfg.RecaptchaSecretKey)
+	}
 
-	result = getEnvAsInt("TEST_INT_VAR", 123)
-	if result != 456 {
-		t.Errorf("Expected 456, got %d", result)
+	var out strings.Builder
+	if err := cfg.Dump(&out); err != nil {
+		t.Fatal(err)
+	}
+	if dump := out.String(); strings.Contains(dump, "hunter2") || strings.Contains(dump, "from-a-mount") ||
+		!strings.Contains(dump, `smtp_password: "<redacted>" # env SMTP_PASSWORD_FILE`) {
+		t.Errorf("Expected redacted secrets with their sources, got:\n%s", dump)
 	}
 
-	// Test invalid int
-	os.Setenv("TEST_INVALID_INT", "not_a_number")
-	defer os.Unsetenv("TEST_INVALID_INT")
+	// A secret is re-read each time the configuration is loaded
+	write("smtp_password", "rotated\n")
+	if cfg, _ := Load(LoadOptions{}); cfg.SMTPPassword != "rotated" {
+		t.Errorf("Expected the rotated password, got %q", cfg.SMTPPassword)
+	}
 
-	result = getEnvAsInt("TEST_INVALID_INT", 789)
-	if result != 789 {
-		t.Errorf("Expected 789 (default), got %d", result)
+	t.Setenv("SMTP_PASSWORD", "plain")
+	t.Setenv("POW_SECRET_FILE", filepath.Join(dir, "missing"))
+	_, errs = Load(LoadOptions{Flags: map[string]string{"from_email_file": "x"}})
+	want := []string{
+		"SMTP_PASSWORD_FILE: conflicts with SMTP_PASSWORD",
+		"POW_SECRET_FILE: open " + filepath.Join(dir, "missing"),
+		"-set from_email_file: unknown setting",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %v", len(want), errs)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("Expected error containing %q, got %q", want[i], err)
+		}
 	}
 }
 
-func TestGetEnvAsFloat(t *testing.T) {
-	result := getEnvAsFloat("NONEXISTENT_VAR", 1.23)
-	if result != 1.23 {
-		t.Errorf("Expected 1.23, got %f", result)
+func TestLoad_WatchedFiles(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "csrf_secret")
+	if err := os.WriteFile(secret, []byte("s3cret"), 0o600); err != nil {
+		t.Fatal(err)
 	}
+	configFile := filepath.Join(dir, "formfling.toml")
+	if err := os.WriteFile(configFile, []byte("watch_interval = \"2s\"\nforms_file = \"forms.json\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSRF_SECRET_FILE", secret)
 
-	os.Setenv("TEST_FLOAT_VAR", "4.56")
-	defer os.Unsetenv("TEST_FLOAT_VAR")
+	cfg, errs := Load(LoadOptions{File: configFile})
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	if cfg.WatchInterval != 2*time.Second {
+		t.Errorf("Expected a watch interval of 2s, got %v", cfg.WatchInterval)
+	}
+	cfg.SetForms(map[string]*Form{"careers": {Slug: "careers", Template: "careers.html"}})
+
+	files := strings.Join(cfg.WatchedFiles(), ",")
+	for _, want := range []string{configFile, secret, "forms.json", cfg.EmailTemplate, "careers.html"} {
+		if !strings.Contains(files, want) {
+			t.Errorf("Expected %s to be watched, got %s", want, files)
+		}
+	}
 
-	result = getEnvAsFloat("TEST_FLOAT_VAR", 1.23)
-	if result != 4.56 {
-		t.Errorf("Expected 4.56, got %f", result)
+	if _, errs := Load(LoadOptions{Flags: map[string]string{"watch_interval": "often"}}); len(errs) != 1 {
+		t.Errorf("Expected a malformed duration to be reported, got %v", errs)
 	}
+}
 
-	// Test invalid float
-	os.Setenv("TEST_INVALID_FLOAT", "not_a_number")
-	defer os.Unsetenv("TEST_INVALID_FLOAT")
+func TestAdminNetworks(t *testing.T) {
+	cfg := &Config{AdminAllowedIPs: []string{"10.1.2.3/8", "192.168.1.5", "::ffff:127.0.0.1", "2001:db8::/32"}}
+	var got []string
+	for _, network := range cfg.AdminNetworks() {
+		got = append(got, network.String())
+	}
+	want := "10.0.0.0/8 192.168.1.5/32 127.0.0.1/32 2001:db8::/32"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Expected networks %s, got %v", want, got)
+	}
+}
 
-	result = getEnvAsFloat("TEST_INVALID_FLOAT", 7.89)
-	if result != 7.89 {
-		t.Errorf("Expected 7.89 (default), got %f", result)
+func TestProxyNetworks(t *testing.T) {
+	cfg := &Config{TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"}}
+	var got []string
+	for _, network := range cfg.ProxyNetworks() {
+		got = append(got, network.String())
+	}
+	want := "127.0.0.1/32 10.0.0.0/8"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Expected networks %s, got %v", want, got)
 	}
 }
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Redacted replaces the values of secret settings in dumps
+const Redacted = "<redacted>"
+
+// Dump writes the resolved settings as a YAML config file, noting where each
+// came from. Secrets are redacted.
+func (c *Config) Dump(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "# Resolved FormFling configuration; secrets are redacted"); err != nil {
+		return err
+	}
+	for _, s := range settings {
+		var v interface{}
+		switch field := s.field(c).(type) {
+		case *string:
+			v = *field
+		case *int:
+			v = *field
+		case *float64:
+			v = *field
+		case *bool:
+			v = *field
+		case *time.Duration:
+			v = field.String()
+		case *[]string:
+			v = *field
+			if *field == nil {
+				v = []string{}
+			}
+		}
+		if s.Secret && v != "" {
+			v = Redacted
+		}
+		var encoded bytes.Buffer
+		enc := json.NewEncoder(&encoded)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+
+		source := c.sources[s.Key()]
+		if source == "" {
+			source = "default"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s # %s\n", s.Key(), bytes.TrimSpace(encoded.Bytes()), source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
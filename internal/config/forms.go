@@ -0,0 +1,265 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"formfling/internal/models"
+)
+
+// DefaultFormSlug identifies the form built from the environment settings. It
+// is the form used by the plain /submit endpoint.
+const DefaultFormSlug = "default"
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// fieldTypes lists the input types a field definition may use
+var fieldTypes = map[string]bool{
+	"text": true, "email": true, "tel": true, "url": true, "number": true,
+	"date": true, "textarea": true, "select": true, "radio": true, "checkbox": true,
+}
+
+// DefaultFields describes the built-in contact form. Forms without their own
+// field definitions are rendered and validated with it.
+var DefaultFields = []models.FieldDefinition{
+	{Name: "name", Label: "Name", Type: "text", Required: true},
+	{Name: "email", Label: "Email", Type: "email", Required: true},
+	{Name: "subject", Label: "Subject", Type: "text"},
+	{Name: "message", Label: "Message", Type: "textarea", Required: true, MinLength: 300},
+}
+
+// Form holds the settings of a single form
+type Form struct {
+	Slug        string                   `json:"slug"`
+	Title       string                   `json:"title"`
+	Description string                   `json:"description"`
+	SubmitLabel string                   `json:"submit_label"`
+	ToEmail     string                   `json:"to_email"`
+	ToName      string                   `json:"to_name"`
+	AllowedCC   []string                 `json:"allowed_cc"`
+	AllowedNext []string                 `json:"allowed_next"`
+	Fields      []models.FieldDefinition `json:"fields"`
+	Theme       Theme                    `json:"theme"`
+	Template    string                   `json:"template"`
+	RequireCSRF bool                     `json:"require_csrf"`
+	// MaxBodyBytes limits the size of submissions; MAX_BODY_BYTES when 0
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+}
+
+// Theme customizes the look of a hosted form page
+type Theme struct {
+	AccentColor     string `json:"accent_color"`
+	BackgroundColor string `json:"background_color"`
+	TextColor       string `json:"text_color"`
+	LogoURL         string `json:"logo_url"`
+	StylesheetURL   string `json:"stylesheet_url"`
+}
+
+// FieldDefinitions returns the form's fields, falling back to DefaultFields
+func (f *Form) FieldDefinitions() []models.FieldDefinition {
+	if len(f.Fields) > 0 {
+		return f.Fields
+	}
+	return DefaultFields
+}
+
+// LoadForms reads the forms file, a JSON array of form definitions
+func LoadForms(path string) (map[string]*Form, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forms file: %v", err)
+	}
+
+	var list []*Form
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse forms file %s: %v", path, err)
+	}
+
+	forms := make(map[string]*Form, len(list))
+	for i, form := range list {
+		if !slugPattern.MatchString(form.Slug) {
+			return nil, fmt.Errorf("forms file %s: form #%d has invalid slug %q", path, i+1, form.Slug)
+		}
+		if _, exists := forms[form.Slug]; exists {
+			return nil, fmt.Errorf("forms file %s: duplicate form slug %q", path, form.Slug)
+		}
+		if err := ValidateForm(form); err != nil {
+			return nil, fmt.Errorf("forms file %s: form %q: %v", path, form.Slug, err)
+		}
+		forms[form.Slug] = form
+	}
+
+	return forms, nil
+}
+
+// ValidateForm checks a form definition, filling in default field types and
+// labels
+func ValidateForm(form *Form) error {
+	if !slugPattern.MatchString(form.Slug) {
+		return fmt.Errorf("invalid slug %q", form.Slug)
+	}
+	if form.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes must not be negative")
+	}
+	return validateFields(form.Fields)
+}
+
+func validateFields(fields []models.FieldDefinition) error {
+	seen := make(map[string]bool, len(fields))
+	for i := range fields {
+		field := &fields[i]
+		if field.Name == "" || strings.HasPrefix(field.Name, "_") || field.Name == "g-recaptcha-response" {
+			return fmt.Errorf("field #%d has invalid name %q", i+1, field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("duplicate field %q", field.Name)
+		}
+		seen[field.Name] = true
+
+		if field.Type == "" {
+			field.Type = "text"
+		}
+		if !fieldTypes[field.Type] {
+			return fmt.Errorf("field %q has unknown type %q", field.Name, field.Type)
+		}
+		if (field.Type == "select" || field.Type == "radio") && len(field.Options) == 0 {
+			return fmt.Errorf("field %q of type %s needs options", field.Name, field.Type)
+		}
+		if field.Label == "" {
+			field.Label = field.Name
+		}
+	}
+	return nil
+}
+
+// DefaultForm returns the form described by the environment settings
+func (c *Config) DefaultForm() *Form {
+	c.formsMu.RLock()
+	form, ok := c.Forms[DefaultFormSlug]
+	c.formsMu.RUnlock()
+	if ok {
+		return form
+	}
+	return &Form{
+		Slug:        DefaultFormSlug,
+		Title:       c.FormTitle,
+		ToEmail:     c.ToEmail,
+		ToName:      c.ToName,
+		AllowedCC:   c.AllowedCC,
+		AllowedNext: c.AllowedNext,
+	}
+}
+
+// Form looks up a form by its slug
+func (c *Config) Form(slug string) (*Form, bool) {
+	c.formsMu.RLock()
+	form, ok := c.Forms[slug]
+	c.formsMu.RUnlock()
+	if ok {
+		return form, true
+	}
+	if slug == DefaultFormSlug {
+		return c.DefaultForm(), true
+	}
+	return nil, false
+}
+
+// AllForms returns every configured form sorted by slug, always including the
+// default form first
+func (c *Config) AllForms() []*Form {
+	c.formsMu.RLock()
+	forms := make([]*Form, 0, len(c.Forms)+1)
+	for slug, form := range c.Forms {
+		if slug != DefaultFormSlug {
+			forms = append(forms, form)
+		}
+	}
+	c.formsMu.RUnlock()
+
+	sort.Slice(forms, func(i, j int) bool { return forms[i].Slug < forms[j].Slug })
+	return append([]*Form{c.DefaultForm()}, forms...)
+}
+
+// SetForms replaces all forms
+func (c *Config) SetForms(forms map[string]*Form) {
+	c.formsMu.Lock()
+	defer c.formsMu.Unlock()
+	c.Forms = forms
+}
+
+// PutForm adds or replaces a single form
+func (c *Config) PutForm(form *Form) {
+	c.formsMu.Lock()
+	defer c.formsMu.Unlock()
+	if c.Forms == nil {
+		c.Forms = make(map[string]*Form)
+	}
+	c.Forms[form.Slug] = form
+}
+
+// RemoveForm deletes a form
+func (c *Config) RemoveForm(slug string) {
+	c.formsMu.Lock()
+	defer c.formsMu.Unlock()
+	delete(c.Forms, slug)
+}
+
+// AllowsCC reports whether the address may be copied on notifications. Entries
+// are either full addresses or domains written as "@example.com".
+func (f *Form) AllowsCC(address string) bool {
+	address = strings.ToLower(strings.TrimSpace(address))
+	for _, allowed := range f.AllowedCC {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if strings.HasPrefix(allowed, "@") {
+			if strings.HasSuffix(address, allowed) {
+				return true
+			}
+		} else if address == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsNext reports whether the user may be redirected to the URL after a
+// successful submission. Entries are either URL prefixes such as
+// "https://example.com/thanks" or bare host names.
+func (f *Form) AllowsNext(target *url.URL) bool {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return false
+	}
+	for _, allowed := range f.AllowedNext {
+		allowed = strings.TrimSpace(allowed)
+		if strings.Contains(allowed, "://") {
+			prefix, err := url.Parse(allowed)
+			if err != nil {
+				continue
+			}
+			if strings.EqualFold(prefix.Scheme, target.Scheme) &&
+				strings.EqualFold(prefix.Host, target.Host) &&
+				withinPath(target.Path, prefix.Path) {
+				return true
+			}
+		} else if strings.EqualFold(allowed, target.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// withinPath reports whether p is the prefix path or below it, whole
+// segments at a time, so "/thanks" allows "/thanks/page" but not
+// "/thanks-evil". Dot segments are resolved first, as the browser would.
+func withinPath(p, prefix string) bool {
+	if p != "" {
+		p = path.Clean(p)
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
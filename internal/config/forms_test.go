@@ -0,0 +1,190 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadForms(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "forms.json")
+	content := `[
+		{"slug": "contact", "title": "Contact", "to_email": "team@example.com", "allowed_cc": ["@example.com"]},
+		{"slug": "careers", "title": "Careers", "allowed_next": ["https://example.com/thanks"]}
+	]`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	forms, err := LoadForms(path)
+	if err != nil {
+		t.Fatalf("LoadForms returned error: %v", err)
+	}
+
+	if len(forms) != 2 {
+		t.Fatalf("Expected 2 forms, got %d", len(forms))
+	}
+
+	if forms["contact"].ToEmail != "team@example.com" {
+		t.Errorf("Expected contact form to_email 'team@example.com', got %s", forms["contact"].ToEmail)
+	}
+
+	if len(forms["careers"].AllowedNext) != 1 {
+		t.Errorf("Expected careers form to have 1 allowed next URL, got %d", len(forms["careers"].AllowedNext))
+	}
+}
+
+func TestLoadForms_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid JSON", `{"slug":`},
+		{"invalid slug", `[{"slug": "Not A Slug"}]`},
+		{"duplicate slug", `[{"slug": "contact"}, {"slug": "contact"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "forms.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadForms(path); err == nil {
+				t.Error("Expected LoadForms to return an error")
+			}
+		})
+	}
+
+	if _, err := LoadForms(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected LoadForms to fail for a missing file")
+	}
+}
+
+func TestConfig_Form(t *testing.T) {
+	cfg := &Config{
+		FormTitle: "Contact Me",
+		ToEmail:   "me@example.com",
+		AllowedCC: []string{"copy@example.com"},
+		Forms: map[string]*Form{
+			"sales": {Slug: "sales", Title: "Sales"},
+		},
+	}
+
+	form, ok := cfg.Form(DefaultFormSlug)
+	if !ok {
+		t.Fatal("Expected default form to exist")
+	}
+	if form.Title != "Contact Me" || form.ToEmail != "me@example.com" {
+		t.Errorf("Expected default form to use env settings, got %+v", form)
+	}
+	if !form.AllowsCC("copy@example.com") {
+		t.Error("Expected default form to use ALLOWED_CC")
+	}
+
+	if form, ok := cfg.Form("sales"); !ok || form.Title != "Sales" {
+		t.Errorf("Expected sales form, got %+v", form)
+	}
+
+	if _, ok := cfg.Form("missing"); ok {
+		t.Error("Expected missing form lookup to fail")
+	}
+}
+
+func TestForm_AllowsCC(t *testing.T) {
+	form := &Form{AllowedCC: []string{"boss@example.com", "@partner.org"}}
+
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{"boss@example.com", true},
+		{"Boss@Example.com", true},
+		{"other@example.com", false},
+		{"anyone@partner.org", true},
+		{"anyone@evilpartner.org", false},
+	}
+
+	for _, tt := range tests {
+		if result := form.AllowsCC(tt.address); result != tt.expected {
+			t.Errorf("AllowsCC(%q) = %v, expected %v", tt.address, result, tt.expected)
+		}
+	}
+}
+
+func TestForm_AllowsNext(t *testing.T) {
+	form := &Form{AllowedNext: []string{"https://example.com/thanks", "https://example.com/done/", "https://example.net", "www.example.org"}}
+
+	tests := []struct {
+		target   string
+		expected bool
+	}{
+		{"https://example.com/thanks", true},
+		{"https://example.com/thanks/page?x=1", true},
+		{"https://example.com/thanks/", true},
+		{"https://example.com/other", false},
+		{"https://example.com/thanks-evil", false},
+		{"https://example.com/thanksx/", false},
+		{"https://example.com/thanks/../admin", false},
+		{"https://example.com/thanks/%2e%2e/admin", false},
+		{"https://example.com/done/", true},
+		{"https://example.com/done/page", true},
+		{"https://example.com/doneevil", false},
+		{"https://example.net", true},
+		{"https://example.net/anything", true},
+		{"http://example.com/thanks", false},
+		{"https://www.example.org/anything", true},
+		{"https://evil.com/thanks", false},
+		{"javascript:alert(1)", false},
+	}
+
+	for _, tt := range tests {
+		target, err := url.Parse(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := form.AllowsNext(target); result != tt.expected {
+			t.Errorf("AllowsNext(%q) = %v, expected %v", tt.target, result, tt.expected)
+		}
+	}
+}
+
+func TestLoadForms_Fields(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid fields", `[{"slug": "a", "fields": [{"name": "company"}, {"name": "plan", "type": "select", "options": ["A"]}]}]`, false},
+		{"reserved name", `[{"slug": "a", "fields": [{"name": "_next"}]}]`, true},
+		{"duplicate field", `[{"slug": "a", "fields": [{"name": "x"}, {"name": "x"}]}]`, true},
+		{"unknown type", `[{"slug": "a", "fields": [{"name": "x", "type": "color"}]}]`, true},
+		{"select without options", `[{"slug": "a", "fields": [{"name": "x", "type": "select"}]}]`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "forms.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			forms, err := LoadForms(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadForms error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				field := forms["a"].Fields[0]
+				if field.Type != "text" || field.Label != "company" {
+					t.Errorf("Expected type and label defaults, got %+v", field)
+				}
+			}
+		})
+	}
+
+	form := &Form{}
+	if len(form.FieldDefinitions()) != len(DefaultFields) {
+		t.Error("Expected forms without fields to use DefaultFields")
+	}
+}
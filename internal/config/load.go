@@ -0,0 +1,340 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file when no -config flag is given
+const ConfigFileEnv = "FORMFLING_CONFIG"
+
+// LoadOptions are the sources of settings besides the environment
+type LoadOptions struct {
+	// File is a YAML or TOML config file. FORMFLING_CONFIG is used when it
+	// is empty.
+	File string
+	// Flags are settings from the command line by key, as given with -set
+	Flags map[string]string
+}
+
+// value is a setting as read from one source
+type value struct {
+	text   string
+	list   []string
+	isList bool
+	name   string // the key or variable as written
+	where  string // names the value in errors
+	source string // names the source in dumps
+
+	// fromFile means text is the path of a file holding the secret
+	fromFile bool
+}
+
+// sourceValues are the values of one source by setting key
+type sourceValues map[string]value
+
+// add records a value. A source may give a secret or its *_FILE variant, but
+// not both.
+func (values sourceValues) add(s setting, v value) error {
+	if prev, ok := values[s.Key()]; ok {
+		return fmt.Errorf("%s: conflicts with %s", v.where, prev.name)
+	}
+	values[s.Key()] = v
+	return nil
+}
+
+// Load resolves the configuration. Flags take precedence over environment
+// variables, which take precedence over the config file, which takes
+// precedence over the defaults. Every unknown key and malformed value is
+// reported.
+func Load(opts LoadOptions) (*Config, []error) {
+	config := &Config{}
+	var errs []error
+
+	for _, s := range settings {
+		if err := config.apply(s, value{text: s.Default, source: "default"}); err != nil {
+			panic(fmt.Sprintf("bad default for %s: %v", s.Env, err))
+		}
+	}
+
+	file := opts.File
+	if file == "" {
+		file = os.Getenv(ConfigFileEnv)
+	}
+	if file != "" {
+		config.files = append(config.files, file)
+		values, fileErrs := readFile(file)
+		errs = append(errs, fileErrs...)
+		errs = append(errs, config.applyAll(values)...)
+	}
+
+	values := make(sourceValues)
+	for _, s := range settings {
+		names := []string{s.Env}
+		if s.Secret {
+			names = append(names, s.Env+secretFileSuffix)
+		}
+		for _, name := range names {
+			if text := os.Getenv(name); text != "" {
+				v := value{text: text, name: name, where: name, source: "env " + name, fromFile: name != s.Env}
+				if err := values.add(s, v); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	errs = append(errs, config.applyAll(values)...)
+
+	values = make(sourceValues)
+	for _, name := range sortedKeys(opts.Flags) {
+		s, fromFile, ok := lookupSetting(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("-set %s: unknown setting", name))
+			continue
+		}
+		v := value{text: opts.Flags[name], name: name, where: "-set " + name, source: "flag -set " + strings.ToLower(name), fromFile: fromFile}
+		if err := values.add(s, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, config.applyAll(values)...)
+
+	// An asterisk, like an empty list, allows every origin
+	if len(config.AllowedOrigins) == 1 && config.AllowedOrigins[0] == "*" {
+		config.AllowedOrigins = nil
+	}
+
+	// Enable reCAPTCHA if secret key is provided
+	config.RecaptchaEnabled = config.RecaptchaSecretKey != ""
+
+	return config, errs
+}
+
+// applyAll sets the values in schema order, so errors come out in a stable
+// order
+func (c *Config) applyAll(values sourceValues) []error {
+	var errs []error
+	for _, s := range settings {
+		if v, ok := values[s.Key()]; ok {
+			if err := c.apply(s, v); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// apply parses a value into the setting's field
+func (c *Config) apply(s setting, v value) error {
+	if v.fromFile {
+		c.files = append(c.files, strings.TrimSpace(v.text))
+		secret, err := readSecret(v.text)
+		if err != nil {
+			return fmt.Errorf("%s: %v", v.where, err)
+		}
+		v.text = secret
+	}
+
+	text := strings.TrimSpace(v.text)
+	switch field := s.field(c).(type) {
+	case *[]string:
+		items := v.list
+		if !v.isList {
+			items = strings.Split(text, ",")
+		}
+		var list []string
+		for _, item := range items {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field = list
+		c.setSource(s.Key(), v.source)
+		return nil
+	default:
+		if v.isList {
+			return fmt.Errorf("%s: expected a single value, not a list", v.where)
+		}
+	}
+
+	switch field := s.field(c).(type) {
+	case *string:
+		*field = v.text
+	case *int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%s: expected a whole number, got %q", v.where, v.text)
+		}
+		*field = n
+	case *float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%s: expected a number, got %q", v.where, v.text)
+		}
+		*field = f
+	case *bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%s: expected true or false, got %q", v.where, v.text)
+		}
+		*field = b
+	case *time.Duration:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%s: expected a duration such as 30s or 5m, got %q", v.where, v.text)
+		}
+		*field = d
+	}
+	c.setSource(s.Key(), v.source)
+	return nil
+}
+
+// readFile reads the settings of a YAML or TOML config file by key
+func readFile(path string) (sourceValues, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("error reading config file: %v", err)}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return readYAML(path, data)
+	case ".toml":
+		return readTOML(path, data)
+	}
+	return nil, []error{fmt.Errorf("%s: config files must end in .yaml, .yml or .toml", path)}
+}
+
+func readYAML(path string, data []byte) (sourceValues, []error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", path, err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil // an empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{fmt.Errorf("%s:%d:%d: expected a mapping of settings", path, root.Line, root.Column)}
+	}
+
+	values := make(sourceValues)
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		where := fmt.Sprintf("%s:%d:%d: %s", path, keyNode.Line, keyNode.Column, keyNode.Value)
+		s, fromFile, ok := lookupSetting(keyNode.Value)
+		if !ok || keyNode.Value != fileKey(s, fromFile) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting", where))
+			continue
+		}
+
+		v := value{name: keyNode.Value, where: where, source: fmt.Sprintf("file %s:%d", path, keyNode.Line), fromFile: fromFile}
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			if valueNode.Tag == "!!null" {
+				continue
+			}
+			v.text = valueNode.Value
+		case yaml.SequenceNode:
+			v.isList = true
+			for _, item := range valueNode.Content {
+				if item.Kind != yaml.ScalarNode {
+					errs = append(errs, fmt.Errorf("%s: list items must be single values", where))
+					break
+				}
+				v.list = append(v.list, item.Value)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: expected a value or a list", where))
+			continue
+		}
+		if err := values.add(s, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return values, errs
+}
+
+func readTOML(path string, data []byte) (sourceValues, []error) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "toml: "))}
+	}
+
+	values := make(sourceValues)
+	var errs []error
+	for _, key := range sortedKeys(doc) {
+		where := fmt.Sprintf("%s:%d: %s", path, tomlLine(data, key), key)
+		s, fromFile, ok := lookupSetting(key)
+		if !ok || key != fileKey(s, fromFile) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting", where))
+			continue
+		}
+
+		v := value{name: key, where: where, source: fmt.Sprintf("file %s:%d", path, tomlLine(data, key)), fromFile: fromFile}
+		switch raw := doc[key].(type) {
+		case []interface{}:
+			v.isList = true
+			for _, item := range raw {
+				v.list = append(v.list, fmt.Sprint(item))
+			}
+		case map[string]interface{}, []map[string]interface{}:
+			errs = append(errs, fmt.Errorf("%s: expected a value or a list, not a table", where))
+			continue
+		default:
+			v.text = fmt.Sprint(raw)
+		}
+		if err := values.add(s, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return values, errs
+}
+
+// fileKey is the key of a setting, or of its *_FILE variant, in config files
+func fileKey(s setting, fromFile bool) string {
+	if fromFile {
+		return strings.ToLower(s.Env + secretFileSuffix)
+	}
+	return s.Key()
+}
+
+// readSecret reads a secret from a file such as a Docker or Kubernetes secret
+// mount. The trailing newline most editors add is not part of the secret.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(strings.TrimSpace(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// tomlLine finds the line of a top-level key, as the TOML decoder does not
+// report where keys are
+func tomlLine(data []byte, key string) int {
+	pattern := regexp.MustCompile(`^\s*["']?` + regexp.QuoteMeta(key) + `["']?\s*=`)
+	for i, line := range strings.Split(string(data), "\n") {
+		if pattern.MatchString(line) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"formfling/internal/listener"
+	"formfling/internal/logging"
+)
+
+// UseDevMode switches to development mode: email is captured instead of sent,
+// so no SMTP account is needed, and the addresses get local defaults
+func (c *Config) UseDevMode() {
+	c.DevMode = true
+	if c.FromEmail == "" {
+		c.FromEmail = "formfling@localhost"
+		c.setSource("from_email", "dev mode")
+	}
+	if c.ToEmail == "" {
+		c.ToEmail = "inbox@localhost"
+		c.setSource("to_email", "dev mode")
+	}
+}
+
+func (c *Config) setSource(key, source string) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[key] = source
+}
+
+// Validate checks the settings that need no files, database or network and
+// returns every problem found
+func (c *Config) Validate() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if !c.DevMode && (c.SMTPUsername == "" || c.SMTPPassword == "") {
+		fail("SMTP_USERNAME and SMTP_PASSWORD are required")
+	}
+	if c.FromEmail == "" || c.ToEmail == "" {
+		fail("FROM_EMAIL and TO_EMAIL are required")
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		fail("PORT %q must be a port number", c.Port)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if _, ok := TLSVersions[c.TLSMinVersion]; !ok {
+		fail("unknown TLS_MIN_VERSION %q (expected 1.2 or 1.3)", c.TLSMinVersion)
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		fail("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE to be set")
+	}
+	if c.HTTPRedirectPort != "" {
+		if !c.TLSEnabled() {
+			fail("HTTP_REDIRECT_PORT requires TLS_CERT_FILE to be set")
+		}
+		if port, err := strconv.Atoi(c.HTTPRedirectPort); err != nil || port < 1 || port > 65535 {
+			fail("HTTP_REDIRECT_PORT %q must be a port number", c.HTTPRedirectPort)
+		} else if c.HTTPRedirectPort == c.Port {
+			fail("HTTP_REDIRECT_PORT must differ from PORT")
+		}
+	}
+	for _, addr := range c.Listen {
+		if err := listener.CheckAddr(addr); err != nil {
+			fail("LISTEN: %v", err)
+		}
+	}
+	for _, addr := range c.AdminListen {
+		if err := listener.CheckAddr(addr); err != nil {
+			fail("ADMIN_LISTEN: %v", err)
+		} else if slices.Contains(c.ListenAddrs(), addr) {
+			fail("ADMIN_LISTEN: %q is also a public address", addr)
+		}
+	}
+	for _, entry := range c.AdminAllowedIPs {
+		if _, err := parseNetwork(entry); err != nil {
+			fail("ADMIN_ALLOWED_IPS: %q is not an IP address or network such as 10.0.0.0/8", entry)
+		}
+	}
+	for _, entry := range c.TrustedProxies {
+		if _, err := parseNetwork(entry); err != nil {
+			fail("TRUSTED_PROXIES: %q is not an IP address or network such as 10.0.0.0/8", entry)
+		}
+	}
+	if c.EnablePprof && len(c.AdminListen) == 0 {
+		fail("ENABLE_PPROF requires ADMIN_LISTEN to be set")
+	}
+	if mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32); err != nil || mode > 0777 {
+		fail("UNIX_SOCKET_MODE %q must be an octal permission such as 0660", c.UnixSocketMode)
+	}
+	if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+		fail("SMTP_PORT %d must be a port number", c.SMTPPort)
+	}
+	if c.RecaptchaMinScore < 0 || c.RecaptchaMinScore > 1 {
+		fail("RECAPTCHA_MIN_SCORE %v must be between 0 and 1", c.RecaptchaMinScore)
+	}
+	if c.PowDifficulty < 0 {
+		fail("POW_DIFFICULTY %d must not be negative", c.PowDifficulty)
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		fail("TZ %q is not a known time zone", c.Timezone)
+	}
+
+	if c.AdminPassword != "" && c.StorePath == "" {
+		fail("ADMIN_PASSWORD requires STORE_PATH to be set")
+	}
+	switch c.FormsBackend {
+	case FormsBackendFile:
+	case FormsBackendDB:
+		if c.StorePath == "" {
+			fail("FORMS_BACKEND=db requires STORE_PATH to be set")
+		}
+		if c.FormsFile != "" {
+			fail("FORMS_FILE cannot be used with FORMS_BACKEND=db")
+		}
+	default:
+		fail("unknown FORMS_BACKEND %q (expected file or db)", c.FormsBackend)
+	}
+	if c.WatchInterval < 0 {
+		fail("WATCH_INTERVAL %v must not be negative", c.WatchInterval)
+	}
+	timeouts := []struct {
+		env string
+		d   time.Duration
+	}{
+		{"READ_HEADER_TIMEOUT", c.ReadHeaderTimeout},
+		{"READ_TIMEOUT", c.ReadTimeout},
+		{"WRITE_TIMEOUT", c.WriteTimeout},
+		{"IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.d < 0 {
+			fail("%s %v must not be negative", t.env, t.d)
+		}
+	}
+	if c.LogFormat != logging.FormatText && c.LogFormat != logging.FormatJSON {
+		fail("unknown LOG_FORMAT %q (expected text or json)", c.LogFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("unknown LOG_LEVEL %q (expected debug, info, warn or error)", c.LogLevel)
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("OTEL_EXPORTER_OTLP_ENDPOINT %q must be an http or https URL such as http://localhost:4318", c.OTLPEndpoint)
+		}
+	}
+	if c.ReadyCheckInterval < time.Second {
+		fail("READY_CHECK_INTERVAL %v must be at least 1s", c.ReadyCheckInterval)
+	}
+	if c.ReadyMaxInFlight < 0 || c.ReadyMaxFailed < 0 {
+		fail("READY_MAX_IN_FLIGHT and READY_MAX_FAILED must not be negative")
+	}
+	if c.MaxBodyBytes <= 0 {
+		fail("MAX_BODY_BYTES must be positive")
+	}
+	if c.InboundAddr != "" && c.StorePath == "" {
+		fail("INBOUND_ADDR requires STORE_PATH to be set")
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Port:               "8080",
+		SMTPPort:           587,
+		SMTPUsername:       "user",
+		SMTPPassword:       "secret",
+		FromEmail:          "from@example.com",
+		ToEmail:            "to@example.com",
+		RecaptchaMinScore:  0.5,
+		Timezone:           "UTC",
+		FormsBackend:       FormsBackendFile,
+		MaxBodyBytes:       1 << 20,
+		TLSMinVersion:      "1.2",
+		UnixSocketMode:     "0660",
+		LogFormat:          "text",
+		LogLevel:           "info",
+		ReadyCheckInterval: time.Minute,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errs := validConfig().Validate(); len(errs) != 0 {
+		t.Fatalf("Expected a valid config, got %v", errs)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"no SMTP credentials", func(c *Config) { c.SMTPPassword = "" }, "SMTP_USERNAME and SMTP_PASSWORD"},
+		{"no recipient", func(c *Config) { c.ToEmail = "" }, "FROM_EMAIL and TO_EMAIL"},
+		{"bad port", func(c *Config) { c.Port = "http" }, "PORT"},
+		{"bad SMTP port", func(c *Config) { c.SMTPPort = 70000 }, "SMTP_PORT"},
+		{"bad score", func(c *Config) { c.RecaptchaMinScore = 1.5 }, "RECAPTCHA_MIN_SCORE"},
+		{"bad time zone", func(c *Config) { c.Timezone = "Mars/Olympus" }, "TZ"},
+		{"admin without store", func(c *Config) { c.AdminPassword = "pw" }, "ADMIN_PASSWORD requires STORE_PATH"},
+		{"db forms without store", func(c *Config) { c.FormsBackend = FormsBackendDB }, "FORMS_BACKEND=db requires STORE_PATH"},
+		{"unknown forms backend", func(c *Config) { c.FormsBackend = "s3" }, "unknown FORMS_BACKEND"},
+		{"inbound without store", func(c *Config) { c.InboundAddr = ":2525" }, "INBOUND_ADDR requires STORE_PATH"},
+		{"negative timeout", func(c *Config) { c.WriteTimeout = -time.Second }, "WRITE_TIMEOUT"},
+		{"no body limit", func(c *Config) { c.MaxBodyBytes = 0 }, "MAX_BODY_BYTES"},
+		{"certificate without key", func(c *Config) { c.TLSCertFile = "cert.pem" }, "TLS_CERT_FILE and TLS_KEY_FILE"},
+		{"bad log format", func(c *Config) { c.LogFormat = "xml" }, "LOG_FORMAT"},
+		{"bad OTLP endpoint", func(c *Config) { c.OTLPEndpoint = "localhost:4318" }, "OTEL_EXPORTER_OTLP_ENDPOINT"},
+		{"frequent readiness checks", func(c *Config) { c.ReadyCheckInterval = 0 }, "READY_CHECK_INTERVAL"},
+		{"negative backlog limit", func(c *Config) { c.ReadyMaxFailed = -1 }, "READY_MAX_FAILED"},
+		{"bad log level", func(c *Config) { c.LogLevel = "verbose" }, "LOG_LEVEL"},
+		{"old TLS version", func(c *Config) { c.TLSMinVersion = "1.0" }, "TLS_MIN_VERSION"},
+		{"client CA without TLS", func(c *Config) { c.TLSClientCAFile = "ca.pem" }, "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE"},
+		{"redirect without TLS", func(c *Config) { c.HTTPRedirectPort = "80" }, "HTTP_REDIRECT_PORT requires TLS_CERT_FILE"},
+		{"bad listen address", func(c *Config) { c.Listen = []string{":8080", "8081"} }, "LISTEN"},
+		{"admin on a public address", func(c *Config) { c.AdminListen = []string{":8080"} }, "also a public address"},
+		{"bad allowed IP", func(c *Config) { c.AdminAllowedIPs = []string{"10.0.0.0/8", "office"} }, "ADMIN_ALLOWED_IPS"},
+		{"bad trusted proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.internal"} }, "TRUSTED_PROXIES"},
+		{"pprof on the public listener", func(c *Config) { c.EnablePprof = true }, "ENABLE_PPROF requires ADMIN_LISTEN"},
+		{"bad socket mode", func(c *Config) { c.UnixSocketMode = "rw-rw----" }, "UNIX_SOCKET_MODE"},
+		{"redirect to itself", func(c *Config) {
+			c.TLSCertFile, c.TLSKeyFile, c.HTTPRedirectPort = "cert.pem", "key.pem", c.Port
+		}, "HTTP_REDIRECT_PORT must differ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(cfg)
+			errs := cfg.Validate()
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.want) {
+				t.Errorf("Expected one error about %q, got %v", tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestUseDevMode(t *testing.T) {
+	cfg := validConfig()
+	cfg.SMTPUsername, cfg.SMTPPassword, cfg.FromEmail, cfg.ToEmail = "", "", "", ""
+	cfg.UseDevMode()
+
+	if errs := cfg.Validate(); len(errs) != 0 {
+		t.Fatalf("Expected development mode to need no SMTP settings, got %v", errs)
+	}
+	if cfg.FromEmail != "formfling@localhost" || cfg.ToEmail != "inbox@localhost" {
+		t.Errorf("Expected local default addresses, got %q and %q", cfg.FromEmail, cfg.ToEmail)
+	}
+}
@@ -0,0 +1,891 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+	"formfling/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// adminCSRFScope binds admin CSRF tokens so they cannot be used on forms
+	adminCSRFScope = "admin"
+	// loginCSRFScope protects the sign-in form, which has no session yet
+	loginCSRFScope = "admin-login"
+
+	totpIssuer = "FormFling"
+)
+
+type adminContextKey struct{}
+
+// AdminHandler serves the submissions dashboard to signed-in admin users
+type AdminHandler struct {
+	config        *config.Config
+	store         *store.Store
+	emailService  services.EmailSender
+	csrfService   *services.CSRFService
+	authService   *services.AuthService
+	adminTemplate *template.Template
+}
+
+func NewAdminHandler(cfg *config.Config, submissionStore *store.Store, emailService services.EmailSender, csrfService *services.CSRFService, authService *services.AuthService) *AdminHandler {
+	adminHandler, err := LoadAdminHandler(cfg, submissionStore, emailService, csrfService, authService)
+	if err != nil {
+		log.Fatal("Error loading admin template:", err)
+	}
+	return adminHandler
+}
+
+// LoadAdminHandler is NewAdminHandler for callers that handle a broken
+// template themselves
+func LoadAdminHandler(cfg *config.Config, submissionStore *store.Store, emailService services.EmailSender, csrfService *services.CSRFService, authService *services.AuthService) (*AdminHandler, error) {
+	adminTemplate, err := ParseAdminTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminHandler{
+		config:        cfg,
+		store:         submissionStore,
+		emailService:  emailService,
+		csrfService:   csrfService,
+		authService:   authService,
+		adminTemplate: adminTemplate,
+	}, nil
+}
+
+// ParseAdminTemplate loads the dashboard template with the functions it uses
+func ParseAdminTemplate(cfg *config.Config) (*template.Template, error) {
+	return template.New("admin").Funcs(template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
+				t = t.In(loc)
+			}
+			return t.Format("02 Jan 2006 15:04")
+		},
+		"ticketStatus": func(status string) string {
+			if status == "" {
+				return ""
+			}
+			return strings.ReplaceAll(strings.ToUpper(status[:1])+status[1:], "_", " ")
+		},
+	}).ParseFiles(cfg.AdminTemplate)
+}
+
+// AdminPage holds what every admin page shows besides its content
+type AdminPage struct {
+	Title     string
+	User      *models.User
+	CSRFToken string
+	Flash     string
+	Error     string
+}
+
+type AdminLoginData struct {
+	AdminPage
+	Username   string
+	Next       string
+	NeedCode   bool
+	SSOEnabled bool
+}
+
+// ssoErrors are shown on the sign-in page after a failed single sign-on
+var ssoErrors = map[string]string{
+	"unavailable": "Single sign-on is unavailable right now",
+	"failed":      "Single sign-on failed, please try again",
+	"denied":      services.ErrOIDCDenied.Error(),
+}
+
+type AdminListData struct {
+	AdminPage
+	Forms       []string
+	Query       store.Query
+	Status      string
+	From        string
+	To          string
+	Statuses    []string
+	Assignees   []string
+	Assignee    string
+	Submissions []*models.Submission
+	NextCursor  string
+	NextURL     string
+	Exports     []AdminExportLink
+}
+
+// AdminExportLink downloads the listed submissions in one format
+type AdminExportLink struct {
+	Label string
+	URL   string
+}
+
+// adminExports are the download links offered below the filters
+var adminExports = []struct {
+	label  string
+	params url.Values
+}{
+	{"CSV", url.Values{"format": {services.ExportCSV}, "bom": {"true"}}},
+	{"CSV (semicolons)", url.Values{"format": {services.ExportCSV}, "bom": {"true"}, "delimiter": {"semicolon"}}},
+	{"Excel", url.Values{"format": {services.ExportXLSX}}},
+	{"JSON Lines", url.Values{"format": {services.ExportJSONL}}},
+}
+
+type AdminDetailData struct {
+	AdminPage
+	Submission   *models.Submission
+	Statuses     []string
+	Assignees    []string
+	Templates    []*models.ReplyTemplate
+	Template     string
+	ReplySubject string
+	ReplyBody    string
+}
+
+type AdminRepliesData struct {
+	AdminPage
+	Templates []*models.ReplyTemplate
+}
+
+type AdminAPIKeysData struct {
+	AdminPage
+	Keys   []*models.APIKey
+	Scopes []string
+	NewKey string
+}
+
+type AdminAccountData struct {
+	AdminPage
+	TOTPEnabled bool
+	TOTPSecret  string
+	TOTPURI     string
+}
+
+// RequireAuth only lets signed-in users through. Browsers are sent to the
+// sign-in page.
+func (h *AdminHandler) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Admin pages show personal data and must not be cached or framed
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+
+		user, err := h.authService.CurrentUser(r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading admin session", "error", err)
+			http.Error(w, "Error loading session", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			if r.Method != http.MethodGet {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, user)))
+	})
+}
+
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(adminContextKey{}).(*models.User)
+	return user
+}
+
+// LoginPage shows the sign-in form
+func (h *AdminHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	data := AdminLoginData{Next: r.URL.Query().Get("next")}
+	data.Error = ssoErrors[r.URL.Query().Get("sso")]
+	h.renderLogin(w, r, data, http.StatusOK)
+}
+
+// Login checks the credentials and starts a session
+func (h *AdminHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := h.csrfService.Verify(r, loginCSRFScope, r.FormValue(services.CSRFFieldName)); err != nil {
+		slog.WarnContext(r.Context(), "Admin login CSRF verification failed", "error", err)
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	data := AdminLoginData{
+		Username: strings.TrimSpace(r.FormValue("username")),
+		Next:     r.FormValue("next"),
+	}
+	ip := signInIP(r, h.config.ProxyNetworks())
+	user, err := h.authService.Login(data.Username, r.FormValue("password"), r.FormValue("code"), ip)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrTOTPRequired), errors.Is(err, services.ErrInvalidTOTP):
+		data.NeedCode = true
+		data.Error = err.Error()
+		h.renderLogin(w, r, data, http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrInvalidCredentials):
+		data.Error = err.Error()
+		h.renderLogin(w, r, data, http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrTooManyAttempts):
+		slog.WarnContext(r.Context(), "Admin sign-in locked out", "user", data.Username, "ip", ip)
+		data.Error = err.Error()
+		h.renderLogin(w, r, data, http.StatusTooManyRequests)
+		return
+	default:
+		slog.ErrorContext(r.Context(), "Error signing in", "user", data.Username, "error", err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.authService.StartSession(w, r, user); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting session", "user", user.Username, "error", err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin signed in", "user", user.Username)
+
+	http.Redirect(w, r, safeAdminPath(data.Next), http.StatusSeeOther)
+}
+
+// safeAdminPath only lets the sign-in page return to admin pages on this host
+func safeAdminPath(next string) string {
+	if strings.HasPrefix(next, "/admin/") && !strings.ContainsAny(next, "\\") {
+		return next
+	}
+	return "/admin/submissions"
+}
+
+// Logout ends the session
+func (h *AdminHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) {
+		return
+	}
+	if err := h.authService.EndSession(w, r); err != nil {
+		slog.ErrorContext(r.Context(), "Error ending session", "error", err)
+	}
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) renderLogin(w http.ResponseWriter, r *http.Request, data AdminLoginData, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	token, err := h.csrfService.Issue(w, r, loginCSRFScope)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error issuing CSRF token", "error", err)
+		http.Error(w, "Error loading sign-in page", http.StatusInternalServerError)
+		return
+	}
+	data.Title = "Sign in"
+	data.CSRFToken = token
+	data.SSOEnabled = h.config.OIDCIssuer != ""
+	h.render(w, status, "login", data)
+}
+
+// List shows the submissions matching the filters in the query string
+func (h *AdminHandler) List(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	params := r.URL.Query()
+	query := h.listQuery(params, user)
+	query.Limit = 50
+
+	subs, next, err := h.store.ListSubmissions(query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing submissions", "error", err)
+		http.Error(w, "Error loading submissions", http.StatusInternalServerError)
+		return
+	}
+
+	page, ok := h.page(w, r, "Submissions")
+	if !ok {
+		return
+	}
+
+	data := AdminListData{
+		AdminPage:   page,
+		Forms:       h.formSlugs(user),
+		Query:       query,
+		Status:      query.Spam,
+		From:        params.Get("from"),
+		To:          params.Get("to"),
+		Statuses:    models.TicketStatuses,
+		Assignees:   assignees(h.store, ""),
+		Assignee:    params.Get("assignee"),
+		Submissions: subs,
+		NextCursor:  next,
+	}
+
+	params.Del("flash")
+	params.Del("cursor")
+	for _, export := range adminExports {
+		exportParams := url.Values{}
+		for key, values := range params {
+			exportParams[key] = values
+		}
+		for key, values := range export.params {
+			exportParams[key] = values
+		}
+		data.Exports = append(data.Exports, AdminExportLink{Label: export.label, URL: "/admin/submissions/export?" + exportParams.Encode()})
+	}
+	if next != "" {
+		params.Set("cursor", next)
+		data.NextURL = "/admin/submissions?" + params.Encode()
+	}
+
+	h.render(w, http.StatusOK, "list", data)
+}
+
+// Export downloads every submission matching the dashboard filters
+func (h *AdminHandler) Export(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	params := r.URL.Query()
+	query := h.listQuery(params, user)
+	opts, err := parseExportOptions(h.config, params, services.ExportCSV)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var forms []*config.Form
+	for _, form := range h.config.AllForms() {
+		if user.CanAccess(form.Slug) {
+			forms = append(forms, form)
+		}
+	}
+	writeExport(w, h.store, query, forms, opts)
+}
+
+// listQuery reads the dashboard filters, limited to the forms the user may
+// see. The from and to dates are both included.
+func (h *AdminHandler) listQuery(params url.Values, user *models.User) store.Query {
+	query := store.Query{
+		Form:   params.Get("form"),
+		Search: params.Get("q"),
+		Spam:   params.Get("status"),
+		Failed: params.Get("delivery") == "failed",
+		Status: params.Get("ticket"),
+		Tag:    params.Get("tag"),
+		Cursor: params.Get("cursor"),
+	}
+	switch assignee := params.Get("assignee"); assignee {
+	case "":
+	case "me":
+		query.Assignee = user.Username
+	case "none":
+		query.Unassigned = true
+	default:
+		query.Assignee = assignee
+	}
+	loc := services.ExportLocation(h.config)
+	if from, err := time.ParseInLocation("2006-01-02", params.Get("from"), loc); err == nil {
+		query.Since = from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", params.Get("to"), loc); err == nil {
+		query.Until = to.AddDate(0, 0, 1)
+	}
+	if !user.IsOwner() {
+		query.Forms = append([]string{}, user.Forms...)
+	}
+	return query
+}
+
+// Detail shows a single submission with its delivery status
+func (h *AdminHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := h.page(w, r, "Submission")
+	if !ok {
+		return
+	}
+
+	templates, err := h.store.ListReplyTemplates()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing reply templates", "error", err)
+	}
+	data := AdminDetailData{
+		AdminPage:    page,
+		Submission:   sub,
+		Statuses:     models.TicketStatuses,
+		Assignees:    assignees(h.store, sub.Form),
+		Templates:    templates,
+		ReplySubject: services.ReplySubject(h.config, sub),
+	}
+
+	// Prefill the reply from a saved template
+	if id := r.URL.Query().Get("template"); id != "" {
+		tmpl, err := h.store.GetReplyTemplate(id)
+		if err != nil {
+			page.Flash = "Reply template not found"
+		} else {
+			data.Template = tmpl.ID
+			if tmpl.Subject != "" {
+				data.ReplySubject = services.RenderReply(tmpl.Subject, sub)
+			}
+			data.ReplyBody = services.RenderReply(tmpl.Body, sub)
+		}
+		data.AdminPage = page
+	}
+
+	h.render(w, http.StatusOK, "detail", data)
+}
+
+// Action applies spam, ham, delete, resend, ticket changes, a note or a reply
+// to a submission
+func (h *AdminHandler) Action(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) {
+		return
+	}
+	user := currentUser(r)
+	if !user.CanEdit() {
+		http.Error(w, "Your role cannot change submissions", http.StatusForbidden)
+		return
+	}
+
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	// The change is applied to the stored submission in one transaction, so
+	// it cannot undo a change another agent saved since sub was loaded.
+	// Email is sent beforehand, outside the transaction.
+	detailURL := "/admin/submissions/" + sub.ID
+	var flash string
+	var change func(*models.Submission) error
+
+	switch action := mux.Vars(r)["action"]; action {
+	case "spam", "ham":
+		change = func(stored *models.Submission) error {
+			stored.SetSpam(user.Username, action == "spam")
+			return nil
+		}
+		flash = "Marked as " + action
+	case "delete":
+		if err := h.store.DeleteSubmission(sub.ID); err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting submission", "submission", sub.ID, "error", err)
+			http.Error(w, "Error deleting submission", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/submissions?flash="+url.QueryEscape("Submission deleted"), http.StatusSeeOther)
+		return
+	case "resend":
+		if err := services.DeliverEmail(r.Context(), h.emailService, sub); err != nil {
+			slog.ErrorContext(r.Context(), "Error resending submission", "submission", sub.ID, "error", err)
+			flash = "Resend failed: " + err.Error()
+		} else {
+			flash = "Notification sent"
+		}
+		change = func(stored *models.Submission) error {
+			copyDelivery(stored, sub)
+			return nil
+		}
+	case "ticket":
+		status := r.FormValue("status")
+		assignee := r.FormValue("assignee")
+		if !models.ValidTicketStatus(status) {
+			http.Error(w, "Unknown status", http.StatusBadRequest)
+			return
+		}
+		if err := checkAssignee(h.store, sub.Form, assignee); err != nil {
+			http.Redirect(w, r, detailURL+"?flash="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+		flash = "Nothing changed"
+		change = func(stored *models.Submission) error {
+			changed := stored.SetStatus(user.Username, status)
+			changed = stored.SetAssignee(user.Username, assignee) || changed
+			changed = stored.SetTags(user.Username, splitTags(r.FormValue("tags"))) || changed
+			if changed {
+				flash = "Ticket updated"
+			}
+			return nil
+		}
+	case "note":
+		body := strings.TrimSpace(r.FormValue("note"))
+		if body == "" {
+			http.Redirect(w, r, detailURL+"?flash="+url.QueryEscape("Write a note first"), http.StatusSeeOther)
+			return
+		}
+		change = func(stored *models.Submission) error {
+			stored.AddNote(user.Username, body)
+			return nil
+		}
+		flash = "Note added"
+	case "reply":
+		subject := strings.TrimSpace(r.FormValue("subject"))
+		body := strings.TrimSpace(r.FormValue("body"))
+		if body == "" || subject == "" {
+			http.Redirect(w, r, detailURL+"?flash="+url.QueryEscape("Write a subject and a reply first"), http.StatusSeeOther)
+			return
+		}
+		if !utils.ValidateEmail(sub.ReplyAddress()) {
+			http.Redirect(w, r, detailURL+"?flash="+url.QueryEscape("The submitter left no email address to reply to"), http.StatusSeeOther)
+			return
+		}
+		reply := services.NewReply(h.config, sub, subject, body)
+		if err := h.emailService.SendReply(r.Context(), reply); err != nil {
+			slog.ErrorContext(r.Context(), "Error sending reply", "submission", sub.ID, "error", err)
+			http.Redirect(w, r, detailURL+"?flash="+url.QueryEscape("Reply failed: "+err.Error()), http.StatusSeeOther)
+			return
+		}
+		change = func(stored *models.Submission) error {
+			stored.AddReply(user.Username, reply)
+			return nil
+		}
+		flash = "Reply sent to " + reply.To
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := h.store.ModifySubmission(sub.ID, change); err != nil {
+		slog.ErrorContext(r.Context(), "Error updating submission", "submission", sub.ID, "error", err)
+		http.Error(w, "Error updating submission", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, detailURL+"?flash="+url.QueryEscape(flash), http.StatusSeeOther)
+}
+
+// Replies lists the saved reply templates with a form to add one
+func (h *AdminHandler) Replies(w http.ResponseWriter, r *http.Request) {
+	h.renderReplies(w, r, "")
+}
+
+// CreateReply saves a reply template
+func (h *AdminHandler) CreateReply(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) || !h.requireEditor(w, r) {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	body := strings.TrimSpace(r.FormValue("body"))
+	if name == "" || body == "" {
+		h.renderReplies(w, r, "Give the template a name and a body")
+		return
+	}
+	if _, err := h.store.CreateReplyTemplate(name, strings.TrimSpace(r.FormValue("subject")), body); err != nil {
+		slog.ErrorContext(r.Context(), "Error creating reply template", "error", err)
+		http.Error(w, "Error saving reply template", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/replies?flash="+url.QueryEscape("Reply template saved"), http.StatusSeeOther)
+}
+
+// DeleteReply removes a reply template
+func (h *AdminHandler) DeleteReply(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) || !h.requireEditor(w, r) {
+		return
+	}
+
+	err := h.store.DeleteReplyTemplate(mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting reply template", "error", err)
+		http.Error(w, "Error deleting reply template", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/replies?flash="+url.QueryEscape("Reply template deleted"), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) renderReplies(w http.ResponseWriter, r *http.Request, errorMsg string) {
+	templates, err := h.store.ListReplyTemplates()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing reply templates", "error", err)
+		http.Error(w, "Error loading reply templates", http.StatusInternalServerError)
+		return
+	}
+
+	page, ok := h.page(w, r, "Reply templates")
+	if !ok {
+		return
+	}
+	page.Error = errorMsg
+
+	h.render(w, http.StatusOK, "replies", AdminRepliesData{
+		AdminPage: page,
+		Templates: templates,
+	})
+}
+
+// APIKeys lists the API keys with a form to create new ones
+func (h *AdminHandler) APIKeys(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOwner(w, r) {
+		return
+	}
+	h.renderAPIKeys(w, r, "", "")
+}
+
+// CreateAPIKey generates a key and shows it once
+func (h *AdminHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) || !h.requireOwner(w, r) {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		h.renderAPIKeys(w, r, "", "Give the key a name")
+		return
+	}
+	var scopes []string
+	for _, scope := range models.Scopes {
+		for _, requested := range r.Form["scope"] {
+			if requested == scope {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		h.renderAPIKeys(w, r, "", "Select at least one scope")
+		return
+	}
+
+	_, plain, err := h.store.CreateAPIKey(name, scopes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating API key", "error", err)
+		http.Error(w, "Error creating API key", http.StatusInternalServerError)
+		return
+	}
+	h.renderAPIKeys(w, r, plain, "")
+}
+
+// RevokeAPIKey deletes an API key
+func (h *AdminHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) || !h.requireOwner(w, r) {
+		return
+	}
+
+	err := h.store.DeleteAPIKey(mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking API key", "error", err)
+		http.Error(w, "Error revoking API key", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/api-keys?flash="+url.QueryEscape("API key revoked"), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) renderAPIKeys(w http.ResponseWriter, r *http.Request, newKey, errorMsg string) {
+	keys, err := h.store.ListAPIKeys()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing API keys", "error", err)
+		http.Error(w, "Error loading API keys", http.StatusInternalServerError)
+		return
+	}
+
+	page, ok := h.page(w, r, "API keys")
+	if !ok {
+		return
+	}
+	page.Error = errorMsg
+	if newKey != "" {
+		page.Flash = "API key created. Copy it now, it will not be shown again."
+	}
+
+	h.render(w, http.StatusOK, "apikeys", AdminAPIKeysData{
+		AdminPage: page,
+		Keys:      keys,
+		Scopes:    models.Scopes,
+		NewKey:    newKey,
+	})
+}
+
+// Account shows the signed-in user's password and two-factor settings
+func (h *AdminHandler) Account(w http.ResponseWriter, r *http.Request) {
+	h.renderAccount(w, r, "", "")
+}
+
+// AccountAction changes the password or two-factor settings
+func (h *AdminHandler) AccountAction(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyCSRF(w, r) {
+		return
+	}
+	user := currentUser(r)
+
+	switch mux.Vars(r)["action"] {
+	case "password":
+		if !services.CheckPassword(user, r.FormValue("current_password")) {
+			h.renderAccount(w, r, "Current password is incorrect", "")
+			return
+		}
+		hash, err := services.HashPassword(r.FormValue("new_password"))
+		if err != nil {
+			h.renderAccount(w, r, err.Error(), "")
+			return
+		}
+		user.PasswordHash = hash
+		if !h.saveAccount(w, user) {
+			return
+		}
+		// Sign out every other browser, then start over on this one
+		if err := h.store.DeleteUserSessions(user.Username); err != nil {
+			slog.ErrorContext(r.Context(), "Error ending sessions", "user", user.Username, "error", err)
+		}
+		if err := h.authService.StartSession(w, r, user); err != nil {
+			slog.ErrorContext(r.Context(), "Error starting session", "user", user.Username, "error", err)
+		}
+		http.Redirect(w, r, "/admin/account?flash="+url.QueryEscape("Password changed"), http.StatusSeeOther)
+	case "totp-setup":
+		secret, err := services.GenerateTOTPSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating TOTP secret", "error", err)
+			http.Error(w, "Error setting up two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		h.renderAccount(w, r, "", secret)
+	case "totp-enable":
+		secret := r.FormValue("secret")
+		step, ok := services.VerifyTOTP(secret, r.FormValue("code"), time.Now())
+		if !ok {
+			h.renderAccount(w, r, "That code did not match, try again", secret)
+			return
+		}
+		user.TOTPSecret = secret
+		user.TOTPLastStep = step
+		if !h.saveAccount(w, user) {
+			return
+		}
+		http.Redirect(w, r, "/admin/account?flash="+url.QueryEscape("Two-factor authentication enabled"), http.StatusSeeOther)
+	case "totp-disable":
+		if !services.CheckPassword(user, r.FormValue("current_password")) {
+			h.renderAccount(w, r, "Current password is incorrect", "")
+			return
+		}
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		if !h.saveAccount(w, user) {
+			return
+		}
+		http.Redirect(w, r, "/admin/account?flash="+url.QueryEscape("Two-factor authentication disabled"), http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AdminHandler) saveAccount(w http.ResponseWriter, user *models.User) bool {
+	if err := h.store.UpdateUser(user); err != nil {
+		slog.Error("Error updating user", "user", user.Username, "error", err)
+		http.Error(w, "Error updating account", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (h *AdminHandler) renderAccount(w http.ResponseWriter, r *http.Request, errorMsg, totpSecret string) {
+	page, ok := h.page(w, r, "Account")
+	if !ok {
+		return
+	}
+	page.Error = errorMsg
+
+	data := AdminAccountData{
+		AdminPage:   page,
+		TOTPEnabled: page.User.TOTPSecret != "",
+		TOTPSecret:  totpSecret,
+	}
+	if totpSecret != "" {
+		data.TOTPURI = services.TOTPURI(totpIssuer, page.User.Username, totpSecret)
+	}
+	h.render(w, http.StatusOK, "account", data)
+}
+
+// page fills in the parts shared by all signed-in pages
+func (h *AdminHandler) page(w http.ResponseWriter, r *http.Request, title string) (AdminPage, bool) {
+	token, err := h.csrfService.Issue(w, r, adminCSRFScope)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error issuing CSRF token", "error", err)
+		http.Error(w, "Error loading page", http.StatusInternalServerError)
+		return AdminPage{}, false
+	}
+	return AdminPage{
+		Title:     title,
+		User:      currentUser(r),
+		CSRFToken: token,
+		Flash:     r.URL.Query().Get("flash"),
+	}, true
+}
+
+func (h *AdminHandler) requireOwner(w http.ResponseWriter, r *http.Request) bool {
+	if !currentUser(r).IsOwner() {
+		http.Error(w, "Only owners can manage API keys", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *AdminHandler) requireEditor(w http.ResponseWriter, r *http.Request) bool {
+	if !currentUser(r).CanEdit() {
+		http.Error(w, "Your role cannot change reply templates", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *AdminHandler) verifyCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return false
+	}
+	if err := h.csrfService.Verify(r, adminCSRFScope, r.FormValue(services.CSRFFieldName)); err != nil {
+		slog.WarnContext(r.Context(), "Admin CSRF verification failed", "error", err)
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// loadSubmission loads the submission in the URL. Submissions of forms the
+// user was not granted are reported as missing.
+func (h *AdminHandler) loadSubmission(w http.ResponseWriter, r *http.Request) (*models.Submission, bool) {
+	sub, err := h.store.GetSubmission(mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !currentUser(r).CanAccess(sub.Form)) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading submission", "error", err)
+		http.Error(w, "Error loading submission", http.StatusInternalServerError)
+		return nil, false
+	}
+	return sub, true
+}
+
+func (h *AdminHandler) formSlugs(user *models.User) []string {
+	var slugs []string
+	for _, form := range h.config.AllForms() {
+		if user.CanAccess(form.Slug) {
+			slugs = append(slugs, form.Slug)
+		}
+	}
+	return slugs
+}
+
+func (h *AdminHandler) render(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.adminTemplate.ExecuteTemplate(w, name, data); err != nil {
+		slog.Error("Error rendering admin page", "page", name, "error", err)
+	}
+}
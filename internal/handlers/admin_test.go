@@ -0,0 +1,700 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+type adminTestEnv struct {
+	router       *mux.Router
+	config       *config.Config
+	store        *store.Store
+	authService  *services.AuthService
+	emailService *mockEmailService
+	session      *http.Cookie
+}
+
+const adminTestPassword = "correct horse battery"
+
+func newAdminTestEnv(t *testing.T) *adminTestEnv {
+	t.Helper()
+	cfg := &config.Config{
+		FormTitle:     "Test Form",
+		FromEmail:     "forms@example.com",
+		ToEmail:       "team@example.com",
+		Timezone:      "UTC",
+		AdminTemplate: "../../web/templates/admin_template.html",
+	}
+
+	submissionStore, err := store.Open(filepath.Join(t.TempDir(), "formfling.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { submissionStore.Close() })
+
+	emailService := &mockEmailService{}
+	authService := services.NewAuthService(submissionStore)
+	handler := NewAdminHandler(cfg, submissionStore, emailService, services.NewCSRFService(cfg), authService)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/login", handler.LoginPage).Methods("GET")
+	r.HandleFunc("/admin/login", handler.Login).Methods("POST")
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(handler.RequireAuth)
+	admin.HandleFunc("/logout", handler.Logout).Methods("POST")
+	admin.HandleFunc("/submissions", handler.List).Methods("GET")
+	admin.HandleFunc("/submissions/export", handler.Export).Methods("GET")
+	admin.HandleFunc("/submissions/{id}", handler.Detail).Methods("GET")
+	admin.HandleFunc("/submissions/{id}/{action}", handler.Action).Methods("POST")
+	admin.HandleFunc("/replies", handler.Replies).Methods("GET")
+	admin.HandleFunc("/replies", handler.CreateReply).Methods("POST")
+	admin.HandleFunc("/replies/{id}/delete", handler.DeleteReply).Methods("POST")
+	admin.HandleFunc("/api-keys", handler.APIKeys).Methods("GET")
+	admin.HandleFunc("/api-keys", handler.CreateAPIKey).Methods("POST")
+	admin.HandleFunc("/api-keys/{id}/delete", handler.RevokeAPIKey).Methods("POST")
+	admin.HandleFunc("/account", handler.Account).Methods("GET")
+	admin.HandleFunc("/account/{action}", handler.AccountAction).Methods("POST")
+
+	env := &adminTestEnv{router: r, config: cfg, store: submissionStore, authService: authService, emailService: emailService}
+	env.addUser(t, "admin", models.RoleOwner)
+	env.session = env.signIn(t, "admin")
+	return env
+}
+
+func (env *adminTestEnv) addUser(t *testing.T, username, role string, forms ...string) *models.User {
+	t.Helper()
+	hash, err := services.HashPassword(adminTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, PasswordHash: hash, Role: role, Forms: forms}
+	if err := env.store.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// signIn starts a session for the user and returns its cookie
+func (env *adminTestEnv) signIn(t *testing.T, username string) *http.Cookie {
+	t.Helper()
+	user, err := env.store.GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	if err := env.authService.StartSession(rr, httptest.NewRequest("GET", "/", nil), user); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Result().Cookies()[0]
+}
+
+func (env *adminTestEnv) do(t *testing.T, method, target string, body url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body.Encode()))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if env.session != nil {
+		req.AddCookie(env.session)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	env.router.ServeHTTP(rr, req)
+	return rr
+}
+
+// csrf loads a page and returns its CSRF cookie and token
+func (env *adminTestEnv) csrf(t *testing.T, target string) (*http.Cookie, string) {
+	t.Helper()
+	page := env.do(t, "GET", target, nil)
+	var cookie *http.Cookie
+	for _, c := range page.Result().Cookies() {
+		if c.Name == services.CSRFCookieName {
+			cookie = c
+		}
+	}
+	match := regexp.MustCompile(`name="_csrf" value="([^"]+)"`).FindStringSubmatch(page.Body.String())
+	if cookie == nil || match == nil {
+		t.Fatalf("Expected a CSRF cookie and token on %s (status %v)", target, page.Code)
+	}
+	return cookie, match[1]
+}
+
+func TestAdminHandler_RequiresAuth(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.session = nil
+
+	rr := env.do(t, "GET", "/admin/submissions?form=default", nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/login?next=%2Fadmin%2Fsubmissions%3Fform%3Ddefault" {
+		t.Errorf("Expected redirect to sign-in, got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := env.do(t, "POST", "/admin/submissions/x/spam", url.Values{}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 posting without a session, got %v", rr.Code)
+	}
+
+	env.session = &http.Cookie{Name: services.SessionCookieName, Value: "forged"}
+	if rr := env.do(t, "GET", "/admin/submissions", nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected a forged session to be rejected, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_Login(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.session = nil
+	cookie, token := env.csrf(t, "/admin/login")
+
+	login := func(password, code string) *httptest.ResponseRecorder {
+		return env.do(t, "POST", "/admin/login", url.Values{
+			"_csrf": {token}, "username": {"Admin"}, "password": {password}, "code": {code},
+			"next": {"/admin/submissions?q=x"},
+		}, cookie)
+	}
+
+	if rr := login("wrong password", ""); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "invalid username or password") {
+		t.Errorf("Expected status 401 with a wrong password, got %v", rr.Code)
+	}
+
+	rr := login(adminTestPassword, "")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/submissions?q=x" {
+		t.Fatalf("Expected redirect back after sign-in, got %v %q", rr.Code, rr.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == services.SessionCookieName {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatal("Expected an HttpOnly session cookie")
+	}
+
+	env.session = session
+	if rr := env.do(t, "GET", "/admin/submissions", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected the session to work, got %v", rr.Code)
+	}
+	if rr := env.do(t, "POST", "/admin/logout", url.Values{"_csrf": {token}}, cookie); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the sign-in CSRF token to be rejected for sign-out, got %v", rr.Code)
+	}
+	csrfCookie, adminToken := env.csrf(t, "/admin/submissions")
+	env.do(t, "POST", "/admin/logout", url.Values{"_csrf": {adminToken}}, csrfCookie)
+	if rr := env.do(t, "GET", "/admin/submissions", nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected the session to end on sign-out, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_LoginLockout(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.session = nil
+	cookie, token := env.csrf(t, "/admin/login")
+
+	for i := 0; i < 5; i++ {
+		env.do(t, "POST", "/admin/login", url.Values{"_csrf": {token}, "username": {"admin"}, "password": {"guess"}}, cookie)
+	}
+	rr := env.do(t, "POST", "/admin/login", url.Values{"_csrf": {token}, "username": {"admin"}, "password": {adminTestPassword}}, cookie)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the account to be locked after 5 failures, got %v", rr.Code)
+	}
+}
+
+// loginFrom posts the sign-in form with an X-Forwarded-For header, over a
+// unix socket when forwarded is empty
+func (env *adminTestEnv) loginFrom(cookie *http.Cookie, token, username, password, forwarded string) int {
+	body := url.Values{"_csrf": {token}, "username": {username}, "password": {password}}
+	req := httptest.NewRequest("POST", "/admin/login", strings.NewReader(body.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if forwarded != "" {
+		req.Header.Set("X-Forwarded-For", forwarded)
+	} else {
+		req.RemoteAddr = "@"
+	}
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	env.router.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestAdminHandler_LoginThrottleIgnoresForwardedFor(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.session = nil
+	cookie, token := env.csrf(t, "/admin/login")
+
+	// Unknown accounts, so only the address throttle can refuse the last try
+	for i := 0; i < 20; i++ {
+		env.loginFrom(cookie, token, fmt.Sprintf("nobody%d", i), "guess", fmt.Sprintf("203.0.113.%d", i))
+	}
+	if code := env.loginFrom(cookie, token, "admin", adminTestPassword, "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the connection to be throttled whatever X-Forwarded-For says, got %v", code)
+	}
+}
+
+func TestAdminHandler_LoginThrottleBehindTrustedProxy(t *testing.T) {
+	env := newAdminTestEnv(t)
+	// httptest requests come from 192.0.2.1
+	env.config.TrustedProxies = []string{"192.0.2.0/24"}
+	env.session = nil
+	cookie, token := env.csrf(t, "/admin/login")
+
+	for i := 0; i < 20; i++ {
+		env.loginFrom(cookie, token, fmt.Sprintf("nobody%d", i), "guess", "203.0.113.7")
+	}
+	for _, tt := range []struct {
+		forwarded string
+		want      int
+	}{
+		{"203.0.113.7", http.StatusTooManyRequests},
+		{"198.51.100.1, 203.0.113.7", http.StatusTooManyRequests},
+		{"198.51.100.1", http.StatusSeeOther},
+	} {
+		if code := env.loginFrom(cookie, token, "admin", adminTestPassword, tt.forwarded); code != tt.want {
+			t.Errorf("Expected %v signing in for %s, got %v", tt.want, tt.forwarded, code)
+		}
+	}
+}
+
+func TestAdminHandler_LoginOverUnixSocket(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.session = nil
+	cookie, token := env.csrf(t, "/admin/login")
+
+	// Every client of the socket shares its address, so only accounts lock
+	for i := 0; i < 25; i++ {
+		env.loginFrom(cookie, token, fmt.Sprintf("nobody%d", i), "guess", "")
+	}
+	if code := env.loginFrom(cookie, token, "admin", adminTestPassword, ""); code != http.StatusSeeOther {
+		t.Errorf("Expected other accounts to sign in over the socket, got %v", code)
+	}
+}
+
+func TestAdminHandler_TwoFactor(t *testing.T) {
+	env := newAdminTestEnv(t)
+	cookie, token := env.csrf(t, "/admin/account")
+
+	rr := env.do(t, "POST", "/admin/account/totp-setup", url.Values{"_csrf": {token}}, cookie)
+	secret := regexp.MustCompile(`name="secret" value="([A-Z2-7]+)"`).FindStringSubmatch(rr.Body.String())
+	if secret == nil {
+		t.Fatalf("Expected a TOTP secret, got %s", rr.Body.String())
+	}
+	code, _ := services.TOTPCode(secret[1], time.Now().Add(-30*time.Second))
+	rr = env.do(t, "POST", "/admin/account/totp-enable", url.Values{"_csrf": {token}, "secret": {secret[1]}, "code": {code}}, cookie)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected two-factor to be enabled, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	env.session = nil
+	loginCookie, loginToken := env.csrf(t, "/admin/login")
+	login := func(code string) *httptest.ResponseRecorder {
+		return env.do(t, "POST", "/admin/login", url.Values{
+			"_csrf": {loginToken}, "username": {"admin"}, "password": {adminTestPassword}, "code": {code},
+		}, loginCookie)
+	}
+
+	if rr := login(""); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), `name="code"`) {
+		t.Errorf("Expected a prompt for the code, got %v", rr.Code)
+	}
+	if rr := login(code); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the code used for setup to be rejected as a replay, got %v", rr.Code)
+	}
+	current, _ := services.TOTPCode(secret[1], time.Now())
+	if rr := login(current); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected sign-in with a fresh code, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_FormPermissions(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.addUser(t, "viewer", models.RoleViewer, "support")
+	env.addUser(t, "editor", models.RoleEditor, "support")
+
+	support := &models.Submission{Form: "support", Data: models.FormData{Name: "Sam", Subject: "Support request"}}
+	sales := &models.Submission{Form: "sales", Data: models.FormData{Name: "Sue", Subject: "Sales lead"}}
+	for _, sub := range []*models.Submission{support, sales} {
+		if err := env.store.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.session = env.signIn(t, "viewer")
+	rr := env.do(t, "GET", "/admin/submissions", nil)
+	if body := rr.Body.String(); !strings.Contains(body, "Support request") || strings.Contains(body, "Sales lead") {
+		t.Error("Expected viewer to see only granted forms")
+	}
+	if rr := env.do(t, "GET", "/admin/submissions?form=sales", nil); strings.Contains(rr.Body.String(), "Sales lead") {
+		t.Error("Expected the form filter not to bypass grants")
+	}
+	if rr := env.do(t, "GET", "/admin/submissions/"+sales.ID, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a form without access, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/admin/api-keys", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 on API keys for a viewer, got %v", rr.Code)
+	}
+	cookie, token := env.csrf(t, "/admin/submissions/"+support.ID)
+	if rr := env.do(t, "POST", "/admin/submissions/"+support.ID+"/spam", url.Values{"_csrf": {token}}, cookie); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a viewer changing a submission, got %v", rr.Code)
+	}
+
+	env.session = env.signIn(t, "editor")
+	if rr := env.do(t, "POST", "/admin/submissions/"+support.ID+"/spam", url.Values{"_csrf": {token}}, cookie); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected an editor to change a granted submission, got %v", rr.Code)
+	}
+	if rr := env.do(t, "POST", "/admin/submissions/"+sales.ID+"/spam", url.Values{"_csrf": {token}}, cookie); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an editor changing another form, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_ListAndDetail(t *testing.T) {
+	env := newAdminTestEnv(t)
+
+	for _, sub := range []*models.Submission{
+		{Form: "default", Data: models.FormData{Name: "Alice", Email: "alice@example.com", Subject: "Pricing question"}},
+		{Form: "default", Data: models.FormData{Name: "Bob", Email: "bob@example.com", Subject: "Cheap pills"}, Spam: true},
+	} {
+		if err := env.store.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := env.do(t, "GET", "/admin/submissions", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "Pricing question") || strings.Contains(body, "Cheap pills") {
+		t.Error("Expected inbox to list ham but not spam")
+	}
+
+	rr = env.do(t, "GET", "/admin/submissions?status=spam", nil)
+	if body := rr.Body.String(); strings.Contains(body, "Pricing question") || !strings.Contains(body, "Cheap pills") {
+		t.Error("Expected spam filter to list only spam")
+	}
+
+	rr = env.do(t, "GET", "/admin/submissions?status=all&q=alice", nil)
+	if body := rr.Body.String(); !strings.Contains(body, "Pricing question") || strings.Contains(body, "Cheap pills") {
+		t.Error("Expected search to match by email")
+	}
+
+	subs, _, _ := env.store.ListSubmissions(store.Query{Search: "alice"})
+	rr = env.do(t, "GET", "/admin/submissions/"+subs[0].ID, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "alice@example.com") {
+		t.Errorf("Expected detail page for Alice, got %v", rr.Code)
+	}
+
+	if rr := env.do(t, "GET", "/admin/submissions/missing", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown submission, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_Export(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.addUser(t, "viewer", models.RoleViewer, "support")
+
+	base := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	for i, sub := range []*models.Submission{
+		{Form: "support", Data: models.FormData{Name: "Sam", Subject: "Old request"}},
+		{Form: "support", Data: models.FormData{Name: "Sid", Subject: "New request"}},
+		{Form: "sales", Data: models.FormData{Name: "Sue", Subject: "Sales lead"}},
+	} {
+		sub.CreatedAt = base.AddDate(0, 0, i*7)
+		if err := env.store.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := env.do(t, "GET", "/admin/submissions?from=2024-05-13&to=2024-05-20", nil)
+	if !strings.Contains(rr.Body.String(), `href="/admin/submissions/export?bom=true&amp;format=csv&amp;from=2024-05-13&amp;to=2024-05-20"`) {
+		t.Errorf("Expected an export link with the filters, got %s", rr.Body.String())
+	}
+
+	rr = env.do(t, "GET", "/admin/submissions/export?from=2024-05-13&to=2024-05-13", nil)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("Expected a CSV download, got %v", rr.Code)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "New request") || strings.Contains(body, "Old request") || strings.Contains(body, "Sales lead") {
+		t.Errorf("Expected only the day's submission, got %s", body)
+	}
+
+	env.session = env.signIn(t, "viewer")
+	rr = env.do(t, "GET", "/admin/submissions/export?format=jsonl&form=sales", nil)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Sales lead") {
+		t.Errorf("Expected the export not to bypass grants, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := env.do(t, "GET", "/admin/submissions/export?format=doc", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_Actions(t *testing.T) {
+	env := newAdminTestEnv(t)
+
+	sub := &models.Submission{Form: "default", Data: models.FormData{Name: "Alice", Email: "alice@example.com"}}
+	if err := env.store.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+	detailURL := "/admin/submissions/" + sub.ID
+
+	cookie, token := env.csrf(t, detailURL)
+	form := url.Values{"_csrf": {token}}
+
+	if rr := env.do(t, "POST", detailURL+"/spam", url.Values{"_csrf": {"forged"}}, cookie); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 with a forged CSRF token, got %v", rr.Code)
+	}
+
+	if rr := env.do(t, "POST", detailURL+"/spam", form, cookie); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %v", rr.Code)
+	}
+	if got, _ := env.store.GetSubmission(sub.ID); !got.Spam {
+		t.Error("Expected submission to be marked as spam")
+	}
+
+	env.do(t, "POST", detailURL+"/ham", form, cookie)
+	if got, _ := env.store.GetSubmission(sub.ID); got.Spam {
+		t.Error("Expected submission to be marked as ham")
+	}
+
+	env.emailService.shouldFail = true
+	env.do(t, "POST", detailURL+"/resend", form, cookie)
+	got, _ := env.store.GetSubmission(sub.ID)
+	if delivery := got.Delivery(services.ChannelEmail); delivery.Status != models.DeliveryFailed || delivery.Attempts != 1 {
+		t.Errorf("Expected failed delivery after 1 attempt, got %+v", delivery)
+	}
+
+	rr := env.do(t, "GET", "/admin/submissions?delivery=failed", nil)
+	if !strings.Contains(rr.Body.String(), detailURL) {
+		t.Error("Expected failed delivery filter to list the submission")
+	}
+
+	env.emailService.shouldFail = false
+	env.do(t, "POST", detailURL+"/resend", form, cookie)
+	got, _ = env.store.GetSubmission(sub.ID)
+	if delivery := got.Delivery(services.ChannelEmail); delivery.Status != models.DeliverySent || delivery.Attempts != 2 {
+		t.Errorf("Expected sent delivery after 2 attempts, got %+v", delivery)
+	}
+	if env.emailService.lastForm.Name != "Alice" {
+		t.Error("Expected resend to use the stored submission")
+	}
+
+	if rr := env.do(t, "POST", detailURL+"/archive", form, cookie); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown action, got %v", rr.Code)
+	}
+
+	if rr := env.do(t, "POST", detailURL+"/delete", form, cookie); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected status 303 after delete, got %v", rr.Code)
+	}
+	if _, err := env.store.GetSubmission(sub.ID); err == nil {
+		t.Error("Expected submission to be deleted")
+	}
+}
+
+func TestAdminHandler_Tickets(t *testing.T) {
+	env := newAdminTestEnv(t)
+	env.addUser(t, "agent", models.RoleEditor, "support")
+	env.addUser(t, "viewer", models.RoleViewer, "support")
+
+	sub := &models.Submission{Form: "support", Data: models.FormData{Name: "Sam", Subject: "Printer on fire"}}
+	other := &models.Submission{Form: "support", Data: models.FormData{Name: "Sid", Subject: "Password reset"}}
+	for _, s := range []*models.Submission{sub, other} {
+		if err := env.store.CreateSubmission(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.session = env.signIn(t, "agent")
+	cookie, token := env.csrf(t, "/admin/submissions/"+sub.ID)
+	form := url.Values{"_csrf": {token}, "status": {models.TicketInProgress}, "assignee": {"agent"}, "tags": {"Hardware, urgent"}}
+	if rr := env.do(t, "POST", "/admin/submissions/"+sub.ID+"/ticket", form, cookie); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after updating the ticket, got %v", rr.Code)
+	}
+	note := url.Values{"_csrf": {token}, "note": {"Sent a technician"}}
+	if rr := env.do(t, "POST", "/admin/submissions/"+sub.ID+"/note", note, cookie); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after adding a note, got %v", rr.Code)
+	}
+
+	got, _ := env.store.GetSubmission(sub.ID)
+	if got.Status != models.TicketInProgress || got.Assignee != "agent" || strings.Join(got.Tags, ",") != "hardware,urgent" {
+		t.Errorf("Unexpected ticket fields %q %q %v", got.Status, got.Assignee, got.Tags)
+	}
+	if len(got.Events) != 4 || got.Events[3].Body != "Sent a technician" || got.Events[0].Actor != "agent" {
+		t.Errorf("Expected three changes and a note on the timeline, got %+v", got.Events)
+	}
+
+	rr := env.do(t, "GET", "/admin/submissions/"+sub.ID, nil)
+	if body := rr.Body.String(); !strings.Contains(body, "Sent a technician") || !strings.Contains(body, "Assigned to agent") {
+		t.Error("Expected the timeline on the detail page")
+	}
+
+	form.Set("assignee", "admin-less")
+	rr = env.do(t, "POST", "/admin/submissions/"+sub.ID+"/ticket", form, cookie)
+	if got, _ := env.store.GetSubmission(sub.ID); got.Assignee != "agent" || !strings.Contains(rr.Header().Get("Location"), "no+account") {
+		t.Errorf("Expected an unknown assignee to be refused, got %q", rr.Header().Get("Location"))
+	}
+
+	rr = env.do(t, "GET", "/admin/submissions?assignee=me&ticket=in_progress", nil)
+	if body := rr.Body.String(); !strings.Contains(body, "Printer on fire") || strings.Contains(body, "Password reset") {
+		t.Error("Expected the assignee and status filters to apply")
+	}
+	rr = env.do(t, "GET", "/admin/submissions?assignee=none", nil)
+	if body := rr.Body.String(); strings.Contains(body, "Printer on fire") || !strings.Contains(body, "Password reset") {
+		t.Error("Expected the unassigned filter to apply")
+	}
+	rr = env.do(t, "GET", "/admin/submissions?tag=urgent", nil)
+	if body := rr.Body.String(); !strings.Contains(body, "Printer on fire") || strings.Contains(body, "Password reset") {
+		t.Error("Expected the tag filter to apply")
+	}
+
+	env.session = env.signIn(t, "viewer")
+	if rr := env.do(t, "POST", "/admin/submissions/"+sub.ID+"/note", note, cookie); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a viewer adding a note, got %v", rr.Code)
+	}
+}
+
+func TestAdminHandler_Replies(t *testing.T) {
+	env := newAdminTestEnv(t)
+	sub := &models.Submission{
+		Form:    "support",
+		Data:    models.FormData{Name: "Sam", Email: "sam@example.com", Subject: "Printer on fire"},
+		Options: models.EmailOptions{ReplyTo: "sam@example.com", MessageID: "<notification@example.com>"},
+	}
+	if err := env.store.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie, token := env.csrf(t, "/admin/replies")
+	form := url.Values{"_csrf": {token}, "name": {"Thanks"}, "subject": {"About {{ subject }}"}, "body": {"Hi {{ name }}, we are on it ({{ id }})."}}
+	if rr := env.do(t, "POST", "/admin/replies", form, cookie); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after saving a template, got %v", rr.Code)
+	}
+	templates, _ := env.store.ListReplyTemplates()
+	if len(templates) != 1 {
+		t.Fatalf("Expected 1 reply template, got %d", len(templates))
+	}
+
+	rr := env.do(t, "GET", "/admin/submissions/"+sub.ID+"?template="+templates[0].ID, nil)
+	body := rr.Body.String()
+	if !strings.Contains(body, "About Printer on fire") || !strings.Contains(body, "Hi Sam, we are on it ("+sub.ID+").") {
+		t.Error("Expected the template to prefill the reply with the submitted fields")
+	}
+
+	for i, subject := range []string{"Re: Printer on fire", "Re: Printer on fire again"} {
+		reply := url.Values{"_csrf": {token}, "subject": {subject}, "body": {"A technician is on the way."}}
+		if rr := env.do(t, "POST", "/admin/submissions/"+sub.ID+"/reply", reply, cookie); rr.Code != http.StatusSeeOther {
+			t.Fatalf("Expected a redirect after replying, got %v", rr.Code)
+		}
+		sent := env.emailService.lastReply
+		if sent.To != "sam@example.com" || sent.ReplyTo != "team@example.com" || sent.Subject != subject {
+			t.Errorf("Unexpected reply %+v", sent)
+		}
+		if !strings.HasSuffix(sent.MessageID, "@example.com>") || sent.InReplyTo != sent.References[len(sent.References)-1] {
+			t.Errorf("Unexpected threading headers %+v", sent)
+		}
+		if len(sent.References) != i+1 || sent.References[0] != "<notification@example.com>" {
+			t.Errorf("Expected reply %d to reference the earlier messages, got %v", i, sent.References)
+		}
+	}
+
+	got, _ := env.store.GetSubmission(sub.ID)
+	if len(got.Events) != 2 || got.Events[1].Kind != models.EventReply || got.Events[1].To != "sam@example.com" || got.Events[1].MessageID == "" {
+		t.Errorf("Expected the replies on the timeline, got %+v", got.Events)
+	}
+
+	env.emailService.shouldFail = true
+	reply := url.Values{"_csrf": {token}, "subject": {"Re: Printer"}, "body": {"Lost"}}
+	rr = env.do(t, "POST", "/admin/submissions/"+sub.ID+"/reply", reply, cookie)
+	if got, _ := env.store.GetSubmission(sub.ID); len(got.Events) != 2 || !strings.Contains(rr.Header().Get("Location"), "Reply+failed") {
+		t.Errorf("Expected a failed reply to be reported and not recorded, got %q", rr.Header().Get("Location"))
+	}
+
+	if rr := env.do(t, "POST", "/admin/replies/"+templates[0].ID+"/delete", url.Values{"_csrf": {token}}, cookie); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after deleting the template, got %v", rr.Code)
+	}
+	if templates, _ := env.store.ListReplyTemplates(); len(templates) != 0 {
+		t.Error("Expected the template to be deleted")
+	}
+}
+
+func TestAdminHandler_APIKeys(t *testing.T) {
+	env := newAdminTestEnv(t)
+
+	cookie, token := env.csrf(t, "/admin/api-keys")
+
+	rr := env.do(t, "POST", "/admin/api-keys", url.Values{"_csrf": {token}, "name": {"CRM"}, "scope": {models.ScopeSubmissionsRead, "admin:everything"}}, cookie)
+	plain := regexp.MustCompile(`ff_[A-Za-z0-9_-]+`).FindString(rr.Body.String())
+	if plain == "" {
+		t.Fatalf("Expected the new key to be shown, got %s", rr.Body.String())
+	}
+	key, err := env.store.AuthenticateAPIKey(plain)
+	if err != nil {
+		t.Fatalf("Expected the shown key to authenticate: %v", err)
+	}
+	if len(key.Scopes) != 1 || key.Scopes[0] != models.ScopeSubmissionsRead {
+		t.Errorf("Expected unknown scopes to be dropped, got %v", key.Scopes)
+	}
+
+	rr = env.do(t, "POST", "/admin/api-keys", url.Values{"_csrf": {token}, "name": {"Empty"}}, cookie)
+	if !strings.Contains(rr.Body.String(), "Select at least one scope") {
+		t.Error("Expected a key without scopes to be rejected")
+	}
+
+	if rr := env.do(t, "POST", "/admin/api-keys/"+key.ID+"/delete", url.Values{"_csrf": {token}}, cookie); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status 303, got %v", rr.Code)
+	}
+	if _, err := env.store.AuthenticateAPIKey(plain); err == nil {
+		t.Error("Expected the revoked key to be rejected")
+	}
+}
+
+func TestSubmitHandler_StoresSubmissions(t *testing.T) {
+	submissionStore, err := store.Open(filepath.Join(t.TempDir(), "formfling.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer submissionStore.Close()
+
+	cfg := &config.Config{FormTitle: "Test Form"}
+	emailService := &mockEmailService{shouldFail: true}
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, submissionStore)
+
+	values := url.Values{
+		"name":    {"John Doe"},
+		"email":   {"john@example.com"},
+		"message": {strings.Repeat("This is a valid message with enough characters. ", 7)},
+	}
+	rr := httptest.NewRecorder()
+	handler.HandleFormspree(rr, newFormspreeRequest(t, "default", values))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500 when email fails, got %v", rr.Code)
+	}
+
+	values.Set("_gotcha", "bot")
+	handler.HandleFormspree(httptest.NewRecorder(), newFormspreeRequest(t, "default", values))
+
+	subs, _, err := submissionStore.ListSubmissions(store.Query{Spam: store.SpamAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Expected 2 stored submissions, got %d", len(subs))
+	}
+	if !subs[0].Spam || subs[0].Delivery(services.ChannelEmail).Status != models.DeliverySkipped {
+		t.Errorf("Expected honeypot submission stored as spam, got %+v", subs[0])
+	}
+	if subs[1].Spam || subs[1].Delivery(services.ChannelEmail).Status != models.DeliveryFailed {
+		t.Errorf("Expected failed delivery to be recorded, got %+v", subs[1])
+	}
+	if id := subs[1].Options.MessageID; id == "" || id != emailService.lastOptions.MessageID {
+		t.Errorf("Expected the notification Message-ID to be stored, got %q", id)
+	}
+}
@@ -0,0 +1,563 @@
+package handlers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+)
+
+// APIHandler serves the versioned admin REST API under /api/v1. Requests
+// authenticate with a bearer API key whose scopes gate each endpoint.
+type APIHandler struct {
+	config       *config.Config
+	store        *store.Store
+	emailService services.EmailSender
+	oidcService  *services.OIDCService
+}
+
+func NewAPIHandler(cfg *config.Config, submissionStore *store.Store, emailService services.EmailSender, oidcService *services.OIDCService) *APIHandler {
+	return &APIHandler{
+		config:       cfg,
+		store:        submissionStore,
+		emailService: emailService,
+		oidcService:  oidcService,
+	}
+}
+
+type apiContextKey struct{}
+
+// apiPrincipal is the caller of an API request: an API key, which sees every
+// form, or a single sign-on user limited to their forms
+type apiPrincipal struct {
+	scopes []string
+	user   *models.User
+	// actor names the caller on submission timelines
+	actor string
+}
+
+func (p *apiPrincipal) hasScope(scope string) bool {
+	for _, s := range p.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *apiPrincipal) canAccess(form string) bool {
+	return p.user == nil || p.user.CanAccess(form)
+}
+
+func principal(r *http.Request) *apiPrincipal {
+	p, _ := r.Context().Value(apiContextKey{}).(*apiPrincipal)
+	return p
+}
+
+// SubmissionUpdate changes the ticket fields of a submission. Fields left
+// out keep their value.
+type SubmissionUpdate struct {
+	Status   *string   `json:"status"`
+	Assignee *string   `json:"assignee"`
+	Tags     *[]string `json:"tags"`
+	Spam     *bool     `json:"spam"`
+}
+
+// NoteRequest adds an internal note to a submission
+type NoteRequest struct {
+	Body string `json:"body"`
+}
+
+// SubmissionPage is a page of submissions with the cursor of the next page
+type SubmissionPage struct {
+	Data       []*models.Submission `json:"data"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// FormList holds every configured form
+type FormList struct {
+	Data []*config.Form `json:"data"`
+}
+
+// Authorize wraps an endpoint so it requires an API key, or a token from the
+// identity provider, with the scope
+func (h *APIHandler) Authorize(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token = strings.TrimSpace(token)
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="FormFling API"`)
+			h.writeError(w, "missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		p, err := h.authenticate(r, token)
+		if errors.Is(err, store.ErrNotFound) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="FormFling API", error="invalid_token"`)
+			h.writeError(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, services.ErrOIDCDenied) || errors.Is(err, services.ErrOIDCAccountConflict) {
+			h.writeError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error authenticating API request", "error", err)
+			h.writeError(w, "error authenticating API request", http.StatusInternalServerError)
+			return
+		}
+		if !p.hasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="FormFling API", error="insufficient_scope", scope=%q`, scope))
+			h.writeError(w, "API key lacks scope "+scope, http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		next(w, r.WithContext(context.WithValue(r.Context(), apiContextKey{}, p)))
+	}
+}
+
+// authenticate resolves a bearer token. FormFling API keys carry a prefix;
+// anything else is treated as a JWT from the identity provider.
+func (h *APIHandler) authenticate(r *http.Request, token string) (*apiPrincipal, error) {
+	if strings.HasPrefix(token, store.APIKeyPrefix) || !h.oidcService.Enabled() {
+		key, err := h.store.AuthenticateAPIKey(token)
+		if err != nil {
+			return nil, err
+		}
+		return &apiPrincipal{scopes: key.Scopes, actor: "API key " + key.Name}, nil
+	}
+
+	user, err := h.oidcService.VerifyBearer(r.Context(), token)
+	if errors.Is(err, services.ErrOIDCDenied) || errors.Is(err, services.ErrOIDCAccountConflict) {
+		return nil, err
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected API token", "error", err)
+		return nil, store.ErrNotFound
+	}
+	return &apiPrincipal{scopes: models.RoleScopes(user.Role), user: user, actor: user.Username}, nil
+}
+
+// OpenAPI serves the OpenAPI 3 description of the API
+func (h *APIHandler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// ListSubmissions returns a page of submissions matching the query filters
+func (h *APIHandler) ListSubmissions(w http.ResponseWriter, r *http.Request) {
+	query, err := h.parseQuery(r)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subs, next, err := h.store.ListSubmissions(query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing submissions", "error", err)
+		h.writeError(w, "error loading submissions", http.StatusInternalServerError)
+		return
+	}
+	if subs == nil {
+		subs = []*models.Submission{}
+	}
+
+	h.writeJSON(w, http.StatusOK, SubmissionPage{Data: subs, NextCursor: next})
+}
+
+// ExportSubmissions streams every matching submission as JSON lines, CSV or
+// XLSX
+func (h *APIHandler) ExportSubmissions(w http.ResponseWriter, r *http.Request) {
+	query, err := h.parseQuery(r)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := parseExportOptions(h.config, r.URL.Query(), services.ExportJSONL)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var forms []*config.Form
+	for _, form := range h.config.AllForms() {
+		if principal(r).canAccess(form.Slug) {
+			forms = append(forms, form)
+		}
+	}
+	writeExport(w, h.store, query, forms, opts)
+}
+
+// GetSubmission returns a single submission
+func (h *APIHandler) GetSubmission(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+	h.writeJSON(w, http.StatusOK, sub)
+}
+
+// DeleteSubmission removes a submission
+func (h *APIHandler) DeleteSubmission(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteSubmission(sub.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting submission", "error", err)
+		h.writeError(w, "error deleting submission", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateSubmission changes the status, assignee, tags or spam flag of a
+// submission and records the changes on its timeline
+func (h *APIHandler) UpdateSubmission(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	var update SubmissionUpdate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		h.writeError(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if update.Status != nil && !models.ValidTicketStatus(*update.Status) {
+		h.writeError(w, "status must be open, in_progress or closed", http.StatusBadRequest)
+		return
+	}
+	if update.Assignee != nil {
+		if err := checkAssignee(h.store, sub.Form, *update.Assignee); err != nil {
+			h.writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	actor := principal(r).actor
+	err := h.store.ModifySubmission(sub.ID, func(stored *models.Submission) error {
+		if update.Status != nil {
+			stored.SetStatus(actor, *update.Status)
+		}
+		if update.Assignee != nil {
+			stored.SetAssignee(actor, *update.Assignee)
+		}
+		if update.Tags != nil {
+			stored.SetTags(actor, *update.Tags)
+		}
+		if update.Spam != nil {
+			stored.SetSpam(actor, *update.Spam)
+		}
+		sub = stored
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating submission", "submission", sub.ID, "error", err)
+		h.writeError(w, "error updating submission", http.StatusInternalServerError)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, sub)
+}
+
+// AddNote adds an internal note to the timeline of a submission
+func (h *APIHandler) AddNote(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	var note NoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+		h.writeError(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if note.Body = strings.TrimSpace(note.Body); note.Body == "" {
+		h.writeError(w, "body is required", http.StatusBadRequest)
+		return
+	}
+
+	var event models.Event
+	err := h.store.ModifySubmission(sub.ID, func(stored *models.Submission) error {
+		event = stored.AddNote(principal(r).actor, note.Body)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating submission", "submission", sub.ID, "error", err)
+		h.writeError(w, "error updating submission", http.StatusInternalServerError)
+		return
+	}
+	h.writeJSON(w, http.StatusCreated, event)
+}
+
+// ReplaySubmission sends the notification email of a submission again
+func (h *APIHandler) ReplaySubmission(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	sendErr := services.DeliverEmail(r.Context(), h.emailService, sub)
+	err := h.store.ModifySubmission(sub.ID, func(stored *models.Submission) error {
+		copyDelivery(stored, sub)
+		sub = stored
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating submission", "submission", sub.ID, "error", err)
+		h.writeError(w, "error updating submission", http.StatusInternalServerError)
+		return
+	}
+	if sendErr != nil {
+		slog.ErrorContext(r.Context(), "Error replaying submission", "submission", sub.ID, "error", sendErr)
+		h.writeError(w, "delivery failed: "+sendErr.Error(), http.StatusBadGateway)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, sub)
+}
+
+// ListForms returns every configured form
+func (h *APIHandler) ListForms(w http.ResponseWriter, r *http.Request) {
+	forms := []*config.Form{}
+	for _, form := range h.config.AllForms() {
+		if principal(r).canAccess(form.Slug) {
+			forms = append(forms, form)
+		}
+	}
+	h.writeJSON(w, http.StatusOK, FormList{Data: forms})
+}
+
+// GetForm returns a single form
+func (h *APIHandler) GetForm(w http.ResponseWriter, r *http.Request) {
+	form, ok := h.config.Form(mux.Vars(r)["slug"])
+	if !ok || !principal(r).canAccess(form.Slug) {
+		h.writeError(w, "form not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, form)
+}
+
+// CreateForm adds a new form
+func (h *APIHandler) CreateForm(w http.ResponseWriter, r *http.Request) {
+	form, ok := h.decodeForm(w, r)
+	if !ok {
+		return
+	}
+	if _, exists := h.config.Form(form.Slug); exists {
+		h.writeError(w, "form "+form.Slug+" already exists", http.StatusConflict)
+		return
+	}
+	if h.saveForm(w, form) {
+		h.writeJSON(w, http.StatusCreated, form)
+	}
+}
+
+// UpdateForm replaces an existing form
+func (h *APIHandler) UpdateForm(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	if _, exists := h.config.Form(slug); !exists {
+		h.writeError(w, "form not found", http.StatusNotFound)
+		return
+	}
+	form, ok := h.decodeForm(w, r)
+	if !ok {
+		return
+	}
+	if form.Slug != slug {
+		h.writeError(w, "slug in body does not match the URL", http.StatusBadRequest)
+		return
+	}
+	if h.saveForm(w, form) {
+		h.writeJSON(w, http.StatusOK, form)
+	}
+}
+
+// DeleteForm removes a form. Its submissions are kept.
+func (h *APIHandler) DeleteForm(w http.ResponseWriter, r *http.Request) {
+	if !h.formsWritable(w) {
+		return
+	}
+	slug := mux.Vars(r)["slug"]
+	err := h.store.DeleteForm(slug)
+	if errors.Is(err, store.ErrNotFound) {
+		h.writeError(w, "form not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting form", "form", slug, "error", err)
+		h.writeError(w, "error deleting form", http.StatusInternalServerError)
+		return
+	}
+	h.config.RemoveForm(slug)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// formsWritable rejects form changes unless forms live in the database
+func (h *APIHandler) formsWritable(w http.ResponseWriter) bool {
+	if h.config.FormsBackend != config.FormsBackendDB {
+		h.writeError(w, "forms are read-only; set FORMS_BACKEND=db to manage them through the API", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func (h *APIHandler) decodeForm(w http.ResponseWriter, r *http.Request) (*config.Form, bool) {
+	if !h.formsWritable(w) {
+		return nil, false
+	}
+
+	form := &config.Form{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(form); err != nil {
+		h.writeError(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if r.Method == http.MethodPut && form.Slug == "" {
+		form.Slug = mux.Vars(r)["slug"]
+	}
+	// Templates are server file paths and stay under the operator's control
+	if form.Template != "" {
+		h.writeError(w, "template can only be set in the forms file", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := config.ValidateForm(form); err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return form, true
+}
+
+func (h *APIHandler) saveForm(w http.ResponseWriter, form *config.Form) bool {
+	if err := h.store.PutForm(form); err != nil {
+		slog.Error("Error saving form", "form", form.Slug, "error", err)
+		h.writeError(w, "error saving form", http.StatusInternalServerError)
+		return false
+	}
+	h.config.PutForm(form)
+	return true
+}
+
+// parseQuery reads the submission filters shared by list and export
+func (h *APIHandler) parseQuery(r *http.Request) (store.Query, error) {
+	params := r.URL.Query()
+	query := store.Query{
+		Form:   params.Get("form"),
+		Search: params.Get("q"),
+		Failed: params.Get("delivery") == models.DeliveryFailed,
+		Cursor: params.Get("cursor"),
+		Limit:  apiDefaultLimit,
+	}
+
+	switch spam := params.Get("spam"); spam {
+	case "", "exclude":
+		query.Spam = store.SpamExclude
+	case "only":
+		query.Spam = store.SpamOnly
+	case "all":
+		query.Spam = store.SpamAll
+	default:
+		return query, fmt.Errorf("spam must be exclude, only or all")
+	}
+
+	if delivery := params.Get("delivery"); delivery != "" && delivery != models.DeliveryFailed {
+		return query, fmt.Errorf("delivery filter must be failed")
+	}
+
+	if query.Status = params.Get("status"); query.Status != "" && !models.ValidTicketStatus(query.Status) {
+		return query, fmt.Errorf("status must be open, in_progress or closed")
+	}
+	if assignee := params.Get("assignee"); assignee == "none" {
+		query.Unassigned = true
+	} else {
+		query.Assignee = strings.ToLower(assignee)
+	}
+	query.Tag = params.Get("tag")
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", apiMaxLimit)
+		}
+		query.Limit = n
+	}
+
+	if p := principal(r); p != nil && p.user != nil && !p.user.IsOwner() {
+		query.Forms = append([]string{}, p.user.Forms...)
+	}
+
+	var err error
+	if query.Since, err = parseAPITime(params.Get("since")); err != nil {
+		return query, fmt.Errorf("since: %v", err)
+	}
+	if query.Until, err = parseAPITime(params.Get("until")); err != nil {
+		return query, fmt.Errorf("until: %v", err)
+	}
+
+	return query, nil
+}
+
+// parseAPITime accepts RFC 3339 timestamps and plain dates
+func parseAPITime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date")
+}
+
+func (h *APIHandler) loadSubmission(w http.ResponseWriter, r *http.Request) (*models.Submission, bool) {
+	sub, err := h.store.GetSubmission(mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !principal(r).canAccess(sub.Form)) {
+		h.writeError(w, "submission not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading submission", "error", err)
+		h.writeError(w, "error loading submission", http.StatusInternalServerError)
+		return nil, false
+	}
+	return sub, true
+}
+
+func (h *APIHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding API response", "error", err)
+	}
+}
+
+func (h *APIHandler) writeError(w http.ResponseWriter, errorMsg string, statusCode int) {
+	h.writeJSON(w, statusCode, models.Response{Status: "error", Error: errorMsg})
+}
@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+type apiTestEnv struct {
+	router       *mux.Router
+	config       *config.Config
+	store        *store.Store
+	emailService *mockEmailService
+}
+
+func newAPITestEnv(t *testing.T, formsBackend string) *apiTestEnv {
+	t.Helper()
+	cfg := &config.Config{
+		FormTitle:    "Test Form",
+		ToEmail:      "owner@example.com",
+		FormsBackend: formsBackend,
+	}
+
+	submissionStore, err := store.Open(filepath.Join(t.TempDir(), "formfling.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { submissionStore.Close() })
+
+	emailService := &mockEmailService{}
+	h := NewAPIHandler(cfg, submissionStore, emailService, nil)
+
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/openapi.json", h.OpenAPI).Methods("GET")
+	api.HandleFunc("/submissions", h.Authorize(models.ScopeSubmissionsRead, h.ListSubmissions)).Methods("GET")
+	api.HandleFunc("/submissions/export", h.Authorize(models.ScopeSubmissionsRead, h.ExportSubmissions)).Methods("GET")
+	api.HandleFunc("/submissions/{id}", h.Authorize(models.ScopeSubmissionsRead, h.GetSubmission)).Methods("GET")
+	api.HandleFunc("/submissions/{id}", h.Authorize(models.ScopeSubmissionsWrite, h.UpdateSubmission)).Methods("PATCH")
+	api.HandleFunc("/submissions/{id}", h.Authorize(models.ScopeSubmissionsDelete, h.DeleteSubmission)).Methods("DELETE")
+	api.HandleFunc("/submissions/{id}/notes", h.Authorize(models.ScopeSubmissionsWrite, h.AddNote)).Methods("POST")
+	api.HandleFunc("/submissions/{id}/replay", h.Authorize(models.ScopeSubmissionsReplay, h.ReplaySubmission)).Methods("POST")
+	api.HandleFunc("/forms", h.Authorize(models.ScopeFormsRead, h.ListForms)).Methods("GET")
+	api.HandleFunc("/forms", h.Authorize(models.ScopeFormsWrite, h.CreateForm)).Methods("POST")
+	api.HandleFunc("/forms/{slug}", h.Authorize(models.ScopeFormsRead, h.GetForm)).Methods("GET")
+	api.HandleFunc("/forms/{slug}", h.Authorize(models.ScopeFormsWrite, h.UpdateForm)).Methods("PUT")
+	api.HandleFunc("/forms/{slug}", h.Authorize(models.ScopeFormsWrite, h.DeleteForm)).Methods("DELETE")
+
+	return &apiTestEnv{router: r, config: cfg, store: submissionStore, emailService: emailService}
+}
+
+func (env *apiTestEnv) key(t *testing.T, scopes ...string) string {
+	t.Helper()
+	_, plain, err := env.store.CreateAPIKey("test", scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func (env *apiTestEnv) do(t *testing.T, method, target, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rr := httptest.NewRecorder()
+	env.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIHandler_Authorization(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	readKey := env.key(t, models.ScopeSubmissionsRead)
+
+	if rr := env.do(t, "GET", "/api/v1/submissions", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a key, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/api/v1/submissions", "ff_unknown", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with an unknown key, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/api/v1/submissions", readKey, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 with a read key, got %v", rr.Code)
+	}
+
+	rr := env.do(t, "DELETE", "/api/v1/submissions/abc", readKey, "")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 deleting with a read key, got %v", rr.Code)
+	}
+	if !strings.Contains(rr.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+		t.Errorf("Expected insufficient_scope challenge, got %q", rr.Header().Get("WWW-Authenticate"))
+	}
+
+	if rr := env.do(t, "GET", "/api/v1/openapi.json", "", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the OpenAPI document without a key, got %v", rr.Code)
+	} else {
+		var spec map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil || spec["openapi"] == nil {
+			t.Errorf("Expected a valid OpenAPI document: %v", err)
+		}
+	}
+}
+
+func TestAPIHandler_ListSubmissions(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	key := env.key(t, models.ScopeSubmissionsRead)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, form := range []string{"default", "support", "support", "support"} {
+		sub := &models.Submission{
+			Form:      form,
+			CreatedAt: base.Add(time.Duration(i) * 24 * time.Hour),
+			Data:      models.FormData{Name: "Sender", Email: "sender@example.com"},
+		}
+		if err := env.store.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var page SubmissionPage
+	rr := env.do(t, "GET", "/api/v1/submissions?form=support&limit=2", key, "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(page.Data) != 2 || page.NextCursor == "" {
+		t.Fatalf("Expected a full first page with a cursor, got %d items, cursor %q", len(page.Data), page.NextCursor)
+	}
+
+	rr = env.do(t, "GET", "/api/v1/submissions?form=support&limit=2&cursor="+page.NextCursor, key, "")
+	page = SubmissionPage{}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if len(page.Data) != 1 || page.NextCursor != "" {
+		t.Errorf("Expected a last page of 1, got %d items, cursor %q", len(page.Data), page.NextCursor)
+	}
+
+	rr = env.do(t, "GET", "/api/v1/submissions?since=2024-03-02&until=2024-03-04", key, "")
+	page = SubmissionPage{}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if len(page.Data) != 2 {
+		t.Errorf("Expected 2 submissions in the date range, got %d", len(page.Data))
+	}
+
+	for _, query := range []string{"limit=0", "limit=abc", "spam=maybe", "since=yesterday", "delivery=sent"} {
+		if rr := env.do(t, "GET", "/api/v1/submissions?"+query, key, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %v", query, rr.Code)
+		}
+	}
+
+	rr = env.do(t, "GET", "/api/v1/submissions/export?form=support", key, "")
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON export, got %q", ct)
+	}
+	if lines := strings.Count(rr.Body.String(), "\n"); lines != 3 {
+		t.Errorf("Expected 3 exported lines, got %d", lines)
+	}
+}
+
+func TestAPIHandler_SubmissionActions(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	key := env.key(t, models.ScopeSubmissionsRead, models.ScopeSubmissionsDelete, models.ScopeSubmissionsReplay)
+
+	sub := &models.Submission{Form: "default", Data: models.FormData{Name: "Jane", Email: "jane@example.com"}}
+	if err := env.store.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := env.do(t, "GET", "/api/v1/submissions/"+sub.ID, key, "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "jane@example.com") {
+		t.Errorf("Expected the submission, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	env.emailService.shouldFail = true
+	if rr := env.do(t, "POST", "/api/v1/submissions/"+sub.ID+"/replay", key, ""); rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 when delivery fails, got %v", rr.Code)
+	}
+	env.emailService.shouldFail = false
+	if rr := env.do(t, "POST", "/api/v1/submissions/"+sub.ID+"/replay", key, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 when delivery succeeds, got %v", rr.Code)
+	}
+	got, _ := env.store.GetSubmission(sub.ID)
+	if d := got.Delivery("email"); d.Status != models.DeliverySent || d.Attempts != 2 {
+		t.Errorf("Expected both attempts recorded, got %+v", d)
+	}
+
+	if rr := env.do(t, "DELETE", "/api/v1/submissions/"+sub.ID, key, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/api/v1/submissions/"+sub.ID, key, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %v", rr.Code)
+	}
+}
+
+func TestAPIHandler_Tickets(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	key := env.key(t, models.ScopeSubmissionsRead, models.ScopeSubmissionsWrite)
+	for _, user := range []*models.User{
+		{Username: "alice", Role: models.RoleEditor, Forms: []string{"default"}},
+		{Username: "bob", Role: models.RoleViewer, Forms: []string{"sales"}},
+	} {
+		if err := env.store.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub := &models.Submission{Form: "default", Data: models.FormData{Name: "Jane", Email: "jane@example.com"}}
+	other := &models.Submission{Form: "default", Data: models.FormData{Name: "John", Email: "john@example.com"}}
+	for _, s := range []*models.Submission{sub, other} {
+		if err := env.store.CreateSubmission(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := env.do(t, "PATCH", "/api/v1/submissions/"+sub.ID, key, `{"status":"in_progress","assignee":"alice","tags":["Billing","vip"]}`)
+	var got models.Submission
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || got.Status != models.TicketInProgress || got.Assignee != "alice" || len(got.Tags) != 2 {
+		t.Fatalf("Expected the ticket to be updated, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if len(got.Events) != 3 || got.Events[0].Actor != "API key test" {
+		t.Errorf("Expected three recorded changes by the key, got %+v", got.Events)
+	}
+
+	rr = env.do(t, "POST", "/api/v1/submissions/"+sub.ID+"/notes", key, `{"body":"Called back, waiting on invoice"}`)
+	if rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"kind":"note"`) {
+		t.Errorf("Expected the note to be created, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	for query, want := range map[string]string{
+		"status=in_progress": sub.ID,
+		"status=open":        other.ID,
+		"assignee=alice":     sub.ID,
+		"assignee=none":      other.ID,
+		"tag=billing":        sub.ID,
+	} {
+		var page SubmissionPage
+		json.Unmarshal(env.do(t, "GET", "/api/v1/submissions?"+query, key, "").Body.Bytes(), &page)
+		if len(page.Data) != 1 || page.Data[0].ID != want {
+			t.Errorf("Expected only %s for %s, got %d results", want, query, len(page.Data))
+		}
+	}
+
+	for _, body := range []string{`{"status":"done"}`, `{"assignee":"bob"}`, `{"assignee":"nobody"}`, `{"priority":"high"}`} {
+		if rr := env.do(t, "PATCH", "/api/v1/submissions/"+sub.ID, key, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %v", body, rr.Code)
+		}
+	}
+	if rr := env.do(t, "POST", "/api/v1/submissions/"+sub.ID+"/notes", key, `{"body":"  "}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty note, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/api/v1/submissions?status=done", key, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown status filter, got %v", rr.Code)
+	}
+
+	readOnly := env.key(t, models.ScopeSubmissionsRead)
+	if rr := env.do(t, "PATCH", "/api/v1/submissions/"+sub.ID, readOnly, `{"status":"closed"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without submissions:write, got %v", rr.Code)
+	}
+}
+
+func TestAPIHandler_ConcurrentNotes(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	sub := &models.Submission{Form: "default", Data: models.FormData{Name: "Jane", Email: "jane@example.com"}}
+	if err := env.store.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two agents note the same submission at once while one of them also
+	// moves the ticket along
+	const notes = 20
+	var wg sync.WaitGroup
+	for _, agent := range []string{"alice", "bob"} {
+		_, key, err := env.store.CreateAPIKey(agent, []string{models.ScopeSubmissionsWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < notes; i++ {
+			wg.Add(1)
+			go func(body string) {
+				defer wg.Done()
+				if rr := env.do(t, "POST", "/api/v1/submissions/"+sub.ID+"/notes", key, body); rr.Code != http.StatusCreated {
+					t.Errorf("Expected 201 adding a note, got %v", rr.Code)
+				}
+			}(fmt.Sprintf(`{"body":"%s %d"}`, agent, i))
+		}
+		if agent == "alice" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if rr := env.do(t, "PATCH", "/api/v1/submissions/"+sub.ID, key, `{"status":"in_progress"}`); rr.Code != http.StatusOK {
+					t.Errorf("Expected 200 updating the ticket, got %v", rr.Code)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	got, err := env.store.GetSubmission(sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, event := range got.Events {
+		seen[event.Kind+" "+event.Body+event.To] = true
+	}
+	for _, agent := range []string{"alice", "bob"} {
+		for i := 0; i < notes; i++ {
+			if note := fmt.Sprintf("note %s %d", agent, i); !seen[note] {
+				t.Errorf("Expected %q on the timeline", note)
+			}
+		}
+	}
+	if !seen["status "+models.TicketInProgress] || got.Status != models.TicketInProgress {
+		t.Errorf("Expected the status change to be kept, got %q", got.Status)
+	}
+}
+
+func TestAPIHandler_Forms(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendDB)
+	key := env.key(t, models.ScopeFormsRead, models.ScopeFormsWrite)
+
+	rr := env.do(t, "POST", "/api/v1/forms", key, `{"slug":"support","title":"Support","fields":[{"name":"topic","type":"select","options":["Billing","Other"]}]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if form, ok := env.config.Form("support"); !ok || form.Fields[0].Label != "topic" {
+		t.Errorf("Expected the form to be live with defaults filled in, got %+v", form)
+	}
+	if rr := env.do(t, "POST", "/api/v1/forms", key, `{"slug":"support"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate slug, got %v", rr.Code)
+	}
+
+	for _, body := range []string{
+		`{"slug":"Bad Slug"}`,
+		`{"slug":"x","fields":[{"name":"topic","type":"select"}]}`,
+		`{"slug":"x","template":"/etc/passwd"}`,
+		`{"slug":"x","unknown":true}`,
+	} {
+		if rr := env.do(t, "POST", "/api/v1/forms", key, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %v", body, rr.Code)
+		}
+	}
+
+	rr = env.do(t, "PUT", "/api/v1/forms/support", key, `{"title":"Help desk"}`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := env.do(t, "PUT", "/api/v1/forms/support", key, `{"slug":"other"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a mismatched slug, got %v", rr.Code)
+	}
+
+	var list FormList
+	rr = env.do(t, "GET", "/api/v1/forms", key, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Data) != 2 || list.Data[0].Slug != config.DefaultFormSlug || list.Data[1].Title != "Help desk" {
+		t.Errorf("Unexpected form list: %s", rr.Body.String())
+	}
+
+	stored, _ := env.store.LoadForms()
+	if stored["support"] == nil || stored["support"].Title != "Help desk" {
+		t.Errorf("Expected the form to be persisted, got %+v", stored)
+	}
+
+	if rr := env.do(t, "DELETE", "/api/v1/forms/support", key, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/api/v1/forms/support", key, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %v", rr.Code)
+	}
+}
+
+func TestAPIHandler_FormsReadOnlyWithFileBackend(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	key := env.key(t, models.ScopeFormsRead, models.ScopeFormsWrite)
+
+	if rr := env.do(t, "POST", "/api/v1/forms", key, `{"slug":"support"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 creating forms with the file backend, got %v", rr.Code)
+	}
+	if rr := env.do(t, "GET", "/api/v1/forms/default", key, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the default form to be readable, got %v", rr.Code)
+	}
+}
+
+func TestAPIHandler_ExportFormats(t *testing.T) {
+	env := newAPITestEnv(t, config.FormsBackendFile)
+	env.config.SetForms(map[string]*config.Form{
+		"quote": {Slug: "quote", Title: "Quote", ToEmail: "owner@example.com", Fields: []models.FieldDefinition{
+			{Name: "name", Type: "text"},
+			{Name: "email", Type: "email"},
+			{Name: "company", Type: "text"},
+			{Name: "budget", Type: "text"},
+		}},
+	})
+	key := env.key(t, models.ScopeSubmissionsRead)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	subs := []*models.Submission{
+		{Form: "quote", Data: models.FormData{Name: "Jane", Email: "jane@example.com"},
+			Extra: []models.ExtraField{{Name: "company", Value: "Acme"}, {Name: "budget", Value: "5k"}}},
+		{Form: "quote", Data: models.FormData{Name: "John", Email: "john@example.com"},
+			Extra: []models.ExtraField{{Name: "utm_source", Value: "newsletter"}}},
+		{Form: "default", Data: models.FormData{Name: "Eve", Email: "eve@example.com", Message: "=HYPERLINK(\"http://evil\")"}},
+	}
+	for i, sub := range subs {
+		sub.CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		if err := env.store.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := env.do(t, "GET", "/api/v1/submissions/export?format=csv&form=quote&delimiter=semicolon&bom=true", key, "")
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Expected CSV, got %q", ct)
+	}
+	body, ok := strings.CutPrefix(rr.Body.String(), "\ufeff")
+	if !ok {
+		t.Error("Expected a byte order mark")
+	}
+	reader := csv.NewReader(strings.NewReader(body))
+	reader.Comma = ';'
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	wantHeader := "id form created_at spam delivery origin status assignee tags name email company budget utm_source"
+	if len(records) != 3 || strings.Join(records[0], " ") != wantHeader {
+		t.Fatalf("Expected header %q and 2 rows, got %v", wantHeader, records)
+	}
+	if records[1][9] != "John" || records[1][13] != "newsletter" || records[2][11] != "Acme" || records[2][2] != "2024-03-01T12:00:00Z" || records[2][6] != "open" {
+		t.Errorf("Unexpected rows %v", records[1:])
+	}
+
+	rr = env.do(t, "GET", "/api/v1/submissions/export?format=csv&form=default", key, "")
+	if !strings.Contains(rr.Body.String(), `'=HYPERLINK`) {
+		t.Errorf("Expected formulas to be neutralized, got %s", rr.Body.String())
+	}
+
+	rr = env.do(t, "GET", "/api/v1/submissions/export?format=xlsx", key, "")
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Invalid XLSX archive: %v", err)
+	}
+	var sheet string
+	for _, f := range archive.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, _ := f.Open()
+			raw, _ := io.ReadAll(r)
+			sheet = string(raw)
+		}
+	}
+	if strings.Count(sheet, "<row>") != 4 || !strings.Contains(sheet, "<t>utm_source</t>") || !strings.Contains(sheet, ">Acme<") {
+		t.Errorf("Unexpected sheet %s", sheet)
+	}
+	if !strings.Contains(sheet, "=HYPERLINK(&#34;http://evil&#34;)") {
+		t.Errorf("Expected the message as escaped text, got %s", sheet)
+	}
+
+	for _, query := range []string{"format=pdf", "format=csv&delimiter=x", "format=csv&bom=maybe"} {
+		if rr := env.do(t, "GET", "/api/v1/submissions/export?"+query, key, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %v", query, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"formfling/internal/config"
+	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// DevMailHandler shows the messages captured in development mode
+type DevMailHandler struct {
+	catcher         *services.MailCatcher
+	devMailTemplate *template.Template
+}
+
+func NewDevMailHandler(cfg *config.Config, catcher *services.MailCatcher) *DevMailHandler {
+	devMailHandler, err := LoadDevMailHandler(cfg, catcher)
+	if err != nil {
+		log.Fatal("Error loading dev mail template:", err)
+	}
+	return devMailHandler
+}
+
+// LoadDevMailHandler is NewDevMailHandler for callers that handle a broken
+// template themselves
+func LoadDevMailHandler(cfg *config.Config, catcher *services.MailCatcher) (*DevMailHandler, error) {
+	devMailTemplate, err := template.ParseFiles(cfg.DevMailTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DevMailHandler{
+		catcher:         catcher,
+		devMailTemplate: devMailTemplate,
+	}, nil
+}
+
+type DevMailData struct {
+	Messages []*services.CapturedMail
+	Selected *services.CapturedMail
+	View     string
+}
+
+// DevMailList is the JSON body listing captured messages
+type DevMailList struct {
+	Messages []*services.CapturedMail `json:"messages"`
+}
+
+// Inbox lists the captured messages and shows the one in the URL as HTML,
+// plain text or raw source
+func (h *DevMailHandler) Inbox(w http.ResponseWriter, r *http.Request) {
+	data := DevMailData{Messages: h.catcher.Messages(), View: r.URL.Query().Get("view")}
+	if id := mux.Vars(r)["id"]; id != "" {
+		if data.Selected = h.catcher.Message(id); data.Selected == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if data.Selected != nil && data.View == "" {
+		data.View = "text"
+		if data.Selected.HTML != "" {
+			data.View = "html"
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.devMailTemplate.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering dev mail page", "error", err)
+	}
+}
+
+// HTML serves the HTML body of a message for the inbox's frame. The sandbox
+// policy keeps scripts in the message from running.
+func (h *DevMailHandler) HTML(w http.ResponseWriter, r *http.Request) {
+	msg := h.catcher.Message(mux.Vars(r)["id"])
+	if msg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write([]byte(msg.HTML))
+}
+
+// Clear empties the inbox
+func (h *DevMailHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	h.catcher.Clear()
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/dev/mail", http.StatusSeeOther)
+}
+
+// ListJSON returns the captured messages, newest first. The to parameter
+// only returns messages sent to that address.
+func (h *DevMailHandler) ListJSON(w http.ResponseWriter, r *http.Request) {
+	to := strings.ToLower(r.URL.Query().Get("to"))
+	list := DevMailList{Messages: []*services.CapturedMail{}}
+	for _, msg := range h.catcher.Messages() {
+		if to == "" || containsFold(msg.Recipients, to) {
+			list.Messages = append(list.Messages, msg)
+		}
+	}
+	h.writeJSON(w, list)
+}
+
+// GetJSON returns one captured message
+func (h *DevMailHandler) GetJSON(w http.ResponseWriter, r *http.Request) {
+	msg := h.catcher.Message(mux.Vars(r)["id"])
+	if msg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.writeJSON(w, msg)
+}
+
+func (h *DevMailHandler) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding dev mail response", "error", err)
+	}
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
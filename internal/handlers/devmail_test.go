@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDevMailHandler(t *testing.T) {
+	cfg := &config.Config{
+		FromEmail:       "formfling@localhost",
+		FromName:        "FormFling",
+		ToEmail:         "inbox@localhost",
+		FormTitle:       "Contact Me",
+		Timezone:        "UTC",
+		EmailTemplate:   "../../web/templates/email_template.html",
+		DevMailTemplate: "../../web/templates/devmail_template.html",
+	}
+	catcher := services.NewMailCatcher(10)
+	emailService := services.NewEmailService(cfg)
+	emailService.SetTransport(catcher)
+
+	formData := models.FormData{Name: "Jane", Email: "jane@example.com", Message: "Hello <b>there</b>"}
+	if err := emailService.SendEmail(context.Background(), formData, "https://example.com", models.EmailOptions{ReplyTo: "jane@example.com"}); err != nil {
+		t.Fatalf("SendEmail returned error: %v", err)
+	}
+	reply := models.Reply{To: "jane@example.com", Subject: "Re: Hello", Body: "Thanks, Jané!", MessageID: "<r1@localhost>", InReplyTo: "<n1@localhost>", References: []string{"<n1@localhost>"}}
+	if err := emailService.SendReply(context.Background(), reply); err != nil {
+		t.Fatalf("SendReply returned error: %v", err)
+	}
+
+	handler := NewDevMailHandler(cfg, catcher)
+	r := mux.NewRouter()
+	r.HandleFunc("/dev/mail", handler.Inbox).Methods("GET")
+	r.HandleFunc("/dev/mail/clear", handler.Clear).Methods("POST")
+	r.HandleFunc("/dev/mail/api/messages", handler.ListJSON).Methods("GET")
+	r.HandleFunc("/dev/mail/api/messages", handler.Clear).Methods("DELETE")
+	r.HandleFunc("/dev/mail/api/messages/{id}", handler.GetJSON).Methods("GET")
+	r.HandleFunc("/dev/mail/{id}", handler.Inbox).Methods("GET")
+	r.HandleFunc("/dev/mail/{id}/html", handler.HTML).Methods("GET")
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+
+	var list DevMailList
+	if err := json.NewDecoder(do("GET", "/dev/mail/api/messages").Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Messages) != 2 {
+		t.Fatalf("Expected 2 captured messages, got %d", len(list.Messages))
+	}
+	replyMail, notification := list.Messages[0], list.Messages[1]
+	if notification.Subject != "New submission from Contact Me" || notification.Recipients[0] != "inbox@localhost" || notification.Headers["Reply-To"] != "jane@example.com" {
+		t.Errorf("Unexpected notification %+v", notification)
+	}
+	if !strings.Contains(notification.HTML, "Jane") || notification.Headers["Message-Id"] == "" {
+		t.Errorf("Expected the rendered notification with a Message-ID, got %+v", notification)
+	}
+	if replyMail.Text != "Thanks, Jané!" || replyMail.Headers["In-Reply-To"] != "<n1@localhost>" {
+		t.Errorf("Unexpected reply %+v", replyMail)
+	}
+
+	if err := json.NewDecoder(do("GET", "/dev/mail/api/messages?to=jane@example.com").Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Messages) != 1 || list.Messages[0].ID != replyMail.ID {
+		t.Errorf("Expected the to filter to return the reply only, got %+v", list.Messages)
+	}
+
+	if rr := do("GET", "/dev/mail/api/messages/"+replyMail.ID); !strings.Contains(rr.Body.String(), "Re: Hello") {
+		t.Errorf("Expected the message as JSON, got %s", rr.Body.String())
+	}
+	if rr := do("GET", "/dev/mail/api/messages/99"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown message, got %v", rr.Code)
+	}
+
+	rr := do("GET", "/dev/mail/"+notification.ID)
+	if body := rr.Body.String(); !strings.Contains(body, "New submission from Contact Me") || !strings.Contains(body, `src="/dev/mail/`+notification.ID+`/html"`) {
+		t.Error("Expected the inbox to show the HTML view of the notification")
+	}
+	if rr := do("GET", "/dev/mail/"+notification.ID+"?view=raw"); !strings.Contains(rr.Body.String(), "MIME-Version: 1.0") {
+		t.Error("Expected the raw source view")
+	}
+	rr = do("GET", "/dev/mail/"+notification.ID+"/html")
+	if rr.Header().Get("Content-Security-Policy") != "sandbox" || !strings.Contains(rr.Body.String(), "Jane") {
+		t.Error("Expected the sandboxed HTML body")
+	}
+
+	if rr := do("DELETE", "/dev/mail/api/messages"); rr.Code != http.StatusNoContent || len(catcher.Messages()) != 0 {
+		t.Errorf("Expected the inbox to be cleared, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// EmbedHandler tells the embed script how to submit a form: where to post it
+// and which bot protection to run first
+type EmbedHandler struct {
+	config     *config.Config
+	powService *services.ProofOfWorkService
+}
+
+func NewEmbedHandler(cfg *config.Config, powService *services.ProofOfWorkService) *EmbedHandler {
+	return &EmbedHandler{
+		config:     cfg,
+		powService: powService,
+	}
+}
+
+// EmbedConfig is the JSON document returned for each form
+type EmbedConfig struct {
+	Action    string                 `json:"action"`
+	Recaptcha *EmbedRecaptcha        `json:"recaptcha,omitempty"`
+	Pow       *services.PowChallenge `json:"pow,omitempty"`
+}
+
+type EmbedRecaptcha struct {
+	SiteKey string `json:"siteKey"`
+	Action  string `json:"action"`
+}
+
+func (h *EmbedHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	if _, ok := h.config.Form(slug); !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.FormspreeResponse{Error: "form not found"})
+		return
+	}
+
+	embed := EmbedConfig{Action: "/f/" + slug}
+
+	if h.config.RecaptchaEnabled && h.config.RecaptchaSiteKey != "" {
+		embed.Recaptcha = &EmbedRecaptcha{
+			SiteKey: h.config.RecaptchaSiteKey,
+			Action:  h.config.RecaptchaAction,
+		}
+	}
+
+	if h.powService.Enabled() {
+		challenge, err := h.powService.NewChallenge()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error creating proof-of-work challenge", "error", err)
+			http.Error(w, "Error creating challenge", http.StatusInternalServerError)
+			return
+		}
+		embed.Pow = &challenge
+	}
+
+	// Challenges are single use, so the response must never be cached
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(embed)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"formfling/internal/config"
+	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// solvePow brute-forces a proof-of-work challenge the way the embed script does
+func solvePow(t *testing.T, pow *services.PowChallenge) string {
+	t.Helper()
+	for nonce := 0; nonce < 1<<24; nonce++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", pow.Challenge, nonce)))
+		zeros := 0
+		for _, b := range sum {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= pow.Difficulty {
+			return fmt.Sprintf("%s:%d", pow.Challenge, nonce)
+		}
+	}
+	t.Fatal("no proof-of-work solution found")
+	return ""
+}
+
+func getEmbedConfig(t *testing.T, handler *EmbedHandler, slug string) (*httptest.ResponseRecorder, EmbedConfig) {
+	t.Helper()
+	req, err := http.NewRequest("GET", "/f/"+slug+"/config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"slug": slug})
+
+	rr := httptest.NewRecorder()
+	handler.Handle(rr, req)
+
+	var embed EmbedConfig
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &embed); err != nil {
+			t.Fatalf("Could not unmarshal response: %v", err)
+		}
+	}
+	return rr, embed
+}
+
+func TestEmbedHandler(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle:          "Test Form",
+		RecaptchaEnabled:   true,
+		RecaptchaSiteKey:   "site-key",
+		RecaptchaSecretKey: "secret-key",
+		RecaptchaAction:    "submit",
+		PowDifficulty:      8,
+	}
+	handler := NewEmbedHandler(cfg, services.NewProofOfWorkService(cfg))
+
+	rr, embed := getEmbedConfig(t, handler, "default")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got %s", rr.Header().Get("Cache-Control"))
+	}
+	if embed.Action != "/f/default" {
+		t.Errorf("Expected action /f/default, got %s", embed.Action)
+	}
+	if embed.Recaptcha == nil || embed.Recaptcha.SiteKey != "site-key" || embed.Recaptcha.Action != "submit" {
+		t.Errorf("Expected reCAPTCHA settings, got %+v", embed.Recaptcha)
+	}
+	if embed.Pow == nil || embed.Pow.Difficulty != 8 || embed.Pow.Challenge == "" {
+		t.Errorf("Expected proof-of-work challenge, got %+v", embed.Pow)
+	}
+
+	rr, _ = getEmbedConfig(t, handler, "missing")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown form, got %v", rr.Code)
+	}
+}
+
+func TestEmbedHandler_NoProtection(t *testing.T) {
+	cfg := &config.Config{FormTitle: "Test Form"}
+	handler := NewEmbedHandler(cfg, services.NewProofOfWorkService(cfg))
+
+	_, embed := getEmbedConfig(t, handler, "default")
+	if embed.Recaptcha != nil || embed.Pow != nil {
+		t.Errorf("Expected no bot protection settings, got %+v", embed)
+	}
+}
+
+func TestSubmitHandler_ProofOfWork(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle:     "Test Form",
+		PowDifficulty: 8,
+	}
+	powService := services.NewProofOfWorkService(cfg)
+	embedHandler := NewEmbedHandler(cfg, powService)
+	submitHandler := NewSubmitHandler(cfg, &mockEmailService{}, nil, powService, nil, nil)
+
+	_, embed := getEmbedConfig(t, embedHandler, "default")
+	solution := solvePow(t, embed.Pow)
+
+	submit := func(pow string) int {
+		formData := url.Values{
+			"name":    {"John Doe"},
+			"email":   {"john@example.com"},
+			"message": {strings.Repeat("This is a valid message with enough characters. ", 7)},
+			"_pow":    {pow},
+		}
+		req := newFormspreeRequest(t, "default", formData)
+		rr := httptest.NewRecorder()
+		submitHandler.HandleFormspree(rr, req)
+		return rr.Code
+	}
+
+	if code := submit(""); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a solution, got %v", code)
+	}
+	if code := submit(embed.Pow.Challenge + "tampered:0"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a tampered challenge, got %v", code)
+	}
+	if code := submit(solution); code != http.StatusOK {
+		t.Errorf("Expected status 200 for a valid solution, got %v", code)
+	}
+	if code := submit(solution); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a replayed solution, got %v", code)
+	}
+}
+
+// newMultipartRequest posts fields as multipart/form-data with the headers
+// the embed script sends along with its FormData body
+func newMultipartRequest(t *testing.T, slug string, fields [][2]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/f/"+slug, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	return mux.SetURLVars(req, map[string]string{"slug": slug})
+}
+
+func TestSubmitHandler_EmbedMultipart(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle:     "Test Form",
+		ToEmail:       "recipient@example.com",
+		PowDifficulty: 8,
+		MaxBodyBytes:  4096,
+	}
+	powService := services.NewProofOfWorkService(cfg)
+	embedHandler := NewEmbedHandler(cfg, powService)
+	emailService := &mockEmailService{}
+	submitHandler := NewSubmitHandler(cfg, emailService, nil, powService, nil, nil)
+
+	_, embed := getEmbedConfig(t, embedHandler, "default")
+	message := strings.Repeat("This is a valid message with enough characters. ", 7)
+
+	tests := []struct {
+		name   string
+		fields [][2]string
+		want   int
+	}{
+		{"script submission", [][2]string{
+			{"name", "John Doe"},
+			{"email", "john@example.com"},
+			{"message", message},
+			{"_pow", solvePow(t, embed.Pow)},
+		}, http.StatusOK},
+		{"missing fields", [][2]string{{"name", "John Doe"}, {"_pow", solvePow(t, embed.Pow)}}, http.StatusBadRequest},
+		{"over the body limit", [][2]string{{"name", "John Doe"}, {"message", strings.Repeat("x", 8192)}}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			submitHandler.HandleFormspree(rr, newMultipartRequest(t, "default", tt.fields))
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+	if emailService.lastForm.Name != "John Doe" || emailService.lastForm.Message != strings.TrimSpace(message) {
+		t.Errorf("Expected the multipart fields to be delivered, got %+v", emailService.lastForm)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+// parseExportOptions reads the format, delimiter and bom parameters shared by
+// the API and dashboard exports
+func parseExportOptions(cfg *config.Config, params url.Values, defaultFormat string) (services.ExportOptions, error) {
+	opts := services.ExportOptions{Format: params.Get("format"), Location: services.ExportLocation(cfg)}
+	if opts.Format == "" {
+		opts.Format = defaultFormat
+	}
+	if !services.ValidExportFormat(opts.Format) {
+		return opts, fmt.Errorf("format must be csv, jsonl or xlsx")
+	}
+
+	var err error
+	if opts.Delimiter, err = services.ParseDelimiter(params.Get("delimiter")); err != nil {
+		return opts, err
+	}
+	if bom := params.Get("bom"); bom != "" {
+		if opts.BOM, err = strconv.ParseBool(bom); err != nil {
+			return opts, fmt.Errorf("bom must be true or false")
+		}
+	}
+	return opts, nil
+}
+
+// exportPageTimeout bounds writing one page of an export. Large exports may
+// take longer than WRITE_TIMEOUT in all, so the deadline moves on with every
+// page, but a stalled download is still cut off.
+const exportPageTimeout = time.Minute
+
+// writeExport streams an export as a file download
+func writeExport(w http.ResponseWriter, s *store.Store, query store.Query, forms []*config.Form, opts services.ExportOptions) {
+	rc := http.NewResponseController(w)
+	opts.BeforePage = func() {
+		// The error only says the writer has no deadline to move
+		_ = rc.SetWriteDeadline(time.Now().Add(exportPageTimeout))
+	}
+	w.Header().Set("Content-Type", services.ExportContentType(opts.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", services.ExportFileName(opts.Format, time.Now().In(opts.Location))))
+	if err := services.ExportSubmissions(w, s, query, forms, opts); err != nil {
+		// Headers are gone by now, so the truncated body is all we can signal
+		slog.Error("Error exporting submissions", "error", err)
+	}
+}
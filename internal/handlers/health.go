@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"formfling/internal/models"
+	"formfling/internal/services"
 )
 
 type HealthHandler struct{}
@@ -18,3 +19,23 @@ func (h *HealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	response := models.Response{Status: "ok"}
 	json.NewEncoder(w).Encode(response)
 }
+
+// ReadinessHandler answers /readyz with the result of each readiness check,
+// and with 503 Service Unavailable when a critical one failed
+type ReadinessHandler struct {
+	readiness *services.Readiness
+}
+
+func NewReadinessHandler(readiness *services.Readiness) *ReadinessHandler {
+	return &ReadinessHandler{readiness: readiness}
+}
+
+func (h *ReadinessHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	report := h.readiness.Run(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"formfling/internal/models"
+	"formfling/internal/services"
 )
 
 func TestHealthHandler(t *testing.T) {
@@ -45,3 +49,72 @@ func TestHealthHandler(t *testing.T) {
 		t.Errorf("Expected no error, got %s", response.Error)
 	}
 }
+
+func TestReadinessHandler(t *testing.T) {
+	smtpChecks := 0
+	smtpErr := errors.New("535 authentication failed")
+	readiness := services.NewReadiness(
+		services.ReadinessCheck{
+			Name:     "smtp",
+			Critical: true,
+			Interval: time.Hour,
+			Check: func(context.Context) (string, error) {
+				smtpChecks++
+				return "", smtpErr
+			},
+		},
+		services.ReadinessCheck{
+			Name:  "templates",
+			Check: func(context.Context) (string, error) { return "", errors.New("reload rejected") },
+		},
+		services.ReadinessCheck{
+			Name:     "store",
+			Critical: true,
+			Check:    func(context.Context) (string, error) { return "reachable", nil },
+		},
+	)
+	handler := NewReadinessHandler(readiness)
+
+	get := func() (int, services.ReadinessReport) {
+		rr := httptest.NewRecorder()
+		handler.Handle(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var report services.ReadinessReport
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Could not unmarshal response: %v", err)
+		}
+		return rr.Code, report
+	}
+
+	code, report := get()
+	if code != http.StatusServiceUnavailable || report.Ready {
+		t.Errorf("Expected 503 while SMTP fails, got %d (ready %v)", code, report.Ready)
+	}
+	want := map[string]string{"smtp": services.CheckFail, "templates": services.CheckWarn, "store": services.CheckOK}
+	for name, status := range want {
+		if got := report.Checks[name].Status; got != status {
+			t.Errorf("Expected %s to be %s, got %s", name, status, got)
+		}
+	}
+	if report.Checks["smtp"].Error != smtpErr.Error() {
+		t.Errorf("Expected the SMTP error in the details, got %q", report.Checks["smtp"].Error)
+	}
+
+	// The SMTP result is reused within its interval
+	smtpErr = nil
+	get()
+	if smtpChecks != 1 {
+		t.Errorf("Expected the SMTP check to run once, ran %d times", smtpChecks)
+	}
+}
+
+func TestReadinessHandler_Ready(t *testing.T) {
+	readiness := services.NewReadiness(services.ReadinessCheck{
+		Name:  "templates",
+		Check: func(context.Context) (string, error) { return "", errors.New("reload rejected") },
+	})
+	rr := httptest.NewRecorder()
+	NewReadinessHandler(readiness).Handle(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected warnings to leave the instance ready, got %d", rr.Code)
+	}
+}
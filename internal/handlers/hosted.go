@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// HostedFormHandler renders a complete form page from a form's field
+// definitions, for teams without a website to embed the form into
+type HostedFormHandler struct {
+	config       *config.Config
+	csrfService  *services.CSRFService
+	powService   *services.ProofOfWorkService
+	formTemplate *template.Template
+	overrides    map[string]*template.Template
+}
+
+func NewHostedFormHandler(cfg *config.Config, csrfService *services.CSRFService, powService *services.ProofOfWorkService) *HostedFormHandler {
+	hostedFormHandler, err := LoadHostedFormHandler(cfg, csrfService, powService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hostedFormHandler
+}
+
+// LoadHostedFormHandler is NewHostedFormHandler for callers that handle a
+// broken template themselves
+func LoadHostedFormHandler(cfg *config.Config, csrfService *services.CSRFService, powService *services.ProofOfWorkService) (*HostedFormHandler, error) {
+	formTemplate, err := template.ParseFiles(cfg.HostedFormTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error loading hosted form template: %v", err)
+	}
+
+	// Forms may bring their own template
+	overrides := make(map[string]*template.Template)
+	for _, form := range cfg.AllForms() {
+		if form.Template == "" {
+			continue
+		}
+		override, err := template.ParseFiles(form.Template)
+		if err != nil {
+			return nil, fmt.Errorf("error loading template for form %s: %v", form.Slug, err)
+		}
+		overrides[form.Slug] = override
+	}
+
+	return &HostedFormHandler{
+		config:       cfg,
+		csrfService:  csrfService,
+		powService:   powService,
+		formTemplate: formTemplate,
+		overrides:    overrides,
+	}, nil
+}
+
+type HostedFormData struct {
+	Slug             string
+	FormTitle        string
+	Description      string
+	SubmitLabel      string
+	Action           string
+	Fields           []models.FieldDefinition
+	Theme            config.Theme
+	CSRFField        string
+	CSRFToken        string
+	HoneypotField    string
+	RecaptchaSiteKey string
+	RecaptchaAction  string
+	PowEnabled       bool
+}
+
+func (h *HostedFormHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	form, ok := h.config.Form(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, err := h.csrfService.Issue(w, r, form.Slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error issuing CSRF token", "error", err)
+		http.Error(w, "Error rendering form page", http.StatusInternalServerError)
+		return
+	}
+
+	data := HostedFormData{
+		Slug:          form.Slug,
+		FormTitle:     form.Title,
+		Description:   form.Description,
+		SubmitLabel:   form.SubmitLabel,
+		Action:        "/f/" + form.Slug,
+		Fields:        form.FieldDefinitions(),
+		Theme:         form.Theme,
+		CSRFField:     services.CSRFFieldName,
+		CSRFToken:     token,
+		HoneypotField: "_gotcha",
+		PowEnabled:    h.powService.Enabled(),
+	}
+	if data.FormTitle == "" {
+		data.FormTitle = h.config.FormTitle
+	}
+	if data.SubmitLabel == "" {
+		data.SubmitLabel = "Send"
+	}
+	if h.config.RecaptchaEnabled {
+		data.RecaptchaSiteKey = h.config.RecaptchaSiteKey
+		data.RecaptchaAction = h.config.RecaptchaAction
+	}
+
+	tmpl := h.formTemplate
+	if override, ok := h.overrides[form.Slug]; ok {
+		tmpl = override
+	}
+
+	// The page embeds a per-browser CSRF token
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := tmpl.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering form page", "form", form.Slug, "error", err)
+		http.Error(w, "Error rendering form page", http.StatusInternalServerError)
+		return
+	}
+}
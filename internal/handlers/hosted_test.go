@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+func hostedTestConfig() *config.Config {
+	return &config.Config{
+		FormTitle:          "Contact Me",
+		HostedFormTemplate: "../../web/templates/form_template.html",
+		Forms: map[string]*config.Form{
+			"event": {
+				Slug:        "event",
+				Title:       "Event signup",
+				Description: "Reserve your seat",
+				SubmitLabel: "Register",
+				Fields: []models.FieldDefinition{
+					{Name: "name", Label: "Full name", Type: "text", Required: true},
+					{Name: "email", Label: "Email", Type: "email", Required: true},
+					{Name: "company", Label: "Company", Type: "text", MaxLength: 20},
+					{Name: "ticket", Label: "Ticket", Type: "select", Required: true, Options: []string{"Standard", "VIP"}},
+				},
+				Theme: config.Theme{AccentColor: "#ff6600"},
+			},
+		},
+	}
+}
+
+func getHostedForm(t *testing.T, handler *HostedFormHandler, slug string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest("GET", "/f/"+slug, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"slug": slug})
+
+	rr := httptest.NewRecorder()
+	handler.Handle(rr, req)
+	return rr
+}
+
+func TestHostedFormHandler_DefaultForm(t *testing.T) {
+	cfg := hostedTestConfig()
+	handler := NewHostedFormHandler(cfg, services.NewCSRFService(cfg), services.NewProofOfWorkService(cfg))
+
+	rr := getHostedForm(t, handler, "default")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+
+	body := rr.Body.String()
+	expected := []string{
+		"<title>Contact Me</title>",
+		`action="/f/default"`,
+		`data-formfling="default"`,
+		`<textarea id="field-message" name="message" required aria-required="true" minlength="300"`,
+		`<input type="email" id="field-email" name="email" required`,
+		`name="_gotcha"`,
+		`name="_csrf" value="`,
+		`<script src="/js/formfling.js" defer></script>`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Expected page to contain %q", e)
+		}
+	}
+
+	if !strings.Contains(rr.Header().Get("Set-Cookie"), services.CSRFCookieName+"=") {
+		t.Errorf("Expected CSRF cookie to be set, got %q", rr.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestHostedFormHandler_CustomFields(t *testing.T) {
+	cfg := hostedTestConfig()
+	handler := NewHostedFormHandler(cfg, services.NewCSRFService(cfg), services.NewProofOfWorkService(cfg))
+
+	rr := getHostedForm(t, handler, "event")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", rr.Code)
+	}
+
+	body := rr.Body.String()
+	expected := []string{
+		"Event signup",
+		"Reserve your seat",
+		`<label for="field-company">Company</label>`,
+		`maxlength="20"`,
+		`<option value="VIP">VIP</option>`,
+		"--ff-accent: #ff6600;",
+		">Register</button>",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Expected page to contain %q", e)
+		}
+	}
+	if strings.Contains(body, `name="message"`) {
+		t.Error("Expected custom form not to render the default message field")
+	}
+
+	if rr := getHostedForm(t, handler, "missing"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown form, got %v", rr.Code)
+	}
+}
+
+func TestHostedForm_SubmitWithCSRF(t *testing.T) {
+	cfg := hostedTestConfig()
+	cfg.Forms["event"].RequireCSRF = true
+	csrfService := services.NewCSRFService(cfg)
+	hostedHandler := NewHostedFormHandler(cfg, csrfService, services.NewProofOfWorkService(cfg))
+	emailService := &mockEmailService{}
+	submitHandler := NewSubmitHandler(cfg, emailService, nil, nil, csrfService, nil)
+
+	page := getHostedForm(t, hostedHandler, "event")
+	cookie := page.Result().Cookies()[0]
+	token := regexp.MustCompile(`name="_csrf" value="([^"]+)"`).FindStringSubmatch(page.Body.String())[1]
+
+	submit := func(values url.Values, withCookie bool) *httptest.ResponseRecorder {
+		req := newFormspreeRequest(t, "event", values)
+		if withCookie {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		submitHandler.HandleFormspree(rr, req)
+		return rr
+	}
+
+	values := url.Values{
+		"name":    {"Jane Doe"},
+		"email":   {"jane@example.com"},
+		"company": {"Acme"},
+		"ticket":  {"VIP"},
+		"ignored": {"not a defined field"},
+		"_csrf":   {token},
+	}
+
+	if rr := submit(values, false); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without CSRF cookie, got %v", rr.Code)
+	}
+
+	rr := submit(values, true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	extra := emailService.lastForm.Extra
+	if len(extra) != 2 || extra[0].Label != "Company" || extra[0].Value != "Acme" || extra[1].Value != "VIP" {
+		t.Errorf("Expected company and ticket extra fields, got %+v", extra)
+	}
+
+	values.Set("ticket", "Backstage")
+	values.Set("company", strings.Repeat("x", 21))
+	rr = submit(values, true)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid values, got %v", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"field":"company"`) || !strings.Contains(rr.Body.String(), `"field":"ticket"`) {
+		t.Errorf("Expected company and ticket field errors, got %s", rr.Body.String())
+	}
+
+	values.Del("_csrf")
+	if rr := submit(values, true); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 when a required CSRF token is missing, got %v", rr.Code)
+	}
+}
+
+// TestHostedForm_EndToEnd loads a hosted page and submits it the way its
+// embed script does: settings from /f/{slug}/config, then the form's fields
+// as multipart FormData with the page's cookies
+func TestHostedForm_EndToEnd(t *testing.T) {
+	cfg := hostedTestConfig()
+	cfg.ToEmail = "owner@example.com"
+	cfg.MaxBodyBytes = 1 << 20
+	cfg.PowDifficulty = 8
+	cfg.Forms["event"].RequireCSRF = true
+	csrfService := services.NewCSRFService(cfg)
+	powService := services.NewProofOfWorkService(cfg)
+	emailService := &mockEmailService{}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/f/{slug}", NewSubmitHandler(cfg, emailService, nil, powService, csrfService, nil).HandleFormspree).Methods("POST")
+	r.HandleFunc("/f/{slug}", NewHostedFormHandler(cfg, csrfService, powService).Handle).Methods("GET")
+	r.HandleFunc("/f/{slug}/config", NewEmbedHandler(cfg, powService).Handle).Methods("GET")
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	get := func(path string) string {
+		t.Helper()
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %v", path, resp.StatusCode)
+		}
+		return string(body)
+	}
+
+	page := get("/f/event")
+	if !strings.Contains(page, `data-formfling="event"`) || !strings.Contains(page, `src="/js/formfling.js"`) {
+		t.Fatalf("Expected the page to be enhanced by the embed script, got %s", page)
+	}
+	var settings EmbedConfig
+	if err := json.Unmarshal([]byte(get("/f/event/config")), &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	filled := map[string]string{"name": "Jane Doe", "email": "jane@example.com", "company": "Acme", "ticket": "VIP"}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, hidden := range regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)"`).FindAllStringSubmatch(page, -1) {
+		mw.WriteField(hidden[1], html.UnescapeString(hidden[2]))
+	}
+	for _, field := range regexp.MustCompile(`<(?:input|select|textarea)[^>]* name="([^"_][^"]*)"`).FindAllStringSubmatch(page, -1) {
+		value, ok := filled[field[1]]
+		if !ok {
+			t.Fatalf("Unexpected field %q on the page", field[1])
+		}
+		mw.WriteField(field[1], value)
+	}
+	mw.WriteField("_pow", solvePow(t, settings.Pow))
+	mw.Close()
+
+	req, err := http.NewRequest("POST", server.URL+settings.Action, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK bool `json:"ok"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || !result.OK {
+		t.Fatalf("Expected the submission to be accepted, got %v %+v", resp.StatusCode, result)
+	}
+	if emailService.lastForm.Name != "Jane Doe" || len(emailService.lastForm.Extra) != 2 {
+		t.Errorf("Expected the page's fields to be delivered, got %+v", emailService.lastForm)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"formfling/internal/services"
+)
+
+// OIDCHandler signs admin users in through the configured identity provider
+type OIDCHandler struct {
+	oidcService *services.OIDCService
+	authService *services.AuthService
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService, authService *services.AuthService) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: oidcService,
+		authService: authService,
+	}
+}
+
+// Login sends the browser to the identity provider
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, err := h.oidcService.AuthURL(w, r, safeAdminPath(r.URL.Query().Get("next")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting single sign-on", "error", err)
+		http.Redirect(w, r, "/admin/login?sso=unavailable", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback completes the sign-in when the provider redirects back
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	user, next, err := h.oidcService.Callback(w, r)
+	if errors.Is(err, services.ErrOIDCDenied) || errors.Is(err, services.ErrOIDCAccountConflict) {
+		slog.WarnContext(r.Context(), "Single sign-on refused", "error", err)
+		http.Redirect(w, r, "/admin/login?sso=denied", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Single sign-on failed", "error", err)
+		http.Redirect(w, r, "/admin/login?sso=failed", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.authService.StartSession(w, r, user); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting session", "user", user.Username, "error", err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin signed in with single sign-on", "user", user.Username)
+
+	http.Redirect(w, r, safeAdminPath(next), http.StatusSeeOther)
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/gorilla/mux"
+)
+
+const oidcTestClientID = "formfling"
+
+// mockOIDCProvider is a minimal identity provider serving discovery, keys and
+// a token endpoint that checks PKCE
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthCode
+}
+
+type mockAuthCode struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, codes: make(map[string]mockAuthCode)}
+
+	routes := http.NewServeMux()
+	routes.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	routes.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	routes.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		code, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "opaque",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     p.sign(t, code.claims),
+		})
+	})
+	p.Server = httptest.NewServer(routes)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// sign issues a token with the standard claims filled in
+func (p *mockOIDCProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	full := map[string]interface{}{
+		"iss": p.URL,
+		"aud": oidcTestClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		full[name] = value
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: p.key, KeyID: "test"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(full)
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+type oidcTestEnv struct {
+	router   *mux.Router
+	store    *store.Store
+	provider *mockOIDCProvider
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	t.Helper()
+	provider := newMockOIDCProvider(t)
+	cfg := &config.Config{
+		FormTitle:         "Test Form",
+		Timezone:          "UTC",
+		AdminTemplate:     "../../web/templates/admin_template.html",
+		OIDCIssuer:        provider.URL,
+		OIDCClientID:      oidcTestClientID,
+		OIDCRedirectURL:   "http://formfling.test/admin/oidc/callback",
+		OIDCScopes:        []string{"openid", "email"},
+		OIDCUsernameClaim: "email",
+		OIDCRoleClaim:     "groups",
+		OIDCRoleMapping:   []string{"formfling-admins=owner", "support=viewer"},
+		OIDCFormsClaim:    "forms",
+	}
+	cfg.SetForms(map[string]*config.Form{
+		"contact": {Slug: "contact", Title: "Contact", ToEmail: "owner@example.com"},
+		"support": {Slug: "support", Title: "Support", ToEmail: "owner@example.com"},
+	})
+
+	cfg.StorePath = filepath.Join(t.TempDir(), "formfling.db")
+	submissionStore, err := store.Open(cfg.StorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { submissionStore.Close() })
+
+	oidcService, err := services.NewOIDCService(cfg, submissionStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcService.SetHTTPClient(provider.Client())
+	authService := services.NewAuthService(submissionStore)
+	emailService := &mockEmailService{}
+	adminHandler := NewAdminHandler(cfg, submissionStore, emailService, services.NewCSRFService(cfg), authService)
+	oidcHandler := NewOIDCHandler(oidcService, authService)
+	apiHandler := NewAPIHandler(cfg, submissionStore, emailService, oidcService)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/login", adminHandler.LoginPage).Methods("GET")
+	r.HandleFunc("/admin/oidc/login", oidcHandler.Login).Methods("GET")
+	r.HandleFunc("/admin/oidc/callback", oidcHandler.Callback).Methods("GET")
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(adminHandler.RequireAuth)
+	admin.HandleFunc("/submissions", adminHandler.List).Methods("GET")
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/submissions", apiHandler.Authorize(models.ScopeSubmissionsRead, apiHandler.ListSubmissions)).Methods("GET")
+	api.HandleFunc("/submissions/{id}", apiHandler.Authorize(models.ScopeSubmissionsRead, apiHandler.GetSubmission)).Methods("GET")
+	api.HandleFunc("/submissions/{id}", apiHandler.Authorize(models.ScopeSubmissionsDelete, apiHandler.DeleteSubmission)).Methods("DELETE")
+	api.HandleFunc("/forms", apiHandler.Authorize(models.ScopeFormsRead, apiHandler.ListForms)).Methods("GET")
+
+	return &oidcTestEnv{router: r, store: submissionStore, provider: provider}
+}
+
+func (env *oidcTestEnv) do(method, target, bearer string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	env.router.ServeHTTP(rr, req)
+	return rr
+}
+
+// signIn runs the browser side of a sign-in in which the provider vouches for
+// claims, returning the callback response
+func (env *oidcTestEnv) signIn(t *testing.T, claims map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	start := env.do("GET", "/admin/oidc/login?next=/admin/submissions%3Fform%3Dcontact", "")
+	if start.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %v", start.Code)
+	}
+	authURL, err := url.Parse(start.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), env.provider.URL+"/authorize") {
+		t.Fatalf("Unexpected provider URL %q", start.Header().Get("Location"))
+	}
+	params := authURL.Query()
+	if params.Get("client_id") != oidcTestClientID || params.Get("code_challenge_method") != "S256" || params.Get("nonce") == "" {
+		t.Fatalf("Authorization request lacks client, PKCE or nonce: %v", params)
+	}
+
+	withNonce := map[string]interface{}{"nonce": params.Get("nonce")}
+	for name, value := range claims {
+		withNonce[name] = value
+	}
+	env.provider.mu.Lock()
+	env.provider.codes["code-1"] = mockAuthCode{challenge: params.Get("code_challenge"), claims: withNonce}
+	env.provider.mu.Unlock()
+
+	callback := "/admin/oidc/callback?code=code-1&state=" + url.QueryEscape(params.Get("state"))
+	return env.do("GET", callback, "", start.Result().Cookies()...)
+}
+
+func sessionCookie(rr *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == services.SessionCookieName && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCHandler_SignIn(t *testing.T) {
+	env := newOIDCTestEnv(t)
+
+	rr := env.signIn(t, map[string]interface{}{
+		"sub":    "user-1",
+		"email":  "Alice@Example.com",
+		"groups": []string{"staff", "support"},
+		"forms":  []string{"contact"},
+	})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/submissions?form=contact" {
+		t.Fatalf("Expected a redirect to the next page, got %v to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	session := sessionCookie(rr)
+	if session == nil {
+		t.Fatal("Expected a session cookie")
+	}
+
+	user, err := env.store.GetUser("alice@example.com")
+	if err != nil {
+		t.Fatalf("Expected the account to be created: %v", err)
+	}
+	if user.Role != models.RoleViewer || len(user.Forms) != 1 || user.Forms[0] != "contact" || user.PasswordHash != "" {
+		t.Errorf("Unexpected account %+v", user)
+	}
+
+	if page := env.do("GET", "/admin/submissions", "", session); page.Code != http.StatusOK {
+		t.Errorf("Expected the dashboard with the session, got %v", page.Code)
+	}
+
+	// The next sign-in picks up role changes at the provider
+	rr = env.signIn(t, map[string]interface{}{
+		"sub":    "user-1",
+		"email":  "alice@example.com",
+		"groups": []string{"formfling-admins"},
+	})
+	if sessionCookie(rr) == nil {
+		t.Fatalf("Expected a second sign-in, got %v to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if user, _ := env.store.GetUser("alice@example.com"); user.Role != models.RoleOwner {
+		t.Errorf("Expected role owner after the group change, got %q", user.Role)
+	}
+}
+
+func TestOIDCHandler_Refused(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	if err := env.store.CreateUser(&models.User{Username: "bob@example.com", PasswordHash: "x", Role: models.RoleOwner}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"unmapped group", map[string]interface{}{"sub": "user-2", "email": "carol@example.com", "groups": []string{"staff"}}},
+		{"local account", map[string]interface{}{"sub": "user-3", "email": "bob@example.com", "groups": []string{"formfling-admins"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := env.signIn(t, tt.claims)
+			if rr.Header().Get("Location") != "/admin/login?sso=denied" || sessionCookie(rr) != nil {
+				t.Errorf("Expected the sign-in to be refused, got %v to %q", rr.Code, rr.Header().Get("Location"))
+			}
+		})
+	}
+	if _, err := env.store.GetUser("carol@example.com"); err == nil {
+		t.Error("Expected no account for an unmapped user")
+	}
+}
+
+func TestOIDCHandler_CallbackChecks(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	start := env.do("GET", "/admin/oidc/login", "")
+	authURL, _ := url.Parse(start.Header().Get("Location"))
+	state := authURL.Query().Get("state")
+
+	tests := []struct {
+		name    string
+		target  string
+		cookies []*http.Cookie
+	}{
+		{"no state cookie", "/admin/oidc/callback?code=x&state=" + url.QueryEscape(state), nil},
+		{"state mismatch", "/admin/oidc/callback?code=x&state=forged", start.Result().Cookies()},
+		{"provider error", "/admin/oidc/callback?error=access_denied", start.Result().Cookies()},
+		{"unknown code", "/admin/oidc/callback?code=x&state=" + url.QueryEscape(state), start.Result().Cookies()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := env.do("GET", tt.target, "", tt.cookies...)
+			if rr.Header().Get("Location") != "/admin/login?sso=failed" || sessionCookie(rr) != nil {
+				t.Errorf("Expected the sign-in to fail, got %v to %q", rr.Code, rr.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestAPIHandler_OIDCBearer(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	for _, form := range []string{"contact", "support"} {
+		sub := &models.Submission{Form: form, Data: models.FormData{Name: "Sender", Email: form + "@example.com"}}
+		if err := env.store.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hidden, _, err := env.store.ListSubmissions(store.Query{Forms: []string{"support"}})
+	if err != nil || len(hidden) != 1 {
+		t.Fatalf("Expected one support submission: %v", err)
+	}
+
+	viewer := env.provider.sign(t, map[string]interface{}{
+		"sub": "user-1", "email": "alice@example.com", "groups": []string{"support"}, "forms": []string{"contact"},
+	})
+
+	rr := env.do("GET", "/api/v1/forms", viewer)
+	var forms FormList
+	json.Unmarshal(rr.Body.Bytes(), &forms)
+	if rr.Code != http.StatusOK || len(forms.Data) != 1 || forms.Data[0].Slug != "contact" {
+		t.Errorf("Expected only the granted form, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = env.do("GET", "/api/v1/submissions", viewer)
+	var page SubmissionPage
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if rr.Code != http.StatusOK || len(page.Data) != 1 || page.Data[0].Form != "contact" {
+		t.Errorf("Expected only submissions of the granted form, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := env.do("GET", "/api/v1/submissions/"+hidden[0].ID, viewer); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a submission of another form, got %v", rr.Code)
+	}
+	if rr := env.do("DELETE", "/api/v1/submissions/"+page.Data[0].ID, viewer); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a viewer token to lack the delete scope, got %v", rr.Code)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   int
+	}{
+		{"wrong audience", map[string]interface{}{"sub": "user-1", "aud": "other-app", "groups": []string{"support"}}, http.StatusUnauthorized},
+		{"expired", map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(-time.Hour).Unix(), "groups": []string{"support"}}, http.StatusUnauthorized},
+		{"unmapped", map[string]interface{}{"sub": "user-1", "groups": []string{"staff"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := env.do("GET", "/api/v1/forms", env.provider.sign(t, tt.claims)); rr.Code != tt.want {
+				t.Errorf("Expected %v, got %v: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RedirectHandler sends plain HTTP requests to the HTTPS listener
+type RedirectHandler struct {
+	port string
+}
+
+// NewRedirectHandler redirects to port on the host the request was sent to
+func NewRedirectHandler(port string) *RedirectHandler {
+	return &RedirectHandler{port: port}
+}
+
+func (h *RedirectHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+	if h.port != "443" {
+		host = net.JoinHostPort(host, h.port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	// Browsers repeat a redirected POST as a GET, so keep the method
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, target, status)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     string
+		method   string
+		host     string
+		target   string
+		status   int
+		location string
+	}{
+		{"default port", "443", "GET", "example.com", "/f/contact?x=1", http.StatusMovedPermanently, "https://example.com/f/contact?x=1"},
+		{"host with port", "443", "GET", "example.com:80", "/", http.StatusMovedPermanently, "https://example.com/"},
+		{"other port", "8443", "GET", "example.com:8080", "/admin", http.StatusMovedPermanently, "https://example.com:8443/admin"},
+		{"IPv6", "8443", "GET", "[::1]:8080", "/", http.StatusMovedPermanently, "https://[::1]:8443/"},
+		{"IPv6 default port", "443", "GET", "[::1]:80", "/", http.StatusMovedPermanently, "https://[::1]/"},
+		{"post", "443", "POST", "example.com", "/submit", http.StatusPermanentRedirect, "https://example.com/submit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+			NewRedirectHandler(tt.port).Handle(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tt.location {
+				t.Errorf("Expected redirect to %s, got %s", tt.location, got)
+			}
+		})
+	}
+}
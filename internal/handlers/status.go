@@ -15,15 +15,25 @@ type StatusHandler struct {
 }
 
 func NewStatusHandler(cfg *config.Config) *StatusHandler {
-	statusTemplate, err := template.ParseFiles(cfg.StatusTemplate)
+	statusHandler, err := LoadStatusHandler(cfg)
 	if err != nil {
 		log.Fatal("Error loading status template:", err)
 	}
+	return statusHandler
+}
+
+// LoadStatusHandler is NewStatusHandler for callers that handle a broken
+// template themselves, such as a reload
+func LoadStatusHandler(cfg *config.Config) (*StatusHandler, error) {
+	statusTemplate, err := template.ParseFiles(cfg.StatusTemplate)
+	if err != nil {
+		return nil, err
+	}
 
 	return &StatusHandler{
 		config:         cfg,
 		statusTemplate: statusTemplate,
-	}
+	}, nil
 }
 
 type StatusPageData struct {
@@ -41,13 +51,6 @@ func (h *StatusHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		status = "success" // default
 	}
 
-	var message string
-	if status == "success" {
-		message = "Your message has been sent successfully!"
-	} else {
-		message = "There was an error sending your message. Please try again."
-	}
-
 	// Get redirect URL from referer or query parameter
 	redirectURL := r.URL.Query().Get("redirect")
 	if redirectURL == "" {
@@ -66,7 +69,7 @@ func (h *StatusHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	data := StatusPageData{
 		Status:      status,
 		FormTitle:   h.config.FormTitle,
-		Message:     message,
+		Message:     StatusMessage(status),
 		RedirectURL: redirectURL,
 	}
 
@@ -75,3 +78,11 @@ func (h *StatusHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// StatusMessage returns the text shown for a status of success or error
+func StatusMessage(status string) string {
+	if status == "success" {
+		return "Your message has been sent successfully!"
+	}
+	return "There was an error sending your message. Please try again."
+}
@@ -2,128 +2,423 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strings"
 
 	"formfling/internal/config"
+	"formfling/internal/metrics"
 	"formfling/internal/models"
 	"formfling/internal/services"
+	"formfling/internal/store"
+	"formfling/internal/tracing"
 	"formfling/internal/utils"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// CodeNotAllowed is reported when a _cc or _next value is not on the form's allowlist
+const CodeNotAllowed = "NOT_ALLOWED"
+
 type SubmitHandler struct {
 	config           *config.Config
 	emailService     services.EmailSender
 	recaptchaService *services.RecaptchaService
+	powService       *services.ProofOfWorkService
+	csrfService      *services.CSRFService
+	store            *store.Store
 }
 
-func NewSubmitHandler(cfg *config.Config, emailService services.EmailSender, recaptchaService *services.RecaptchaService) *SubmitHandler {
+func NewSubmitHandler(cfg *config.Config, emailService services.EmailSender, recaptchaService *services.RecaptchaService, powService *services.ProofOfWorkService, csrfService *services.CSRFService, submissionStore *store.Store) *SubmitHandler {
 	return &SubmitHandler{
 		config:           cfg,
 		emailService:     emailService,
 		recaptchaService: recaptchaService,
+		powService:       powService,
+		csrfService:      csrfService,
+		store:            submissionStore,
 	}
 }
 
+// Handle accepts submissions for the default form at /submit
 func (h *SubmitHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.process(w, r, h.config.DefaultForm(), false)
+}
+
+// HandleFormspree accepts submissions for a named form at /f/{slug} and
+// answers AJAX requests with Formspree-shaped JSON
+func (h *SubmitHandler) HandleFormspree(w http.ResponseWriter, r *http.Request) {
+	form, ok := h.config.Form(mux.Vars(r)["slug"])
+	if !ok {
+		h.handleFormspreeError(w, r, "form not found", http.StatusNotFound, nil)
+		return
+	}
+	h.process(w, r, form, true)
+}
+
+func (h *SubmitHandler) process(w http.ResponseWriter, r *http.Request, form *config.Form, formspree bool) {
+	fail := func(outcome, errorMsg string, statusCode int, fieldErrors []models.FieldError) {
+		recordOutcome(r, form, outcome)
+		if formspree {
+			h.handleFormspreeError(w, r, errorMsg, statusCode, fieldErrors)
+		} else {
+			h.handleError(w, r, errorMsg, statusCode)
+		}
+	}
+
 	if r.Method != http.MethodPost {
-		h.handleError(w, r, "must be a post", http.StatusMethodNotAllowed)
+		fail(metrics.OutcomeBadRequest, "must be a post", http.StatusMethodNotAllowed, nil)
 		return
 	}
 
+	if limit := h.bodyLimit(form); limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
 	var formData models.FormData
+	var special models.SpecialFields
+	var lookup func(name string) string
 	var err error
 
 	// Parse data based on content type
 	contentType := r.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		// Parse JSON request body
-		formData, err = h.parseJSONRequest(r)
+		formData, special, lookup, err = h.parseJSONRequest(r)
+		if tooLarge(err) {
+			fail(metrics.OutcomeBadRequest, "request too large", http.StatusRequestEntityTooLarge, nil)
+			return
+		}
 		if err != nil {
-			h.handleError(w, r, "failed to parse JSON", http.StatusBadRequest)
+			fail(metrics.OutcomeBadRequest, "failed to parse JSON", http.StatusBadRequest, nil)
 			return
 		}
 	} else {
-		// Parse form data (default)
-		if err := r.ParseForm(); err != nil {
-			h.handleError(w, r, "failed to parse form", http.StatusBadRequest)
+		// Parse form data (default). The embed script posts FormData, which
+		// browsers send as multipart/form-data.
+		parse := r.ParseForm
+		if strings.HasPrefix(contentType, "multipart/form-data") {
+			parse = func() error { return r.ParseMultipartForm(multipartMemory) }
+			defer func() {
+				if r.MultipartForm != nil {
+					r.MultipartForm.RemoveAll()
+				}
+			}()
+		}
+		if err := parse(); tooLarge(err) {
+			fail(metrics.OutcomeBadRequest, "request too large", http.StatusRequestEntityTooLarge, nil)
 			return
+		} else if err != nil {
+			fail(metrics.OutcomeBadRequest, "failed to parse form", http.StatusBadRequest, nil)
+			return
+		}
+		formData = utils.SanitizeForm(models.FormData{
+			Name:              r.FormValue("name"),
+			Email:             r.FormValue("email"),
+			Subject:           r.FormValue("subject"),
+			Message:           r.FormValue("message"),
+			Phone:             r.FormValue("phone"),
+			Website:           r.FormValue("website"),
+			RecaptchaResponse: r.FormValue("g-recaptcha-response"), // Don't sanitize the token
+		})
+		special = models.SpecialFields{
+			ReplyTo:  r.FormValue("_replyto"),
+			Subject:  r.FormValue("_subject"),
+			CC:       r.FormValue("_cc"),
+			Next:     r.FormValue("_next"),
+			Gotcha:   r.FormValue("_gotcha"),
+			Format:   r.FormValue("_format"),
+			Redirect: r.FormValue("_redirect"),
+			Pow:      r.FormValue("_pow"),
+			CSRF:     r.FormValue(services.CSRFFieldName),
+		}
+		lookup = func(name string) string {
+			return strings.Join(r.Form[name], ", ")
+		}
+	}
+	formData.Extra = h.extraFields(form, lookup)
+
+	// Get origin for email
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+
+	// Silently accept submissions that filled in the honeypot field
+	if strings.TrimSpace(special.Gotcha) != "" {
+		slog.WarnContext(r.Context(), "Dropping submission: honeypot field filled", "form", form.Slug)
+		recordOutcome(r, form, metrics.OutcomeSpam)
+		if h.store != nil {
+			sub := h.newSubmission(r, form, formData, origin, models.EmailOptions{})
+			sub.Spam = true
+			sub.Delivery(services.ChannelEmail).Status = models.DeliverySkipped
+			if err := h.store.CreateSubmission(sub); err != nil {
+				slog.ErrorContext(r.Context(), "Error storing submission", "error", err)
+			}
 		}
-		formData = models.FormData{
-			Name:              utils.CleanString(r.FormValue("name")),
-			Email:             utils.CleanString(r.FormValue("email")),
-			Subject:           utils.CleanString(r.FormValue("subject")),
-			Message:           utils.CleanString(r.FormValue("message")),
-			Phone:             utils.CleanString(r.FormValue("phone")),
-			Website:           utils.CleanString(r.FormValue("website")),
-			RecaptchaResponse: r.FormValue("g-recaptcha-response"), // Don't clean the token
+		h.succeed(w, r, formspree, nil)
+		return
+	}
+
+	// Verify the CSRF token of hosted form pages
+	if special.CSRF != "" || form.RequireCSRF {
+		if err := h.csrfService.Verify(r, form.Slug, special.CSRF); err != nil {
+			slog.WarnContext(r.Context(), "CSRF verification failed", "form", form.Slug, "error", err)
+			fail(metrics.OutcomeBadRequest, "CSRF verification failed", http.StatusForbidden, nil)
+			return
 		}
 	}
 
 	// Verify reCAPTCHA if enabled
 	if h.config.RecaptchaEnabled {
-		remoteIP := h.getClientIP(r)
-		if err := h.recaptchaService.VerifyToken(formData.RecaptchaResponse, remoteIP); err != nil {
-			log.Printf("reCAPTCHA verification failed: %v", err)
-			h.handleError(w, r, "reCAPTCHA verification failed", http.StatusBadRequest)
+		remoteIP := clientIP(r)
+		if err := h.recaptchaService.VerifyToken(r.Context(), formData.RecaptchaResponse, remoteIP); err != nil {
+			slog.WarnContext(r.Context(), "reCAPTCHA verification failed", "error", err)
+			fail(metrics.OutcomeCaptchaFailed, "reCAPTCHA verification failed", http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	// Verify proof-of-work if enabled
+	if h.powService.Enabled() {
+		if err := h.powService.Verify(special.Pow); err != nil {
+			slog.WarnContext(r.Context(), "Proof-of-work verification failed", "error", err)
+			fail(metrics.OutcomeCaptchaFailed, "proof-of-work verification failed", http.StatusBadRequest, nil)
 			return
 		}
 	}
 
 	// Validate form
-	if err := utils.ValidateForm(formData); err != nil {
-		h.handleError(w, r, "server rejected", http.StatusBadRequest)
+	_, span := tracing.Start(r.Context(), "submission.validate")
+	fieldErrors := utils.ValidateFormFields(formData)
+	if len(form.Fields) > 0 {
+		fieldErrors = utils.ValidateDefinitions(form.Fields, formData)
+	}
+	var opts models.EmailOptions
+	var next *url.URL
+	if len(fieldErrors) == 0 {
+		opts, next, fieldErrors = h.deliveryOptions(r, form, formData, special)
+	}
+	span.SetAttributes(attribute.Int("formfling.field_errors", len(fieldErrors)))
+	span.End()
+	if len(fieldErrors) > 0 {
+		fail(metrics.OutcomeValidationFailed, "server rejected", http.StatusBadRequest, fieldErrors)
 		return
 	}
 
-	// Get origin for email
-	origin := r.Header.Get("Origin")
-	if origin == "" {
-		origin = r.Header.Get("Referer")
+	// Store the submission first so it survives a failed delivery
+	sub := h.newSubmission(r, form, formData, origin, opts)
+	if h.store != nil {
+		if err := h.store.CreateSubmission(sub); err != nil {
+			slog.ErrorContext(r.Context(), "Error storing submission", "error", err)
+		}
 	}
 
 	// Send email
-	if err := h.emailService.SendEmail(formData, origin); err != nil {
-		log.Printf("Error sending email: %v", err)
-		h.handleError(w, r, "failed to send email", http.StatusInternalServerError)
+	sendErr := services.DeliverEmail(r.Context(), h.emailService, sub)
+	if h.store != nil && sub.ID != "" {
+		err := h.store.ModifySubmission(sub.ID, func(stored *models.Submission) error {
+			copyDelivery(stored, sub)
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error updating submission", "submission", sub.ID, "error", err)
+		}
+	}
+	if sendErr != nil {
+		slog.ErrorContext(r.Context(), "Error sending email", "error", sendErr)
+		fail(metrics.OutcomeDeliveryFailed, "failed to send email", http.StatusInternalServerError, nil)
 		return
 	}
 
-	h.handleSuccess(w, r)
+	recordOutcome(r, form, metrics.OutcomeAccepted)
+	h.succeed(w, r, formspree, next)
+}
+
+// copyDelivery records the email delivery made from a loaded copy of a
+// submission on the stored submission, keeping changes saved since it was
+// loaded
+func copyDelivery(stored, sent *models.Submission) {
+	*stored.Delivery(services.ChannelEmail) = *sent.Delivery(services.ChannelEmail)
+}
+
+// recordOutcome counts a submission and notes its form and outcome on the
+// span of the request
+func recordOutcome(r *http.Request, form *config.Form, outcome string) {
+	metrics.Submission(form.Slug, outcome)
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("formfling.form", form.Slug),
+		attribute.String("formfling.outcome", outcome),
+	)
+}
+
+func (h *SubmitHandler) newSubmission(r *http.Request, form *config.Form, formData models.FormData, origin string, opts models.EmailOptions) *models.Submission {
+	extra := formData.Extra
+	formData.Extra = nil
+	formData.RecaptchaResponse = "" // tokens are single use and not worth keeping
+
+	return &models.Submission{
+		Form:     form.Slug,
+		Data:     formData,
+		Extra:    extra,
+		Origin:   origin,
+		RemoteIP: clientIP(r),
+		Options:  opts,
+	}
+}
+
+// deliveryOptions turns the special fields into email options, enforcing the
+// form's allowlists for _cc and _next
+func (h *SubmitHandler) deliveryOptions(r *http.Request, form *config.Form, formData models.FormData, special models.SpecialFields) (models.EmailOptions, *url.URL, []models.FieldError) {
+	var fieldErrors []models.FieldError
+
+	opts := models.EmailOptions{
+		FormTitle: form.Title,
+		ToEmail:   form.ToEmail,
+		ToName:    form.ToName,
+		Subject:   utils.SanitizeField("_subject", special.Subject),
+		ReplyTo:   formData.Email,
+		PlainText: strings.EqualFold(strings.TrimSpace(special.Format), "plain"),
+		MessageID: services.NewMessageID(h.config.FromEmail),
+	}
+
+	if replyTo := utils.SanitizeField("_replyto", special.ReplyTo); replyTo != "" {
+		if utils.ValidateEmail(replyTo) {
+			opts.ReplyTo = replyTo
+		} else {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "_replyto", Code: utils.CodeTypeEmail, Message: "_replyto should be an email"})
+		}
+	}
+
+	for _, cc := range strings.Split(utils.SanitizeField("_cc", special.CC), ",") {
+		if cc = strings.TrimSpace(cc); cc == "" {
+			continue
+		}
+		if !utils.ValidateEmail(cc) || !form.AllowsCC(cc) {
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "_cc", Code: CodeNotAllowed, Message: fmt.Sprintf("%s is not an allowed _cc address", cc)})
+			continue
+		}
+		opts.CC = append(opts.CC, cc)
+	}
+
+	var next *url.URL
+	if rawNext := strings.TrimSpace(special.Next); rawNext != "" {
+		next = h.resolveNext(r, rawNext)
+		if next == nil || !form.AllowsNext(next) {
+			next = nil
+			fieldErrors = append(fieldErrors, models.FieldError{Field: "_next", Code: CodeNotAllowed, Message: "_next is not an allowed redirect target"})
+		}
+	}
+
+	return opts, next, fieldErrors
+}
+
+// resolveNext resolves a possibly relative _next URL against the page the form
+// was submitted from
+func (h *SubmitHandler) resolveNext(r *http.Request, rawNext string) *url.URL {
+	next, err := url.Parse(rawNext)
+	if err != nil {
+		return nil
+	}
+	if next.IsAbs() {
+		return next
+	}
+	referer, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || !referer.IsAbs() {
+		return nil
+	}
+	return referer.ResolveReference(next)
+}
+
+// multipartMemory is how much of a multipart submission is held in memory;
+// uploaded files beyond it go to temporary files until the request ends
+const multipartMemory = 1 << 20
+
+// bodyLimit is the largest submission the form accepts
+func (h *SubmitHandler) bodyLimit(form *config.Form) int64 {
+	if form.MaxBodyBytes > 0 {
+		return form.MaxBodyBytes
+	}
+	return int64(h.config.MaxBodyBytes)
+}
+
+// tooLarge reports whether reading the body stopped at the size limit
+func tooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
 }
 
-func (h *SubmitHandler) parseJSONRequest(r *http.Request) (models.FormData, error) {
+func (h *SubmitHandler) parseJSONRequest(r *http.Request) (models.FormData, models.SpecialFields, func(string) string, error) {
 	var formData models.FormData
+	var special models.SpecialFields
+	var values map[string]interface{}
 
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return formData, fmt.Errorf("failed to read request body: %v", err)
+		return formData, special, nil, fmt.Errorf("failed to read request body: %w", err)
 	}
 	defer r.Body.Close()
 
 	// Parse JSON
 	if err := json.Unmarshal(body, &formData); err != nil {
-		return formData, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		return formData, special, nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if err := json.Unmarshal(body, &special); err != nil {
+		return formData, special, nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if err := json.Unmarshal(body, &values); err != nil {
+		return formData, special, nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	lookup := func(name string) string {
+		switch value := values[name].(type) {
+		case nil:
+			return ""
+		case string:
+			return value
+		case []interface{}:
+			parts := make([]string, 0, len(value))
+			for _, item := range value {
+				parts = append(parts, fmt.Sprint(item))
+			}
+			return strings.Join(parts, ", ")
+		default:
+			return fmt.Sprint(value)
+		}
 	}
 
-	// Clean the data (but not the reCAPTCHA token)
-	formData.Name = utils.CleanString(formData.Name)
-	formData.Email = utils.CleanString(formData.Email)
-	formData.Subject = utils.CleanString(formData.Subject)
-	formData.Message = utils.CleanString(formData.Message)
-	formData.Phone = utils.CleanString(formData.Phone)
-	formData.Website = utils.CleanString(formData.Website)
+	// Sanitize the data (but not the reCAPTCHA token)
+	return utils.SanitizeForm(formData), special, lookup, nil
+}
 
-	return formData, nil
+// extraFields collects the values of the form's own fields. Undefined fields
+// are ignored so arbitrary input never reaches the notification.
+func (h *SubmitHandler) extraFields(form *config.Form, lookup func(string) string) []models.ExtraField {
+	var extra []models.ExtraField
+	for _, def := range form.Fields {
+		if models.IsBuiltinField(def.Name) {
+			continue
+		}
+		extra = append(extra, models.ExtraField{
+			Name:  def.Name,
+			Label: def.Label,
+			Value: utils.SanitizeField(def.Name, lookup(def.Name)),
+		})
+	}
+	return extra
 }
 
-func (h *SubmitHandler) getClientIP(r *http.Request) string {
+// clientIP returns the address of the client, honouring proxy headers
+func clientIP(r *http.Request) string {
 	// Check for X-Forwarded-For header (common in reverse proxy setups)
 	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
 		// X-Forwarded-For can contain multiple IPs, take the first one
@@ -145,6 +440,62 @@ func (h *SubmitHandler) getClientIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+// signInIP returns the address sign-in attempts are throttled on: that of
+// the connection or, when it comes from a trusted proxy, the address the
+// proxies forwarded the request for. X-Forwarded-For is read from the right,
+// as each proxy appends the address it saw, and stops at the first address
+// that is not a trusted proxy, since anything to its left came from the
+// client. Connections over unix sockets count as proxies, as the socket
+// permissions decide who connects; without a forwarded address they return
+// "".
+func signInIP(r *http.Request, proxies []netip.Prefix) string {
+	var ip netip.Addr
+	trusted := true
+	if peer, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ip = peer.Addr().Unmap()
+		trusted = containsAddr(proxies, ip)
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for ; trusted && len(hops) > 0; hops = hops[:len(hops)-1] {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		trusted = containsAddr(proxies, ip)
+	}
+
+	if !ip.IsValid() {
+		return ""
+	}
+	return ip.String()
+}
+
+func containsAddr(networks []netip.Prefix, ip netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *SubmitHandler) succeed(w http.ResponseWriter, r *http.Request, formspree bool, next *url.URL) {
+	if formspree {
+		h.handleFormspreeSuccess(w, r, next)
+		return
+	}
+	if next != nil && !h.isAjaxRequest(r) {
+		http.Redirect(w, r, next.String(), http.StatusSeeOther)
+		return
+	}
+	h.handleSuccess(w, r)
+}
+
 func (h *SubmitHandler) handleSuccess(w http.ResponseWriter, r *http.Request) {
 	// Check if this is an AJAX request (API mode)
 	if h.isAjaxRequest(r) {
@@ -159,6 +510,38 @@ func (h *SubmitHandler) handleSuccess(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 }
 
+func (h *SubmitHandler) handleFormspreeSuccess(w http.ResponseWriter, r *http.Request, next *url.URL) {
+	redirectURL := h.getRedirectURL(r, "success")
+	if next != nil {
+		redirectURL = next.String()
+	}
+
+	if h.isAjaxRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		response := models.FormspreeResponse{OK: true, Next: redirectURL}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+func (h *SubmitHandler) handleFormspreeError(w http.ResponseWriter, r *http.Request, errorMsg string, statusCode int, fieldErrors []models.FieldError) {
+	if !h.isAjaxRequest(r) {
+		h.handleError(w, r, errorMsg, statusCode)
+		return
+	}
+
+	if len(fieldErrors) > 0 {
+		errorMsg = "Validation errors"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	response := models.FormspreeResponse{Error: errorMsg, Errors: fieldErrors}
+	json.NewEncoder(w).Encode(response)
+}
+
 func (h *SubmitHandler) handleError(w http.ResponseWriter, r *http.Request, errorMsg string, statusCode int) {
 	// Check if this is an AJAX request (API mode)
 	if h.isAjaxRequest(r) {
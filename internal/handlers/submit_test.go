@@ -2,25 +2,43 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"strings"
 	"testing"
 
 	"formfling/internal/config"
+	"formfling/internal/metrics"
 	"formfling/internal/models"
 	"formfling/internal/services"
+
+	"github.com/gorilla/mux"
 )
 
 // Mock email service for testing
 type mockEmailService struct {
-	shouldFail bool
+	shouldFail  bool
+	lastForm    models.FormData
+	lastOptions models.EmailOptions
+	lastReply   models.Reply
 }
 
-func (m *mockEmailService) SendEmail(formData models.FormData, origin string) error {
+func (m *mockEmailService) SendEmail(ctx context.Context, formData models.FormData, origin string, opts models.EmailOptions) error {
+	m.lastForm = formData
+	m.lastOptions = opts
+	if m.shouldFail {
+		return errors.New("mock email service error")
+	}
+	return nil
+}
+
+func (m *mockEmailService) SendReply(ctx context.Context, reply models.Reply) error {
+	m.lastReply = reply
 	if m.shouldFail {
 		return errors.New("mock email service error")
 	}
@@ -42,7 +60,7 @@ func TestSubmitHandler_RedirectMode(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Test form submission without AJAX headers (should redirect)
 	formData := url.Values{
@@ -89,7 +107,7 @@ func TestSubmitHandler_AjaxMode(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	formData := url.Values{
 		"name":    {"John Doe"},
@@ -137,7 +155,7 @@ func TestSubmitHandler_JSONRequest_Success(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Create JSON request body
 	formData := models.FormData{
@@ -194,7 +212,7 @@ func TestSubmitHandler_JSONRequest_ValidationError(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Create JSON request body with invalid data
 	formData := models.FormData{
@@ -239,7 +257,7 @@ func TestSubmitHandler_JSONRequest_ParseError(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Create invalid JSON
 	invalidJSON := `{"name": "John", "email": }`
@@ -285,7 +303,7 @@ func TestSubmitHandler_CustomRedirect(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Test with custom redirect URL
 	formData := url.Values{
@@ -326,7 +344,7 @@ func TestSubmitHandler_ValidationError(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Test with invalid data (missing required fields)
 	formData := url.Values{
@@ -372,7 +390,7 @@ func TestSubmitHandler_AjaxValidationError(t *testing.T) {
 	}
 
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Test AJAX request with invalid data
 	formData := url.Values{
@@ -412,7 +430,7 @@ func TestSubmitHandler_MethodNotAllowed(t *testing.T) {
 		FormTitle: "Test Form",
 	}
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Test GET request (should fail)
 	req, err := http.NewRequest("GET", "/submit", nil)
@@ -448,7 +466,7 @@ func TestSubmitHandler_EmailServiceError(t *testing.T) {
 
 	// Mock email service that fails
 	emailService := &mockEmailService{shouldFail: true}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	formData := url.Values{
 		"name":    {"John Doe"},
@@ -489,7 +507,7 @@ func TestSubmitHandler_EmailServiceError(t *testing.T) {
 func TestIsAjaxRequest(t *testing.T) {
 	cfg := &config.Config{}
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	tests := []struct {
 		name     string
@@ -556,7 +574,7 @@ func TestIsAjaxRequest(t *testing.T) {
 func TestGetRedirectURL(t *testing.T) {
 	cfg := &config.Config{}
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	tests := []struct {
 		name         string
@@ -610,7 +628,7 @@ func TestGetRedirectURL(t *testing.T) {
 func TestAddStatusParam(t *testing.T) {
 	cfg := &config.Config{}
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	tests := []struct {
 		name     string
@@ -653,7 +671,7 @@ func TestSubmitHandler_ParseFormError(t *testing.T) {
 		FormTitle: "Test Form",
 	}
 	emailService := &mockEmailService{}
-	handler := NewSubmitHandler(cfg, emailService, nil)
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
 
 	// Create a request with malformed form data
 	req, err := http.NewRequest("POST", "/submit", strings.NewReader("%"))
@@ -676,3 +694,388 @@ func TestSubmitHandler_ParseFormError(t *testing.T) {
 		t.Errorf("Expected redirect URL to contain /status?type=error, got %s", location)
 	}
 }
+
+func TestSubmitHandler_PreservesCase(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle: "Test Form",
+	}
+
+	emailService := &mockEmailService{}
+	handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
+
+	message := "Photo: Toronto skyline at night. " + strings.Repeat("This is a valid message with enough characters. ", 7)
+	formData := url.Values{
+		"name":    {"John  McDonald"},
+		"email":   {"John@Example.com"},
+		"subject": {"Photo: Toronto\r\nBcc: evil@example.com"},
+		"message": {message},
+	}
+
+	req, err := http.NewRequest("POST", "/submit", strings.NewReader(formData.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+
+	rr := httptest.NewRecorder()
+	handler.Handle(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", status)
+	}
+
+	got := emailService.lastForm
+	if got.Name != "John McDonald" {
+		t.Errorf("Expected name 'John McDonald', got %q", got.Name)
+	}
+	if got.Email != "John@Example.com" {
+		t.Errorf("Expected email to keep its case, got %q", got.Email)
+	}
+	if got.Subject != "Photo: Toronto Bcc: evil@example.com" {
+		t.Errorf("Expected subject without line breaks, got %q", got.Subject)
+	}
+	if got.Message != strings.TrimSpace(message) {
+		t.Errorf("Expected message to be kept verbatim, got %q", got.Message)
+	}
+}
+
+func newFormspreeRequest(t *testing.T, slug string, values url.Values) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/f/"+slug, strings.NewReader(values.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	return mux.SetURLVars(req, map[string]string{"slug": slug})
+}
+
+func TestSubmitHandler_Formspree(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle: "Test Form",
+		ToEmail:   "recipient@example.com",
+		Forms: map[string]*config.Form{
+			"contact": {
+				Slug:        "contact",
+				Title:       "Contact",
+				ToEmail:     "team@example.com",
+				AllowedCC:   []string{"@example.com"},
+				AllowedNext: []string{"https://example.com/thanks"},
+			},
+		},
+	}
+	message := strings.Repeat("This is a valid message with enough characters. ", 7)
+
+	t.Run("Success with special fields", func(t *testing.T) {
+		emailService := &mockEmailService{}
+		handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
+
+		req := newFormspreeRequest(t, "contact", url.Values{
+			"name":     {"John Doe"},
+			"email":    {"john@example.com"},
+			"message":  {message},
+			"_replyto": {"reply@example.net"},
+			"_subject": {"Website enquiry"},
+			"_cc":      {"sales@example.com, boss@example.com"},
+			"_next":    {"https://example.com/thanks"},
+			"_format":  {"plain"},
+		})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+		}
+
+		var response models.FormspreeResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Could not unmarshal response: %v", err)
+		}
+		if !response.OK || response.Next != "https://example.com/thanks" {
+			t.Errorf("Expected ok response with next URL, got %+v", response)
+		}
+
+		opts := emailService.lastOptions
+		if opts.ToEmail != "team@example.com" || opts.FormTitle != "Contact" {
+			t.Errorf("Expected form recipient and title, got %+v", opts)
+		}
+		if opts.ReplyTo != "reply@example.net" {
+			t.Errorf("Expected Reply-To from _replyto, got %q", opts.ReplyTo)
+		}
+		if opts.Subject != "Website enquiry" {
+			t.Errorf("Expected subject from _subject, got %q", opts.Subject)
+		}
+		if len(opts.CC) != 2 || opts.CC[0] != "sales@example.com" {
+			t.Errorf("Expected 2 CC addresses, got %v", opts.CC)
+		}
+		if !opts.PlainText {
+			t.Error("Expected plain text delivery for _format=plain")
+		}
+	})
+
+	t.Run("Redirects to _next without AJAX", func(t *testing.T) {
+		handler := NewSubmitHandler(cfg, &mockEmailService{}, nil, nil, nil, nil)
+
+		req := newFormspreeRequest(t, "contact", url.Values{
+			"name":    {"John Doe"},
+			"email":   {"john@example.com"},
+			"message": {message},
+			"_next":   {"/thanks?from=contact"},
+		})
+		req.Header.Del("Accept")
+		req.Header.Set("Referer", "https://example.com/contact")
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("Expected status 303, got %v", rr.Code)
+		}
+		if location := rr.Header().Get("Location"); location != "https://example.com/thanks?from=contact" {
+			t.Errorf("Expected redirect to resolved _next, got %s", location)
+		}
+	})
+
+	t.Run("Rejects targets outside the allowlists", func(t *testing.T) {
+		emailService := &mockEmailService{}
+		handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
+
+		req := newFormspreeRequest(t, "contact", url.Values{
+			"name":    {"John Doe"},
+			"email":   {"john@example.com"},
+			"message": {message},
+			"_cc":     {"someone@evil.com"},
+			"_next":   {"https://evil.com/phish"},
+		})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %v", rr.Code)
+		}
+
+		var response models.FormspreeResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Could not unmarshal response: %v", err)
+		}
+		if len(response.Errors) != 2 || response.Errors[0].Field != "_cc" || response.Errors[1].Field != "_next" {
+			t.Errorf("Expected _cc and _next field errors, got %+v", response.Errors)
+		}
+		if emailService.lastForm.Email != "" {
+			t.Error("Expected no email to be sent")
+		}
+	})
+
+	t.Run("Field errors for invalid input", func(t *testing.T) {
+		handler := NewSubmitHandler(cfg, &mockEmailService{}, nil, nil, nil, nil)
+
+		req := newFormspreeRequest(t, "contact", url.Values{
+			"email":   {"not-an-email"},
+			"message": {"short"},
+		})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		var response models.FormspreeResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Could not unmarshal response: %v", err)
+		}
+		if response.Error != "Validation errors" || len(response.Errors) != 3 {
+			t.Errorf("Expected 3 validation errors, got %+v", response)
+		}
+		if response.Errors[1].Code != "TYPE_EMAIL" {
+			t.Errorf("Expected TYPE_EMAIL code for email, got %s", response.Errors[1].Code)
+		}
+	})
+
+	t.Run("Honeypot submissions are dropped silently", func(t *testing.T) {
+		emailService := &mockEmailService{}
+		handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
+
+		req := newFormspreeRequest(t, "contact", url.Values{
+			"name":    {"Bot"},
+			"_gotcha": {"spam"},
+		})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %v", rr.Code)
+		}
+		if emailService.lastForm.Name != "" {
+			t.Error("Expected honeypot submission not to be emailed")
+		}
+	})
+
+	t.Run("Unknown form", func(t *testing.T) {
+		handler := NewSubmitHandler(cfg, &mockEmailService{}, nil, nil, nil, nil)
+
+		req := newFormspreeRequest(t, "missing", url.Values{"name": {"John"}})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %v", rr.Code)
+		}
+	})
+
+	t.Run("JSON body with special fields", func(t *testing.T) {
+		emailService := &mockEmailService{}
+		handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
+
+		body := `{"name":"John Doe","email":"john@example.com","message":"` + message + `","_subject":"From JSON"}`
+		req, err := http.NewRequest("POST", "/f/contact", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req = mux.SetURLVars(req, map[string]string{"slug": "contact"})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+		}
+		if emailService.lastOptions.Subject != "From JSON" {
+			t.Errorf("Expected subject from JSON _subject, got %q", emailService.lastOptions.Subject)
+		}
+		if emailService.lastOptions.ReplyTo != "john@example.com" {
+			t.Errorf("Expected Reply-To to default to the email field, got %q", emailService.lastOptions.ReplyTo)
+		}
+	})
+}
+
+func TestSubmitHandler_BodyLimit(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle:    "Test Form",
+		ToEmail:      "recipient@example.com",
+		MaxBodyBytes: 1024,
+		Forms: map[string]*config.Form{
+			"large": {Slug: "large", Title: "Large", MaxBodyBytes: 4096},
+		},
+	}
+	message := strings.Repeat("x", 2000)
+
+	t.Run("Form data over the global limit", func(t *testing.T) {
+		handler := NewSubmitHandler(cfg, &mockEmailService{}, nil, nil, nil, nil)
+		req, err := http.NewRequest("POST", "/submit", strings.NewReader(url.Values{"message": {message}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+
+		rr := httptest.NewRecorder()
+		handler.Handle(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status 413, got %v", rr.Code)
+		}
+	})
+
+	t.Run("JSON over the global limit", func(t *testing.T) {
+		handler := NewSubmitHandler(cfg, &mockEmailService{}, nil, nil, nil, nil)
+		body, _ := json.Marshal(map[string]string{"message": message})
+		req, err := http.NewRequest("POST", "/submit", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		handler.Handle(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status 413, got %v", rr.Code)
+		}
+	})
+
+	t.Run("Form with a larger limit", func(t *testing.T) {
+		emailService := &mockEmailService{}
+		handler := NewSubmitHandler(cfg, emailService, nil, nil, nil, nil)
+		req := newFormspreeRequest(t, "large", url.Values{
+			"name":    {"John Doe"},
+			"email":   {"john@example.com"},
+			"message": {message},
+		})
+
+		rr := httptest.NewRecorder()
+		handler.HandleFormspree(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %v: %s", rr.Code, rr.Body.String())
+		}
+		if emailService.lastForm.Message != message {
+			t.Error("Expected the message to be delivered")
+		}
+	})
+}
+
+func TestSubmitHandler_Metrics(t *testing.T) {
+	cfg := &config.Config{
+		FormTitle: "Test Form",
+		ToEmail:   "recipient@example.com",
+		Forms: map[string]*config.Form{
+			"metrics": {Slug: "metrics", Title: "Metrics"},
+		},
+	}
+	handler := NewSubmitHandler(cfg, &mockEmailService{}, nil, nil, nil, nil)
+	for _, values := range []url.Values{
+		{"name": {"John"}, "_gotcha": {"bot"}},
+		{"name": {"John"}},
+	} {
+		handler.HandleFormspree(httptest.NewRecorder(), newFormspreeRequest(t, "metrics", values))
+	}
+
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`formfling_submissions_total{form="metrics",outcome="spam"} 1`,
+		`formfling_submissions_total{form="metrics",outcome="validation-failed"} 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("Expected the metrics to contain %s", want)
+		}
+	}
+}
+
+func TestSignInIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "198.51.100.7:4321", nil, "198.51.100.7"},
+		{"forwarded by an untrusted client", "198.51.100.7:4321", []string{"203.0.113.1"}, "198.51.100.7"},
+		{"trusted proxy", "192.0.2.1:4321", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"chain of trusted proxies", "192.0.2.1:4321", []string{"203.0.113.1, 10.1.2.3"}, "203.0.113.1"},
+		{"forged entries left of the client", "192.0.2.1:4321", []string{"198.51.100.9, 203.0.113.1"}, "203.0.113.1"},
+		{"one header per proxy", "192.0.2.1:4321", []string{"203.0.113.1", "10.1.2.3"}, "203.0.113.1"},
+		{"malformed entry", "192.0.2.1:4321", []string{"unknown, 10.1.2.3"}, "10.1.2.3"},
+		{"trusted proxy without header", "192.0.2.1:4321", nil, "192.0.2.1"},
+		{"IPv4-mapped peer", "[::ffff:192.0.2.1]:4321", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"unix socket", "@", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"unix socket without header", "@", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/admin/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := signInIP(req, proxies); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
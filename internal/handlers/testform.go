@@ -14,15 +14,25 @@ type TestFormHandler struct {
 }
 
 func NewTestFormHandler(cfg *config.Config) *TestFormHandler {
+	testFormHandler, err := LoadTestFormHandler(cfg)
+	if err != nil {
+		log.Fatal("Error loading test form template:", err)
+	}
+	return testFormHandler
+}
+
+// LoadTestFormHandler is NewTestFormHandler for callers that handle a broken
+// template themselves
+func LoadTestFormHandler(cfg *config.Config) (*TestFormHandler, error) {
 	testFormTemplate, err := template.ParseFiles(cfg.TestFormTemplate)
 	if err != nil {
-		log.Fatal("Error loading status template:", err)
+		return nil, err
 	}
 
 	return &TestFormHandler{
 		config:           cfg,
 		testFormTemplate: testFormTemplate,
-	}
+	}, nil
 }
 
 type TestFormData struct {
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"formfling/internal/store"
+)
+
+// checkAssignee verifies a submission of the form may be assigned to the
+// user. An empty username unassigns it.
+func checkAssignee(s *store.Store, form, username string) error {
+	if username == "" {
+		return nil
+	}
+	user, err := s.GetUser(username)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("no account named %q", username)
+	}
+	if err != nil {
+		return err
+	}
+	if !user.CanAccess(form) {
+		return fmt.Errorf("%s cannot see the %s form", user.Username, form)
+	}
+	return nil
+}
+
+// assignees lists the accounts that can handle submissions of the form, or
+// every account when form is empty
+func assignees(s *store.Store, form string) []string {
+	users, err := s.ListUsers()
+	if err != nil {
+		slog.Error("Error listing users", "error", err)
+		return nil
+	}
+	var names []string
+	for _, user := range users {
+		if form == "" || user.CanAccess(form) {
+			names = append(names, user.Username)
+		}
+	}
+	return names
+}
+
+// splitTags reads comma-separated tags
+func splitTags(value string) []string {
+	return strings.Split(value, ",")
+}
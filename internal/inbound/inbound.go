@@ -0,0 +1,113 @@
+// Package inbound receives the email submitters send in reply to FormFling
+// and threads it into the submission timelines.
+package inbound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+// ErrNoMatch is returned for mail that belongs to no submission
+var ErrNoMatch = errors.New("no matching submission")
+
+// errInvalidMessage is returned for mail that cannot be parsed
+var errInvalidMessage = errors.New("invalid message")
+
+// Ingester appends received replies to the submissions they answer
+type Ingester struct {
+	config *config.Config
+	store  *store.Store
+}
+
+func NewIngester(cfg *config.Config, submissionStore *store.Store) *Ingester {
+	return &Ingester{
+		config: cfg,
+		store:  submissionStore,
+	}
+}
+
+// Accept reports whether mail for the recipient is taken at all. Only the
+// configured reply domain is accepted, so the listener is never a relay.
+func (i *Ingester) Accept(rcpt string) bool {
+	at := strings.LastIndex(rcpt, "@")
+	return at >= 0 && strings.EqualFold(rcpt[at+1:], i.config.InboundDomain)
+}
+
+// Deliver matches a message to a submission, by its tokenized recipient
+// address or else by the Message-IDs it references, and adds it to the
+// timeline. Automatic replies and messages already on the timeline are
+// dropped.
+func (i *Ingester) Deliver(from, rcpt string, data []byte) error {
+	msg, err := ParseMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidMessage, err)
+	}
+	if msg.AutoSubmitted {
+		slog.Warn("Dropping automatic reply", "from", from, "to", rcpt)
+		return nil
+	}
+
+	sub, err := i.match(rcpt, msg)
+	if err != nil {
+		return err
+	}
+
+	sender := msg.From
+	if sender == "" {
+		sender = from
+	}
+	added := false
+	err = i.store.ModifySubmission(sub.ID, func(stored *models.Submission) error {
+		if msg.MessageID != "" && stored.HasMessage(msg.MessageID) {
+			return nil
+		}
+		stored.AddEmail(sender, msg.Subject, StripQuoted(msg.Text), msg.MessageID)
+		added = true
+		return nil
+	})
+	if err != nil || !added {
+		return err
+	}
+	slog.Info("Added email to submission", "from", sender, "submission", sub.ID)
+	return nil
+}
+
+func (i *Ingester) match(rcpt string, msg *Message) (*models.Submission, error) {
+	if id, ok := services.ParseReplyAddress(i.config, rcpt); ok {
+		sub, err := i.store.GetSubmission(id)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrNoMatch
+		}
+		return sub, err
+	}
+
+	// Newest first: the message answers the last one it references
+	ids := append([]string{msg.InReplyTo}, reversed(msg.References)...)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		sub, err := i.store.FindSubmissionByMessageID(id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		return sub, err
+	}
+	return nil, ErrNoMatch
+}
+
+func reversed(list []string) []string {
+	out := make([]string, 0, len(list))
+	for j := len(list) - 1; j >= 0; j-- {
+		out = append(out, list[j])
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package inbound
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+func newTestIngester(t *testing.T) (*Ingester, *store.Store, *config.Config) {
+	t.Helper()
+	cfg := &config.Config{
+		InboundAddr:   ":2525",
+		InboundDomain: "reply.example.com",
+		InboundSecret: "inbound-secret",
+	}
+	s, err := store.Open(filepath.Join(t.TempDir(), "formfling.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return NewIngester(cfg, s), s, cfg
+}
+
+func TestIngester_Deliver(t *testing.T) {
+	ingester, s, cfg := newTestIngester(t)
+
+	sub := &models.Submission{Form: "support", Options: models.EmailOptions{MessageID: "<notification@example.com>"}}
+	if err := s.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+	address := services.ReplyAddress(cfg, sub.ID)
+	if address == "" || !ingester.Accept(address) || ingester.Accept("someone@example.com") {
+		t.Fatalf("Expected only the reply domain to be accepted, got address %q", address)
+	}
+	if reply := services.NewReply(cfg, sub, "Re: Printer", "Hello"); reply.ReplyTo != address {
+		t.Errorf("Expected replies to ask for answers at %s, got %q", address, reply.ReplyTo)
+	}
+
+	byAddress := "From: Sam <sam@example.com>\r\nMessage-ID: <1@mail.example.com>\r\nSubject: Re: Printer\r\n\r\n" +
+		"Thanks!\r\n\r\nOn Monday, Support wrote:\r\n> Hello\r\n"
+	if err := ingester.Deliver("sam@example.com", address, []byte(byAddress)); err != nil {
+		t.Fatalf("Deliver returned error: %v", err)
+	}
+	// Delivered again after a retry
+	if err := ingester.Deliver("sam@example.com", address, []byte(byAddress)); err != nil {
+		t.Fatalf("Deliver returned error: %v", err)
+	}
+
+	byReference := "From: sam@example.com\r\nMessage-ID: <2@mail.example.com>\r\nReferences: <notification@example.com>\r\n\r\nOne more thing.\r\n"
+	if err := ingester.Deliver("sam@example.com", "support@reply.example.com", []byte(byReference)); err != nil {
+		t.Fatalf("Deliver returned error: %v", err)
+	}
+
+	auto := "From: sam@example.com\r\nAuto-Submitted: auto-replied\r\n\r\nOut of office\r\n"
+	if err := ingester.Deliver("sam@example.com", address, []byte(auto)); err != nil {
+		t.Fatalf("Deliver returned error: %v", err)
+	}
+
+	got, _ := s.GetSubmission(sub.ID)
+	if len(got.Events) != 2 {
+		t.Fatalf("Expected 2 emails on the timeline, got %+v", got.Events)
+	}
+	first := got.Events[0]
+	if first.Kind != models.EventEmail || first.From != "sam@example.com" || first.Subject != "Re: Printer" || first.Body != "Thanks!" {
+		t.Errorf("Unexpected event %+v", first)
+	}
+	if got.Events[1].Body != "One more thing." {
+		t.Errorf("Expected the message matched by References, got %+v", got.Events[1])
+	}
+
+	// The new Message-IDs thread later replies too
+	if found, err := s.FindSubmissionByMessageID("<2@mail.example.com>"); err != nil || found.ID != sub.ID {
+		t.Errorf("Expected received Message-IDs to be indexed, got %v", err)
+	}
+
+	forged := "reply+" + sub.ID + ".0000000000000000@reply.example.com"
+	if err := ingester.Deliver("eve@example.com", forged, []byte("From: eve@example.com\r\n\r\nHi\r\n")); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("Expected ErrNoMatch for a forged address, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Message is the part of a received email that goes on the timeline
+type Message struct {
+	From          string
+	Subject       string
+	MessageID     string
+	InReplyTo     string
+	References    []string
+	Text          string
+	AutoSubmitted bool
+}
+
+// wordDecoder decodes RFC 2047 encoded headers in any charset x/text knows
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// messageIDPattern finds the Message-IDs in In-Reply-To and References
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// ParseMessage reads a MIME message and extracts its plain text. HTML-only
+// messages are converted to text.
+func ParseMessage(r io.Reader) (*Message, error) {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		MessageID:  strings.TrimSpace(m.Header.Get("Message-Id")),
+		InReplyTo:  messageIDPattern.FindString(m.Header.Get("In-Reply-To")),
+		References: messageIDPattern.FindAllString(m.Header.Get("References"), -1),
+	}
+	if subject, err := wordDecoder.DecodeHeader(m.Header.Get("Subject")); err == nil {
+		msg.Subject = strings.TrimSpace(subject)
+	} else {
+		msg.Subject = strings.TrimSpace(m.Header.Get("Subject"))
+	}
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	if from, err := parser.Parse(m.Header.Get("From")); err == nil {
+		msg.From = from.Address
+	}
+
+	// RFC 3834: anything but "no" is an automatic message, like an out of
+	// office notice
+	if auto := strings.TrimSpace(m.Header.Get("Auto-Submitted")); auto != "" && !strings.EqualFold(auto, "no") {
+		msg.AutoSubmitted = true
+	}
+
+	text, isHTML, err := bodyText(m.Header, m.Body)
+	if err != nil {
+		return nil, err
+	}
+	if isHTML {
+		text = htmlToText(text)
+	}
+	msg.Text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	return msg, nil
+}
+
+// header is what bodyText needs from mail and multipart headers
+type header interface {
+	Get(key string) string
+}
+
+// bodyText returns the decoded text of a body, preferring text/plain over
+// text/html in multipart messages
+func bodyText(h header, body io.Reader) (string, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var htmlText string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", false, fmt.Errorf("invalid multipart body: %v", err)
+			}
+			if strings.HasPrefix(part.Header.Get("Content-Disposition"), "attachment") {
+				continue
+			}
+			text, isHTML, err := bodyText(part.Header, part)
+			if err != nil {
+				return "", false, err
+			}
+			if !isHTML && text != "" {
+				return text, false, nil
+			}
+			if isHTML && htmlText == "" {
+				htmlText = text
+			}
+		}
+		return htmlText, htmlText != "", nil
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", false, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &lineJoiner{r: body})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	if charset := params["charset"]; charset != "" {
+		decoded, err := charsetReader(charset, body)
+		if err != nil {
+			return "", false, err
+		}
+		body = decoded
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", false, fmt.Errorf("error decoding body: %v", err)
+	}
+	return string(data), mediaType == "text/html", nil
+}
+
+// charsetReader decodes text in the named charset to UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return input, nil
+	}
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// lineJoiner drops the line breaks base64 bodies are wrapped with
+type lineJoiner struct {
+	r io.Reader
+}
+
+func (l *lineJoiner) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	n = copy(p, bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, p[:n]))
+	return n, err
+}
+
+var (
+	htmlDropPattern  = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>|<blockquote\b.*?</blockquote>`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+	blankLines       = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// htmlToText reduces an HTML body to its text. Quoted blocks are dropped, like
+// quoted lines of plain text messages.
+func htmlToText(body string) string {
+	body = htmlDropPattern.ReplaceAllString(body, "")
+	body = htmlBreakPattern.ReplaceAllString(body, "\n")
+	body = html.UnescapeString(htmlTagPattern.ReplaceAllString(body, ""))
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
+
+var (
+	// attributionPattern matches the line mail clients put above a quote
+	attributionPattern = regexp.MustCompile(`^(On|Am|Le|El|Op) .*(wrote|schrieb|écrit|escribió|schreef)\s*:$`)
+	// forwardedPattern matches the separators of Outlook style quotes
+	forwardedPattern = regexp.MustCompile(`^(-{2,}\s*Original Message\s*-{2,}|_{10,})$`)
+)
+
+// StripQuoted returns the new text of a reply: everything above the quoted
+// message and the signature. Text without anything new is returned as is.
+func StripQuoted(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var kept []string
+scan:
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case line == "-- " || line == "--":
+			break scan
+		case forwardedPattern.MatchString(trimmed):
+			break scan
+		case attributionPattern.MatchString(trimmed):
+			break scan
+		case i+1 < len(lines) && attributionPattern.MatchString(trimmed+" "+strings.TrimSpace(lines[i+1])):
+			// Long attributions are often wrapped
+			break scan
+		case strings.HasPrefix(trimmed, "From:") && i+1 < len(lines) && isQuoteHeader(lines[i+1]):
+			break scan
+		case strings.HasPrefix(trimmed, ">"):
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	stripped := strings.TrimSpace(strings.Join(kept, "\n"))
+	if stripped == "" {
+		return strings.TrimSpace(text)
+	}
+	return stripped
+}
+
+// isQuoteHeader reports whether a line continues the header block Outlook
+// writes above a quoted message
+func isQuoteHeader(line string) bool {
+	for _, prefix := range []string{"Sent:", "Date:", "To:", "Subject:"} {
+		if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		subject string
+		text    string
+	}{
+		{
+			name: "plain text",
+			message: "From: Sam <sam@example.com>\r\n" +
+				"Subject: Re: Printer on fire\r\n" +
+				"\r\n" +
+				"Still burning.\r\n",
+			subject: "Re: Printer on fire",
+			text:    "Still burning.",
+		},
+		{
+			name: "multipart alternative prefers text",
+			message: "From: sam@example.com\r\n" +
+				"Subject: =?utf-8?q?Re=3A_Caf=C3=A9?=\r\n" +
+				"MIME-Version: 1.0\r\n" +
+				"Content-Type: multipart/alternative; boundary=b1\r\n" +
+				"\r\n" +
+				"--b1\r\n" +
+				"Content-Type: text/plain; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"Caf=C3=A9 au lait, s'il vous pla=\r\n" +
+				"=C3=AEt.\r\n" +
+				"--b1\r\n" +
+				"Content-Type: text/html; charset=utf-8\r\n" +
+				"\r\n" +
+				"<p>HTML version</p>\r\n" +
+				"--b1--\r\n",
+			subject: "Re: Café",
+			text:    "Café au lait, s'il vous plaît.",
+		},
+		{
+			name: "base64 in another charset",
+			message: "From: sam@example.com\r\n" +
+				"Subject: Re: Printer\r\n" +
+				"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"R3L832UgYXVz\r\n" +
+				"IE38bmNoZW4=\r\n",
+			subject: "Re: Printer",
+			text:    "Grüße aus München",
+		},
+		{
+			name: "HTML only",
+			message: "From: sam@example.com\r\n" +
+				"Subject: Re: Printer\r\n" +
+				"Content-Type: text/html; charset=utf-8\r\n" +
+				"\r\n" +
+				"<html><head><style>p{}</style></head><body><p>Fixed &amp; done</p><div>Thanks<br>Sam</div>" +
+				"<blockquote>Old message</blockquote></body></html>\r\n",
+			subject: "Re: Printer",
+			text:    "Fixed & done\nThanks\nSam",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ParseMessage(strings.NewReader(tt.message))
+			if err != nil {
+				t.Fatalf("ParseMessage returned error: %v", err)
+			}
+			if msg.From != "sam@example.com" {
+				t.Errorf("Expected sender sam@example.com, got %q", msg.From)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("Expected subject %q, got %q", tt.subject, msg.Subject)
+			}
+			if msg.Text != tt.text {
+				t.Errorf("Expected text %q, got %q", tt.text, msg.Text)
+			}
+		})
+	}
+}
+
+func TestParseMessage_Headers(t *testing.T) {
+	msg, err := ParseMessage(strings.NewReader("From: sam@example.com\r\n" +
+		"Message-ID: <answer@mail.example.com>\r\n" +
+		"In-Reply-To: <reply@example.com>\r\n" +
+		"References: <notification@example.com>\r\n <reply@example.com>\r\n" +
+		"Auto-Submitted: auto-replied\r\n" +
+		"\r\n" +
+		"I am out of office.\r\n"))
+	if err != nil {
+		t.Fatalf("ParseMessage returned error: %v", err)
+	}
+	if msg.MessageID != "<answer@mail.example.com>" || msg.InReplyTo != "<reply@example.com>" {
+		t.Errorf("Unexpected Message-IDs %q %q", msg.MessageID, msg.InReplyTo)
+	}
+	if strings.Join(msg.References, " ") != "<notification@example.com> <reply@example.com>" {
+		t.Errorf("Unexpected references %v", msg.References)
+	}
+	if !msg.AutoSubmitted {
+		t.Error("Expected the message to be recognized as automatic")
+	}
+}
+
+func TestStripQuoted(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "gmail",
+			text: "Thanks, that worked.\n\nOn Mon, 3 Jun 2024 at 10:00, Support <support@example.com> wrote:\n> A technician is on the way.\n",
+			want: "Thanks, that worked.",
+		},
+		{
+			name: "wrapped attribution",
+			text: "Thanks!\n\nOn Mon, 3 Jun 2024 at 10:00, Support Team at Example Inc\n<support@example.com> wrote:\n\n> Hello\n",
+			want: "Thanks!",
+		},
+		{
+			name: "outlook",
+			text: "Sounds good.\n\n-----Original Message-----\nFrom: Support\nSent: Monday\n\nHello\n",
+			want: "Sounds good.",
+		},
+		{
+			name: "outlook header block",
+			text: "Sounds good.\n\nFrom: Support <support@example.com>\nSent: Monday, June 3, 2024\nSubject: Re: Printer\n",
+			want: "Sounds good.",
+		},
+		{
+			name: "signature",
+			text: "See you then.\n-- \nSam\nExample Inc",
+			want: "See you then.",
+		},
+		{
+			name: "interleaved quotes",
+			text: "> When can you come?\nTuesday works.\n> Which floor?\nThird.",
+			want: "Tuesday works.\nThird.",
+		},
+		{
+			name: "only quoted text",
+			text: "> Hello",
+			want: "> Hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripQuoted(tt.text); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
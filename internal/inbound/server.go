@@ -0,0 +1,493 @@
+package inbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"formfling/internal/config"
+)
+
+const (
+	// commandTimeout bounds how long the server waits for a client
+	commandTimeout = 5 * time.Minute
+	// maxRecipients limits the recipients of a single message
+	maxRecipients = 100
+	// maxLineLength limits a command line, including its CRLF, as RFC 5321
+	// does
+	maxLineLength = 512
+	// maxConnections is the default limit of concurrent connections
+	maxConnections = 100
+)
+
+// errLineTooLong is returned for a command line over maxLineLength
+var errLineTooLong = errors.New("line too long")
+
+// Handler accepts the messages the server receives
+type Handler interface {
+	Accept(rcpt string) bool
+	Deliver(from, rcpt string, data []byte) error
+}
+
+// Server is a minimal SMTP or LMTP server. It accepts mail for the
+// recipients its handler accepts and has no authentication or TLS, so it is
+// meant to run behind the MX of the reply domain or as the LMTP target of a
+// local MTA.
+type Server struct {
+	Addr     string
+	LMTP     bool
+	Hostname string
+	MaxBytes int64
+	Handler  Handler
+	// MaxConns limits the concurrent connections, to maxConnections when
+	// zero. Clients over the limit are told to try again later.
+	MaxConns int
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool // whether each connection is running a command
+	closed   bool
+}
+
+// NewServer configures a server from the INBOUND_* settings
+func NewServer(cfg *config.Config, handler Handler) (*Server, error) {
+	if cfg.InboundDomain == "" || cfg.InboundSecret == "" {
+		return nil, fmt.Errorf("INBOUND_ADDR requires INBOUND_DOMAIN and INBOUND_SECRET")
+	}
+	if cfg.InboundProtocol != config.InboundSMTP && cfg.InboundProtocol != config.InboundLMTP {
+		return nil, fmt.Errorf("unknown INBOUND_PROTOCOL %q (expected smtp or lmtp)", cfg.InboundProtocol)
+	}
+	if cfg.InboundMaxBytes <= 0 {
+		return nil, fmt.Errorf("INBOUND_MAX_BYTES must be positive")
+	}
+	return &Server{
+		Addr:     cfg.InboundAddr,
+		LMTP:     cfg.InboundProtocol == config.InboundLMTP,
+		Hostname: cfg.InboundDomain,
+		MaxBytes: int64(cfg.InboundMaxBytes),
+		Handler:  handler,
+	}, nil
+}
+
+// ListenAndServe listens on the TCP address, or on a unix socket when the
+// address starts with unix:
+func (s *Server) ListenAndServe() error {
+	network, addr := "tcp", s.Addr
+	if path, ok := strings.CutPrefix(s.Addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections until the listener fails or the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if s.full() {
+			conn.SetDeadline(time.Now().Add(time.Second))
+			fmt.Fprintf(conn, "421 4.3.2 %s too many connections, try again later\r\n", s.Hostname)
+			conn.Close()
+			continue
+		}
+		if !s.track(conn, true) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.track(conn, false)
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops the listener and drops open connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// Shutdown stops the listener, closes idle connections and waits for the
+// commands in flight, such as a message being delivered, to finish. When the
+// context ends first the remaining connections are dropped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn, busy := range s.conns {
+		if !busy {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		open := len(s.conns)
+		s.mu.Unlock()
+		if open == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// setBusy marks a connection as running a command and reports whether the
+// server is still open
+func (s *Server) setBusy(conn net.Conn, busy bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = busy
+	}
+	return !s.closed
+}
+
+// full reports whether the server has as many connections as it takes
+func (s *Server) full() bool {
+	limit := s.MaxConns
+	if limit <= 0 {
+		limit = maxConnections
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns) >= limit
+}
+
+func (s *Server) track(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = false
+	return true
+}
+
+// session is the state of one client connection
+type session struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	text    *textproto.Reader
+	helo    bool
+	from    string
+	hasMail bool
+	rcpts   []string
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	sess := &session{server: s, conn: conn, reader: reader, text: textproto.NewReader(reader)}
+	protocol := "ESMTP"
+	if s.LMTP {
+		protocol = "LMTP"
+	}
+	sess.reply(220, "%s %s FormFling ready", s.Hostname, protocol)
+
+	for {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := sess.readLine()
+		if errors.Is(err, errLineTooLong) {
+			sess.reply(500, "5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+		s.setBusy(conn, true)
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.handle(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+		if !s.setBusy(conn, false) {
+			sess.closing()
+			return
+		}
+	}
+}
+
+// readLine reads a command line without its line ending
+func (sess *session) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := sess.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxLineLength {
+			return "", errLineTooLong
+		}
+		if err == nil {
+			return strings.TrimRight(string(line), "\r\n"), nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+}
+
+// closing answers the client's next command, typically QUIT after a message,
+// once the server is shutting down
+func (sess *session) closing() {
+	sess.conn.SetDeadline(time.Now().Add(time.Second))
+	line, err := sess.readLine()
+	if err != nil {
+		return
+	}
+	if verb, _, _ := strings.Cut(line, " "); strings.EqualFold(verb, "QUIT") {
+		sess.reply(221, "2.0.0 Bye")
+		return
+	}
+	sess.reply(421, "4.3.2 %s shutting down", sess.server.Hostname)
+}
+
+// handle runs one command and reports whether the session goes on
+func (sess *session) handle(verb, arg string) bool {
+	lmtp := sess.server.LMTP
+	switch {
+	case verb == "EHLO" && !lmtp, verb == "LHLO" && lmtp:
+		sess.reset()
+		sess.helo = true
+		sess.reply(250, "%s\n8BITMIME\nPIPELINING\nENHANCEDSTATUSCODES\nSIZE %d", sess.server.Hostname, sess.server.MaxBytes)
+	case verb == "HELO" && !lmtp:
+		sess.reset()
+		sess.helo = true
+		sess.reply(250, "%s", sess.server.Hostname)
+	case verb == "HELO" || verb == "EHLO":
+		sess.reply(500, "5.5.1 This is an LMTP server, use LHLO")
+	case verb == "LHLO":
+		sess.reply(500, "5.5.1 This is an SMTP server, use EHLO")
+	case verb == "MAIL":
+		sess.mail(arg)
+	case verb == "RCPT":
+		sess.rcpt(arg)
+	case verb == "DATA":
+		return sess.data()
+	case verb == "RSET":
+		sess.reset()
+		sess.reply(250, "2.0.0 OK")
+	case verb == "NOOP":
+		sess.reply(250, "2.0.0 OK")
+	case verb == "VRFY":
+		sess.reply(252, "2.5.0 Send some mail and see")
+	case verb == "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		sess.reply(502, "5.5.2 Command not implemented")
+	}
+	return true
+}
+
+func (sess *session) mail(arg string) {
+	if !sess.helo {
+		sess.reply(503, "5.5.1 Say hello first")
+		return
+	}
+	if sess.hasMail {
+		sess.reply(503, "5.5.1 Sender already given")
+		return
+	}
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(key, "SIZE") {
+			continue
+		}
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > sess.server.MaxBytes {
+			sess.reply(552, "5.3.4 Message too big")
+			return
+		}
+	}
+	sess.from = from
+	sess.hasMail = true
+	sess.reply(250, "2.1.0 OK")
+}
+
+func (sess *session) rcpt(arg string) {
+	if !sess.hasMail {
+		sess.reply(503, "5.5.1 Need MAIL first")
+		return
+	}
+	rcpt, _, ok := parsePath(arg, "TO:")
+	if !ok || rcpt == "" {
+		sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(sess.rcpts) >= maxRecipients {
+		sess.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+	if !sess.server.Handler.Accept(rcpt) {
+		sess.reply(550, "5.1.1 No such mailbox")
+		return
+	}
+	sess.rcpts = append(sess.rcpts, rcpt)
+	sess.reply(250, "2.1.5 OK")
+}
+
+func (sess *session) data() bool {
+	if len(sess.rcpts) == 0 {
+		sess.reply(503, "5.5.1 Need RCPT first")
+		return true
+	}
+	sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	sess.conn.SetDeadline(time.Now().Add(commandTimeout))
+	dot := sess.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(dot, sess.server.MaxBytes+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(data)) > sess.server.MaxBytes {
+		// Read the rest of the message so the session stays in sync
+		if _, err := io.Copy(io.Discard, dot); err != nil {
+			return false
+		}
+		sess.replyAll(552, "5.3.4 Message too big")
+		sess.reset()
+		return true
+	}
+
+	var failed error
+	for _, rcpt := range sess.rcpts {
+		err := sess.server.Handler.Deliver(sess.from, rcpt, data)
+		if err != nil {
+			slog.Warn("Inbound mail not delivered", "from", sess.from, "to", rcpt, "error", err)
+			failed = err
+		}
+		if sess.server.LMTP {
+			sess.replyErr(err)
+		}
+	}
+	if !sess.server.LMTP {
+		sess.replyErr(failed)
+	}
+	sess.reset()
+	return true
+}
+
+// replyErr answers DATA with the outcome of a delivery
+func (sess *session) replyErr(err error) {
+	switch {
+	case err == nil:
+		sess.reply(250, "2.0.0 Delivered")
+	case errors.Is(err, ErrNoMatch):
+		sess.reply(550, "5.1.1 No matching conversation")
+	case errors.Is(err, errInvalidMessage):
+		sess.reply(554, "5.6.0 Invalid message")
+	default:
+		sess.reply(451, "4.3.0 Try again later")
+	}
+}
+
+// replyAll answers DATA once, or once per recipient over LMTP
+func (sess *session) replyAll(code int, format string) {
+	n := 1
+	if sess.server.LMTP {
+		n = len(sess.rcpts)
+	}
+	for i := 0; i < n; i++ {
+		sess.reply(code, format)
+	}
+}
+
+func (sess *session) reset() {
+	sess.from = ""
+	sess.hasMail = false
+	sess.rcpts = nil
+}
+
+// reply writes a response; newlines in the text make a multiline response
+func (sess *session) reply(code int, format string, args ...interface{}) {
+	lines := strings.Split(fmt.Sprintf(format, args...), "\n")
+	w := bufio.NewWriter(sess.conn)
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		fmt.Fprintf(w, "%d%s%s\r\n", code, separator, line)
+	}
+	w.Flush()
+}
+
+// parsePath reads the address and parameters of MAIL FROM or RCPT TO
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.Index(arg, ">")
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
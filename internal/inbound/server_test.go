@@ -0,0 +1,238 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type delivery struct {
+	from, rcpt, data string
+}
+
+type mockHandler struct {
+	mu         sync.Mutex
+	deliveries []delivery
+}
+
+func (m *mockHandler) Accept(rcpt string) bool {
+	return strings.HasSuffix(rcpt, "@reply.example.com")
+}
+
+func (m *mockHandler) Deliver(from, rcpt string, data []byte) error {
+	if strings.HasPrefix(rcpt, "unknown@") {
+		return ErrNoMatch
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = append(m.deliveries, delivery{from, rcpt, string(data)})
+	return nil
+}
+
+func startServer(t *testing.T, lmtp bool) (*Server, *mockHandler, string) {
+	t.Helper()
+	handler := &mockHandler{}
+	server := &Server{LMTP: lmtp, Hostname: "reply.example.com", MaxBytes: 1024, Handler: handler}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- server.Serve(l) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Expected Serve to return net.ErrClosed, got %v", err)
+		}
+	})
+	return server, handler, l.Addr().String()
+}
+
+func TestServer_SMTP(t *testing.T) {
+	_, handler, addr := startServer(t, false)
+
+	msg := "Subject: Re: Printer\r\n\r\nThanks!\r\n.leading dot\r\n"
+	err := smtp.SendMail(addr, nil, "sam@example.com", []string{"reply+1@reply.example.com"}, []byte(msg))
+	if err != nil {
+		t.Fatalf("SendMail returned error: %v", err)
+	}
+	if len(handler.deliveries) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", len(handler.deliveries))
+	}
+	got := handler.deliveries[0]
+	if got.from != "sam@example.com" || got.rcpt != "reply+1@reply.example.com" || !strings.Contains(got.data, "\n.leading dot") {
+		t.Errorf("Unexpected delivery %+v", got)
+	}
+
+	err = smtp.SendMail(addr, nil, "sam@example.com", []string{"someone@example.com"}, []byte(msg))
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Expected relaying to be refused, got %v", err)
+	}
+
+	err = smtp.SendMail(addr, nil, "sam@example.com", []string{"reply+1@reply.example.com"}, []byte(strings.Repeat("x", 2048)))
+	if err == nil || !strings.Contains(err.Error(), "552") {
+		t.Errorf("Expected an oversized message to be refused, got %v", err)
+	}
+}
+
+func TestServer_LMTP(t *testing.T) {
+	_, handler, addr := startServer(t, true)
+
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expect := func(code int) {
+		t.Helper()
+		if _, _, err := conn.ReadResponse(code); err != nil {
+			t.Fatalf("Expected %d: %v", code, err)
+		}
+	}
+	send := func(line string, code int) {
+		t.Helper()
+		if err := conn.PrintfLine("%s", line); err != nil {
+			t.Fatal(err)
+		}
+		expect(code)
+	}
+
+	expect(220)
+	send("EHLO client", 500)
+	send("LHLO client", 250)
+	send("MAIL FROM:<sam@example.com> SIZE=100", 250)
+	send("RCPT TO:<reply+1@reply.example.com>", 250)
+	send("RCPT TO:<unknown@reply.example.com>", 250)
+	send("DATA", 354)
+	w := conn.DotWriter()
+	w.Write([]byte("Subject: Hi\r\n\r\nHello\r\n"))
+	w.Close()
+	// One answer per recipient
+	expect(250)
+	expect(550)
+	send("MAIL FROM:<sam@example.com> SIZE=4096", 552)
+	send("QUIT", 221)
+
+	if len(handler.deliveries) != 1 || handler.deliveries[0].rcpt != "reply+1@reply.example.com" {
+		t.Errorf("Unexpected deliveries %+v", handler.deliveries)
+	}
+}
+
+type blockingHandler struct {
+	started, release chan struct{}
+}
+
+func (b *blockingHandler) Accept(rcpt string) bool { return true }
+
+func (b *blockingHandler) Deliver(from, rcpt string, data []byte) error {
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	handler := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	server := &Server{Hostname: "reply.example.com", MaxBytes: 1024, Handler: handler}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+
+	// An idle client is disconnected right away
+	idle, err := textproto.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	if _, _, err := idle.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- smtp.SendMail(l.Addr().String(), nil, "sam@example.com", []string{"reply+1@reply.example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	}()
+	<-handler.started
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- server.Shutdown(ctx)
+	}()
+
+	if _, err := idle.ReadLine(); err == nil {
+		t.Error("Expected the idle connection to be closed")
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Expected Shutdown to wait for the delivery, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(handler.release)
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown returned error: %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("Expected the delivery in flight to finish, got %v", err)
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	server := &Server{Hostname: "reply.example.com", MaxBytes: 1024, Handler: &mockHandler{}, MaxConns: 1}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	first, err := textproto.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, _, err := first.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := textproto.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if _, _, err := second.ReadResponse(220); err == nil || !strings.HasPrefix(err.Error(), "421") {
+		t.Errorf("Expected a connection over the limit to be refused with 421, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		line string
+		code int
+	}{
+		{"longest line", "NOOP " + strings.Repeat("x", maxLineLength-len("NOOP \r\n")), 250},
+		{"line too long", "NOOP " + strings.Repeat("x", maxLineLength), 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := first.PrintfLine("%s", tt.line); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := first.ReadResponse(tt.code); err != nil {
+				t.Errorf("Expected %d: %v", tt.code, err)
+			}
+		})
+	}
+	if _, err := first.ReadLine(); err == nil {
+		t.Error("Expected the session to be dropped after a line too long")
+	}
+}
@@ -0,0 +1,171 @@
+// Package listener opens the sockets FormFling serves on: TCP addresses, unix
+// domain sockets and sockets passed in by systemd socket activation.
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Prefixes of LISTEN addresses that are not TCP addresses
+const (
+	UnixPrefix = "unix:"
+	Systemd    = "systemd"
+)
+
+// Options apply to unix domain sockets
+type Options struct {
+	// SocketMode is the permission of the socket file
+	SocketMode os.FileMode
+	// SocketGroup, when set, owns the socket file, so a proxy in that group
+	// can connect
+	SocketGroup string
+}
+
+// Listen opens a listener for an address: host:port, unix:/path/to/socket,
+// systemd for the only socket passed by systemd, or systemd:NAME for the
+// one named NAME in FileDescriptorName=
+func Listen(addr string, opts Options) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		return listenUnix(path, opts)
+	}
+	if addr == Systemd || strings.HasPrefix(addr, Systemd+":") {
+		return listenSystemd(strings.TrimPrefix(strings.TrimPrefix(addr, Systemd), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// CheckAddr reports whether an address can be passed to Listen, without
+// opening it
+func CheckAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("%q needs a socket path", addr)
+		}
+		return nil
+	}
+	if addr == Systemd || strings.HasPrefix(addr, Systemd+":") {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%q is not host:port, unix:/path or systemd[:name]", addr)
+	}
+	return nil
+}
+
+// listenUnix listens on a unix domain socket. A socket file left behind by
+// a process that did not exit cleanly is replaced.
+func listenUnix(path string, opts Options) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, opts.SocketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if opts.SocketGroup != "" {
+		group, err := user.LookupGroup(opts.SocketGroup)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		gid, _ := strconv.Atoi(group.Gid)
+		if err := os.Chown(path, -1, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// listenFDsStart is the first file descriptor passed by systemd
+var listenFDsStart = 3
+
+var (
+	inheritedMu sync.Mutex
+	inherited   []*os.File // an entry is nil once taken
+	inheritDone bool
+)
+
+// inheritFiles takes the sockets passed by systemd once, following
+// sd_listen_fds(3). The variables are cleared so child processes do not
+// take them as well.
+func inheritFiles() {
+	if inheritDone {
+		return
+	}
+	inheritDone = true
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		inherited = append(inherited, os.NewFile(uintptr(fd), name))
+	}
+}
+
+// listenSystemd takes a socket passed by systemd, by name if one is given
+func listenSystemd(name string) (net.Listener, error) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	inheritFiles()
+
+	var available []int
+	for i, file := range inherited {
+		if file != nil && (name == "" || file.Name() == name) {
+			available = append(available, i)
+		}
+	}
+	switch {
+	case len(available) == 0 && name == "":
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_FDS)")
+	case len(available) == 0:
+		return nil, fmt.Errorf("no socket named %q was passed by systemd (LISTEN_FDNAMES)", name)
+	case len(available) > 1:
+		return nil, fmt.Errorf("systemd passed %d sockets, pick one with systemd:NAME", len(available))
+	}
+
+	file := inherited[available[0]]
+	inherited[available[0]] = nil
+	l, err := net.FileListener(file)
+	file.Close() // FileListener works on a copy
+	if err != nil {
+		return nil, fmt.Errorf("socket %s passed by systemd: %v", file.Name(), err)
+	}
+	return l, nil
+}
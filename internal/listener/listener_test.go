@@ -0,0 +1,110 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListen_TCP(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, ok := l.Addr().(*net.TCPAddr); !ok {
+		t.Errorf("Expected a TCP listener, got %v", l.Addr())
+	}
+}
+
+func TestListen_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "formfling.sock")
+	l, err := Listen(UnixPrefix+path, Options{SocketMode: 0660})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("Expected mode 0660, got %v", info.Mode().Perm())
+	}
+
+	// A second server must not take over a socket in use
+	if _, err := Listen(UnixPrefix+path, Options{SocketMode: 0660}); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Expected an error about the socket in use, got %v", err)
+	}
+	l.Close()
+
+	// A stale socket file is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	l, err = Listen(UnixPrefix+path, Options{SocketMode: 0600})
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced, got %v", err)
+	}
+	l.Close()
+
+	notSocket := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(notSocket, nil, 0600)
+	if _, err := Listen(UnixPrefix+notSocket, Options{}); err == nil {
+		t.Error("Expected an error for a regular file")
+	}
+}
+
+func TestListen_Systemd(t *testing.T) {
+	passed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer passed.Close()
+	file, err := passed.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listenFDsStart = int(file.Fd())
+	inheritDone, inherited = false, nil
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "public")
+
+	if _, err := Listen("systemd:admin", Options{}); err == nil || !strings.Contains(err.Error(), `"admin"`) {
+		t.Errorf("Expected an error about the missing admin socket, got %v", err)
+	}
+	l, err := Listen("systemd:public", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != passed.Addr().String() {
+		t.Errorf("Expected the passed socket %v, got %v", passed.Addr(), l.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Expected LISTEN_FDS to be cleared")
+	}
+	if _, err := Listen(Systemd, Options{}); err == nil {
+		t.Error("Expected an error once the socket was taken")
+	}
+}
+
+func TestCheckAddr(t *testing.T) {
+	for _, addr := range []string{":8080", "127.0.0.1:8080", "[::1]:8080", "unix:/run/formfling.sock", "systemd", "systemd:admin"} {
+		if err := CheckAddr(addr); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"8080", "unix:", "localhost"} {
+		if err := CheckAddr(addr); err == nil {
+			t.Errorf("Expected %q to be rejected", addr)
+		}
+	}
+}
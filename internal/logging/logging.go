@@ -0,0 +1,161 @@
+// Package logging sets up structured logging with log/slog. Records carry the
+// IDs of the request and trace they belong to, and personal data is masked
+// unless debug logging is enabled.
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log formats for LOG_FORMAT
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces message bodies in logs
+const Redacted = "[redacted]"
+
+// level is the running LOG_LEVEL, which a reload may change
+var level = new(slog.LevelVar)
+
+// Setup sends slog records, and the output of the log package, to w in
+// format from level on
+func Setup(w io.Writer, format string, l slog.Level) {
+	level.Set(l)
+	opts := &slog.HandlerOptions{Level: level}
+	var base slog.Handler = slog.NewTextHandler(w, opts)
+	if format == FormatJSON {
+		base = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(&handler{next: base}))
+	log.SetFlags(0)
+}
+
+// SetLevel changes the level of the logger made by Setup
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// redacting reports whether personal data is masked, which it is unless
+// debug logging is enabled
+func redacting() bool {
+	return level.Level() > slog.LevelDebug
+}
+
+type contextKey struct{}
+
+// WithRequestID returns a context carrying a request ID for the logs
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the request ID of a context, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// handler adds the request and trace IDs and masks personal data before
+// records reach the text or JSON handler
+type handler struct {
+	next slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	redact := redacting()
+	message := r.Message
+	if redact {
+		message = Redact(message)
+	}
+	out := slog.NewRecord(r.Time, r.Level, message, r.PC)
+	if id := RequestID(ctx); id != "" {
+		out.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		out.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if redact {
+			a = redactAttr(a)
+		}
+		out.AddAttrs(a)
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if redacting() {
+		for i := range attrs {
+			attrs[i] = redactAttr(attrs[i])
+		}
+	}
+	return &handler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name)}
+}
+
+// bodyKeys are attributes holding what a submitter wrote, which are left
+// out of the logs entirely
+var bodyKeys = map[string]bool{"message": true, "body": true, "text": true, "html": true}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+	switch {
+	case bodyKeys[strings.ToLower(a.Key)]:
+		return slog.String(a.Key, Redacted)
+	case value.Kind() == slog.KindString:
+		return slog.String(a.Key, Redact(value.String()))
+	case value.Kind() == slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, len(group))
+		for i, attr := range group {
+			attrs[i] = redactAttr(attr)
+		}
+		return slog.Group(a.Key, attrs...)
+	case value.Kind() == slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,})`)
+	// phonePattern finds candidates; only those with enough digits that
+	// are not IP addresses are masked
+	phonePattern = regexp.MustCompile(`\+?\(?\d[\d ().-]{7,}\d`)
+)
+
+// Redact masks email addresses, keeping the domain, and phone numbers
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, "***@$1")
+	return phonePattern.ReplaceAllStringFunc(s, func(candidate string) string {
+		digits := 0
+		for _, c := range candidate {
+			if c >= '0' && c <= '9' {
+				digits++
+			}
+		}
+		if digits < 9 || net.ParseIP(candidate) != nil {
+			return candidate
+		}
+		return "[phone]"
+	})
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"from jane.doe+forms@example.co.uk", "from ***@example.co.uk"},
+		{"call +44 20 7946 0958 today", "call [phone] today"},
+		{"call (555) 123-4567", "call [phone]"},
+		{"client 192.168.100.200 connected", "client 192.168.100.200 connected"},
+		{"submission 12345678", "submission 12345678"},
+		{"took 2026-10-18", "took 2026-10-18"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func setupJSON(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(log.LstdFlags)
+	})
+	var buf bytes.Buffer
+	Setup(&buf, FormatJSON, level)
+	return &buf
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	return record
+}
+
+func TestHandler(t *testing.T) {
+	buf := setupJSON(t, slog.LevelInfo)
+	ctx := WithRequestID(context.Background(), "abc123")
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	slog.InfoContext(ctx, "Sent reply to jane@example.com",
+		"message", "Hello, my number is 555 123 4567",
+		"error", errors.New("mailbox bob@example.org full"))
+
+	record := decode(t, buf)
+	want := map[string]interface{}{
+		"msg":        "Sent reply to ***@example.com",
+		"request_id": "abc123",
+		"trace_id":   "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id":    "00f067aa0ba902b7",
+		"message":    Redacted,
+		"error":      "mailbox ***@example.org full",
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("Expected %s %q, got %q", key, value, record[key])
+		}
+	}
+}
+
+func TestHandler_Debug(t *testing.T) {
+	buf := setupJSON(t, slog.LevelDebug)
+	slog.Debug("Sent reply to jane@example.com", "message", "Hello")
+
+	record := decode(t, buf)
+	if record["msg"] != "Sent reply to jane@example.com" || record["message"] != "Hello" {
+		t.Errorf("Expected debug logs to keep personal data, got %v", record)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf := setupJSON(t, slog.LevelWarn)
+	slog.Info("hidden")
+	log.Printf("also hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("Expected info records to be dropped at warn, got %q", buf.String())
+	}
+
+	SetLevel(slog.LevelInfo)
+	log.Printf("shown")
+	if !strings.Contains(buf.String(), `"msg":"shown"`) {
+		t.Errorf("Expected the log package to write through slog, got %q", buf.String())
+	}
+}
@@ -0,0 +1,171 @@
+// Package metrics collects the Prometheus metrics served at /metrics. The
+// collectors live for the whole process, so counts carry over reloads.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of submissions
+const (
+	OutcomeAccepted         = "accepted"
+	OutcomeValidationFailed = "validation-failed"
+	OutcomeCaptchaFailed    = "captcha-failed"
+	OutcomeSpam             = "spam"
+	OutcomeBadRequest       = "bad-request"
+	OutcomeDeliveryFailed   = "delivery-failed"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	submissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "formfling_submissions_total",
+		Help: "Submissions by form and outcome.",
+	}, []string{"form", "outcome"})
+
+	recaptchaScores = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "formfling_recaptcha_score",
+		Help:    "Scores returned by reCAPTCHA v3, by action.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"action"})
+
+	smtpSendDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "formfling_smtp_send_duration_seconds",
+		Help:    "Time taken to hand a message to the SMTP server, successful or not.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+
+	smtpErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "formfling_smtp_errors_total",
+		Help: "Failed SMTP sends by the step that failed: connect, tls, auth, sender, recipient, data or timeout.",
+	}, []string{"class"})
+
+	deliveriesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "formfling_deliveries_in_flight",
+		Help: "Deliveries being sent right now, by channel. Deliveries run within their request, so this is the delivery queue.",
+	}, []string{"channel"})
+
+	deliveryRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "formfling_delivery_retries_total",
+		Help: "Deliveries sent again after an earlier attempt, by channel and result.",
+	}, []string{"channel", "result"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "formfling_http_request_duration_seconds",
+		Help:    "Time taken to answer HTTP requests, by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		submissions,
+		recaptchaScores,
+		smtpSendDuration,
+		smtpErrors,
+		deliveriesInFlight,
+		deliveryRetries,
+		httpDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Submission counts a submission to a form
+func Submission(form, outcome string) {
+	submissions.WithLabelValues(form, outcome).Inc()
+}
+
+// RecaptchaScore records the score of a verified reCAPTCHA token
+func RecaptchaScore(action string, score float64) {
+	recaptchaScores.WithLabelValues(action).Observe(score)
+}
+
+// SMTPSend records an SMTP send. class names the failed step and is empty
+// when the send succeeded.
+func SMTPSend(duration time.Duration, class string) {
+	smtpSendDuration.Observe(duration.Seconds())
+	if class != "" {
+		smtpErrors.WithLabelValues(class).Inc()
+	}
+}
+
+// DeliveryStarted counts a delivery as in flight until the returned function
+// is called with its outcome. attempt is 1 for the first try.
+func DeliveryStarted(channel string, attempt int) func(err error) {
+	gauge := deliveriesInFlight.WithLabelValues(channel)
+	gauge.Inc()
+	return func(err error) {
+		gauge.Dec()
+		if attempt > 1 {
+			result := "sent"
+			if err != nil {
+				result = "failed"
+			}
+			deliveryRetries.WithLabelValues(channel, result).Inc()
+		}
+	}
+}
+
+// HTTP records request durations by route template, so the label values are
+// bounded by the routes and not by the URLs clients make up. It is router
+// middleware; a route without a path template, such as the one handing admin
+// routes to their own router, is left to the inner router.
+func HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := mux.CurrentRoute(r)
+		if current == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, err := current.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpDuration.WithLabelValues(route, method(r.Method), strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// method limits the method label to the standard methods
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "other"
+}
+
+// statusRecorder remembers the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the connection, so exports can
+// lift the write deadline
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSubmission(t *testing.T) {
+	before := testutil.ToFloat64(submissions.WithLabelValues("contact", OutcomeSpam))
+	Submission("contact", OutcomeSpam)
+	if got := testutil.ToFloat64(submissions.WithLabelValues("contact", OutcomeSpam)); got != before+1 {
+		t.Errorf("Expected the spam count to grow by one, got %v after %v", got, before)
+	}
+}
+
+func TestDeliveryStarted(t *testing.T) {
+	inFlight := deliveriesInFlight.WithLabelValues("test")
+	failedRetries := deliveryRetries.WithLabelValues("test", "failed")
+
+	done := DeliveryStarted("test", 1)
+	if got := testutil.ToFloat64(inFlight); got != 1 {
+		t.Errorf("Expected one delivery in flight, got %v", got)
+	}
+	done(nil)
+	if got := testutil.ToFloat64(inFlight); got != 0 {
+		t.Errorf("Expected no delivery in flight, got %v", got)
+	}
+
+	DeliveryStarted("test", 2)(errors.New("connection refused"))
+	if got := testutil.ToFloat64(failedRetries); got != 1 {
+		t.Errorf("Expected one failed retry, got %v", got)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(HTTP)
+	r.HandleFunc("/f/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	inner := mux.NewRouter()
+	inner.Use(HTTP)
+	inner.HandleFunc("/admin/{page}", func(w http.ResponseWriter, r *http.Request) {})
+	r.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+		var match mux.RouteMatch
+		return inner.Match(req, &match)
+	}).Handler(inner)
+
+	for _, path := range []string{"/f/one", "/f/two", "/admin/submissions"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/f/coffee", nil))
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		`formfling_http_request_duration_seconds_count{code="404",method="GET",route="/f/{slug}"} 2`,
+		`formfling_http_request_duration_seconds_count{code="404",method="other",route="/f/{slug}"} 1`,
+		`formfling_http_request_duration_seconds_count{code="200",method="GET",route="/admin/{page}"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the metrics to contain %s", want)
+		}
+	}
+	if got := strings.Count(body, "formfling_http_request_duration_seconds_count{"); got != 3 {
+		t.Errorf("Expected three request series, got %d", got)
+	}
+}
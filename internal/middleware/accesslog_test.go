@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	handler := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short"))
+	}))
+	req := httptest.NewRequest("POST", "/submit?email=jane@example.com", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	for _, want := range []string{"method=POST", "path=/submit", "status=418", "bytes=5"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in the access log, got %q", want, line)
+		}
+	}
+	if strings.Contains(line, "jane") {
+		t.Errorf("Expected the query string to be left out, got %q", line)
+	}
+}
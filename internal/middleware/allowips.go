@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// AllowIPs refuses requests from addresses outside networks. The address is
+// that of the connection, as forwarding headers can be forged. Connections
+// over unix sockets have no address and are left to the socket permissions.
+func AllowIPs(networks []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			addr := addrPort.Addr().Unmap()
+			for _, network := range networks {
+				if network.Contains(addr) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestAllowIPs(t *testing.T) {
+	networks := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	handler := AllowIPs(networks)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"inside the network", "10.2.3.4:51234", "", http.StatusOK},
+		{"IPv6 loopback", "[::1]:51234", "", http.StatusOK},
+		{"IPv4-mapped", "[::ffff:10.0.0.1]:51234", "", http.StatusOK},
+		{"outside", "192.0.2.1:51234", "", http.StatusForbidden},
+		{"forged forwarding header", "192.0.2.1:51234", "10.0.0.1", http.StatusForbidden},
+		{"unix socket", "@", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
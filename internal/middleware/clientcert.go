@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// RequireClientCert refuses requests that did not come with a client
+// certificate verified against TLS_CLIENT_CA_FILE, except to the exempt paths
+func RequireClientCert(exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(exempt, r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+				http.Error(w, "client certificate required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireClientCert(t *testing.T) {
+	handler := RequireClientCert("/health", "/livez")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	tests := []struct {
+		name string
+		path string
+		tls  *tls.ConnectionState
+		want int
+	}{
+		{"admin over plain HTTP", "/admin/submissions", nil, http.StatusForbidden},
+		{"admin without certificate", "/admin", &tls.ConnectionState{}, http.StatusForbidden},
+		{"admin with certificate", "/admin/login", verified, http.StatusOK},
+		{"metrics without certificate", "/metrics", &tls.ConnectionState{}, http.StatusForbidden},
+		{"pprof without certificate", "/debug/pprof/heap", &tls.ConnectionState{}, http.StatusForbidden},
+		{"API with certificate", "/api/v1/submissions", verified, http.StatusOK},
+		{"exempt probe", "/livez", &tls.ConnectionState{}, http.StatusOK},
+		{"below an exempt path", "/health/x", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.TLS = tt.tls
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"formfling/internal/config"
 )
@@ -11,8 +13,9 @@ func CORS(cfg *config.Config) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
 
-			// Check if origin is allowed (skip check if ALLOWED_ORIGINS is "*" or empty)
-			if len(cfg.AllowedOrigins) > 0 {
+			// Check if origin is allowed (skip check if ALLOWED_ORIGINS is "*" or empty).
+			// Same-origin requests from hosted form pages and the admin are always allowed.
+			if len(cfg.AllowedOrigins) > 0 && !isSameOrigin(r, origin) {
 				allowed := false
 				for _, allowedOrigin := range cfg.AllowedOrigins {
 					if origin == allowedOrigin {
@@ -34,7 +37,7 @@ func CORS(cfg *config.Config) func(http.Handler) http.Handler {
 			}
 
 			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Requested-With")
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -45,3 +48,9 @@ func CORS(cfg *config.Config) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// isSameOrigin reports whether the Origin header names the host serving the request
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
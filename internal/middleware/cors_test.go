@@ -124,7 +124,7 @@ func TestCORS_OptionsRequest(t *testing.T) {
 		t.Errorf("Expected Access-Control-Allow-Methods to be '%s', got %s", expectedMethods, rr.Header().Get("Access-Control-Allow-Methods"))
 	}
 
-	expectedHeaders := "Content-Type"
+	expectedHeaders := "Content-Type, X-Requested-With"
 	if rr.Header().Get("Access-Control-Allow-Headers") != expectedHeaders {
 		t.Errorf("Expected Access-Control-Allow-Headers to be '%s', got %s", expectedHeaders, rr.Header().Get("Access-Control-Allow-Headers"))
 	}
@@ -167,3 +167,28 @@ func containsInner(s, substr string) bool {
 	}
 	return false
 }
+
+func TestCORS_SameOriginAlwaysAllowed(t *testing.T) {
+	cfg := &config.Config{
+		AllowedOrigins: []string{"https://example.com"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CORS(cfg)(handler)
+
+	req, err := http.NewRequest("POST", "https://forms.example.org/admin/submissions/1/spam", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "https://forms.example.org")
+
+	rr := httptest.NewRecorder()
+	corsHandler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for same-origin request, got %d", rr.Code)
+	}
+}
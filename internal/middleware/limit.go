@@ -0,0 +1,19 @@
+package middleware
+
+import "net/http"
+
+// MaxBytes limits request bodies to limit bytes. Requests that declare a
+// larger body are refused up front; handlers reading past the limit of a
+// body without a length get an error.
+func MaxBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBytes(t *testing.T) {
+	handler := MaxBytes(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{"within the limit", "short", 5, http.StatusOK},
+		{"declared too large", "far too long", 12, http.StatusRequestEntityTooLarge},
+		{"chunked too large", "far too long", -1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
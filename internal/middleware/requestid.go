@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"formfling/internal/logging"
+)
+
+// RequestIDHeader carries the ID of a request
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID accepts the IDs proxies and load balancers commonly send;
+// anything else is replaced, so clients cannot inject text into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID gives every request an ID, taken from X-Request-ID when a proxy
+// set one and made up otherwise. The ID is sent back in X-Request-ID and
+// added to the logs of the request.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"formfling/internal/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"from a proxy", "edge-7f3a:42", true},
+		{"missing", "", false},
+		{"unsafe", "x\nforged log line", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			id := rr.Header().Get(RequestIDHeader)
+			if id == "" || id != seen {
+				t.Fatalf("Expected the response and context to share an ID, got %q and %q", id, seen)
+			}
+			if (id == tt.incoming) != tt.keep {
+				t.Errorf("Incoming ID %q: got %q", tt.incoming, id)
+			}
+		})
+	}
+}
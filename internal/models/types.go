@@ -1,5 +1,12 @@
 package models
 
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type FormData struct {
 	Name              string `json:"name"`
 	Email             string `json:"email"`
@@ -8,6 +15,31 @@ type FormData struct {
 	Phone             string `json:"phone"`
 	Website           string `json:"website"`
 	RecaptchaResponse string `json:"g-recaptcha-response"` // reCAPTCHA v3 token
+
+	// Extra holds the values of fields defined by the form beyond the
+	// built-in ones above
+	Extra []ExtraField `json:"-"`
+}
+
+// ExtraField is a submitted value of a form-defined field
+type ExtraField struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// FieldDefinition describes an input of a form. It drives the hosted form page
+// and server-side validation.
+type FieldDefinition struct {
+	Name        string   `json:"name"`
+	Label       string   `json:"label"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required"`
+	Placeholder string   `json:"placeholder"`
+	Help        string   `json:"help"`
+	Options     []string `json:"options"`
+	MinLength   int      `json:"min_length"`
+	MaxLength   int      `json:"max_length"`
 }
 
 type EmailTemplateData struct {
@@ -21,3 +53,480 @@ type Response struct {
 	Status string `json:"status"`
 	Error  string `json:"error,omitempty"`
 }
+
+// SpecialFields holds the Formspree-style underscore fields that control how
+// a submission is delivered rather than what it contains
+type SpecialFields struct {
+	ReplyTo  string `json:"_replyto"`
+	Subject  string `json:"_subject"`
+	CC       string `json:"_cc"`
+	Next     string `json:"_next"`
+	Gotcha   string `json:"_gotcha"`
+	Format   string `json:"_format"`
+	Redirect string `json:"_redirect"`
+	Pow      string `json:"_pow"`
+	CSRF     string `json:"_csrf"`
+}
+
+// EmailOptions overrides the configured notification settings for a single
+// submission. Empty values fall back to the configuration.
+type EmailOptions struct {
+	FormTitle string   `json:"form_title,omitempty"`
+	ToEmail   string   `json:"to_email,omitempty"`
+	ToName    string   `json:"to_name,omitempty"`
+	Subject   string   `json:"subject,omitempty"`
+	ReplyTo   string   `json:"reply_to,omitempty"`
+	CC        []string `json:"cc,omitempty"`
+	PlainText bool     `json:"plain_text,omitempty"`
+	MessageID string   `json:"message_id,omitempty"`
+}
+
+// Reply is an email to the submitter, threaded under the notification by its
+// In-Reply-To and References headers
+type Reply struct {
+	To         string
+	ReplyTo    string
+	Subject    string
+	Body       string
+	MessageID  string
+	InReplyTo  string
+	References []string
+}
+
+// ReplyTemplate is a saved reply. Subject and body may contain {{ field }}
+// placeholders filled from the submission.
+type ReplyTemplate struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FieldError describes a problem with a single submitted field
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// FormspreeResponse mirrors the JSON bodies returned by Formspree so existing
+// client code keeps working after switching the form action URL
+type FormspreeResponse struct {
+	OK     bool         `json:"ok,omitempty"`
+	Next   string       `json:"next,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// Value returns the submitted value of a built-in or form-defined field
+func (f FormData) Value(name string) string {
+	switch name {
+	case "name":
+		return f.Name
+	case "email":
+		return f.Email
+	case "subject":
+		return f.Subject
+	case "message":
+		return f.Message
+	case "phone":
+		return f.Phone
+	case "website":
+		return f.Website
+	}
+	for _, extra := range f.Extra {
+		if extra.Name == name {
+			return extra.Value
+		}
+	}
+	return ""
+}
+
+// IsBuiltinField reports whether the field has a dedicated FormData member
+func IsBuiltinField(name string) bool {
+	switch name {
+	case "name", "email", "subject", "message", "phone", "website":
+		return true
+	}
+	return false
+}
+
+// Delivery statuses
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliverySkipped = "skipped"
+)
+
+// Delivery records the outcome of sending a submission over one channel
+type Delivery struct {
+	Channel   string    `json:"channel"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Submission is a stored form submission
+type Submission struct {
+	ID         string       `json:"id"`
+	Form       string       `json:"form"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Data       FormData     `json:"data"`
+	Extra      []ExtraField `json:"extra,omitempty"`
+	Origin     string       `json:"origin,omitempty"`
+	RemoteIP   string       `json:"remote_ip,omitempty"`
+	Spam       bool         `json:"spam"`
+	Options    EmailOptions `json:"options"`
+	Deliveries []Delivery   `json:"deliveries,omitempty"`
+
+	// Ticket fields track how the submission is handled
+	Status   string   `json:"status"`
+	Assignee string   `json:"assignee,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Events   []Event  `json:"events,omitempty"`
+}
+
+// Ticket statuses
+const (
+	TicketOpen       = "open"
+	TicketInProgress = "in_progress"
+	TicketClosed     = "closed"
+)
+
+// TicketStatuses lists every ticket status in workflow order
+var TicketStatuses = []string{TicketOpen, TicketInProgress, TicketClosed}
+
+// ValidTicketStatus reports whether status is a known ticket status
+func ValidTicketStatus(status string) bool {
+	for _, s := range TicketStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeline event kinds
+const (
+	EventNote     = "note"
+	EventStatus   = "status"
+	EventAssignee = "assignee"
+	EventTags     = "tags"
+	EventSpam     = "spam"
+	EventReply    = "reply"
+	EventEmail    = "email"
+)
+
+// Event is an entry on the timeline of a submission: an internal note, a
+// reply to the submitter, an email from the submitter, or a change of a ticket field from one value to another
+type Event struct {
+	Kind      string    `json:"kind"`
+	Actor     string    `json:"actor"`
+	At        time.Time `json:"at"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+	Body      string    `json:"body,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+}
+
+// FormData returns the submitted data including the form-defined fields
+func (s *Submission) FormData() FormData {
+	data := s.Data
+	data.Extra = s.Extra
+	return data
+}
+
+// Delivery returns the delivery record for a channel, creating it if needed
+func (s *Submission) Delivery(channel string) *Delivery {
+	for i := range s.Deliveries {
+		if s.Deliveries[i].Channel == channel {
+			return &s.Deliveries[i]
+		}
+	}
+	s.Deliveries = append(s.Deliveries, Delivery{Channel: channel, Status: DeliveryPending})
+	return &s.Deliveries[len(s.Deliveries)-1]
+}
+
+// record appends a change to the timeline if the value differs
+func (s *Submission) record(actor, kind, from, to string) bool {
+	if from == to {
+		return false
+	}
+	s.Events = append(s.Events, Event{Kind: kind, Actor: actor, At: time.Now(), From: from, To: to})
+	return true
+}
+
+// SetStatus changes the ticket status and reports whether it changed
+func (s *Submission) SetStatus(actor, status string) bool {
+	changed := s.record(actor, EventStatus, s.Status, status)
+	s.Status = status
+	return changed
+}
+
+// SetAssignee changes who handles the submission; empty unassigns it
+func (s *Submission) SetAssignee(actor, assignee string) bool {
+	changed := s.record(actor, EventAssignee, s.Assignee, assignee)
+	s.Assignee = assignee
+	return changed
+}
+
+// SetTags replaces the tags after normalizing them
+func (s *Submission) SetTags(actor string, tags []string) bool {
+	tags = NormalizeTags(tags)
+	changed := s.record(actor, EventTags, strings.Join(s.Tags, ", "), strings.Join(tags, ", "))
+	s.Tags = tags
+	return changed
+}
+
+// SetSpam marks the submission as spam or ham
+func (s *Submission) SetSpam(actor string, spam bool) bool {
+	changed := s.record(actor, EventSpam, strconv.FormatBool(s.Spam), strconv.FormatBool(spam))
+	s.Spam = spam
+	return changed
+}
+
+// AddNote adds an internal note to the timeline
+func (s *Submission) AddNote(actor, body string) Event {
+	event := Event{Kind: EventNote, Actor: actor, At: time.Now(), Body: body}
+	s.Events = append(s.Events, event)
+	return event
+}
+
+// AddReply adds a sent reply to the timeline
+func (s *Submission) AddReply(actor string, reply Reply) Event {
+	event := Event{
+		Kind:      EventReply,
+		Actor:     actor,
+		At:        time.Now(),
+		To:        reply.To,
+		Subject:   reply.Subject,
+		Body:      reply.Body,
+		MessageID: reply.MessageID,
+	}
+	s.Events = append(s.Events, event)
+	return event
+}
+
+// AddEmail adds an email received from the submitter to the timeline
+func (s *Submission) AddEmail(from, subject, body, messageID string) Event {
+	event := Event{
+		Kind:      EventEmail,
+		Actor:     from,
+		At:        time.Now(),
+		From:      from,
+		Subject:   subject,
+		Body:      body,
+		MessageID: messageID,
+	}
+	s.Events = append(s.Events, event)
+	return event
+}
+
+// HasMessage reports whether an email with the Message-ID is part of the
+// conversation
+func (s *Submission) HasMessage(messageID string) bool {
+	for _, id := range s.ThreadIDs() {
+		if id == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplyAddress returns the address replies to the submitter go to
+func (s *Submission) ReplyAddress() string {
+	if s.Options.ReplyTo != "" {
+		return s.Options.ReplyTo
+	}
+	return s.Data.Email
+}
+
+// ThreadIDs returns the Message-IDs of the email conversation, oldest first:
+// the notification followed by every message on the timeline
+func (s *Submission) ThreadIDs() []string {
+	var ids []string
+	if s.Options.MessageID != "" {
+		ids = append(ids, s.Options.MessageID)
+	}
+	for _, event := range s.Events {
+		if event.MessageID != "" {
+			ids = append(ids, event.MessageID)
+		}
+	}
+	return ids
+}
+
+// HasTag reports whether the submission carries the tag
+func (s *Submission) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTags lowercases and trims tags, dropping blanks and duplicates,
+// and sorts them
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// Failed reports whether any delivery channel failed
+func (s *Submission) Failed() bool {
+	for _, d := range s.Deliveries {
+		if d.Status == DeliveryFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// API key scopes
+const (
+	ScopeSubmissionsRead   = "submissions:read"
+	ScopeSubmissionsWrite  = "submissions:write"
+	ScopeSubmissionsDelete = "submissions:delete"
+	ScopeSubmissionsReplay = "submissions:replay"
+	ScopeFormsRead         = "forms:read"
+	ScopeFormsWrite        = "forms:write"
+)
+
+// Scopes lists every API key scope
+var Scopes = []string{
+	ScopeSubmissionsRead,
+	ScopeSubmissionsWrite,
+	ScopeSubmissionsDelete,
+	ScopeSubmissionsReplay,
+	ScopeFormsRead,
+	ScopeFormsWrite,
+}
+
+// APIKey grants scripted access to the admin API. Only a hash of the key is
+// stored.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Hash       string    `json:"hash"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// HasScope reports whether the key grants the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin roles, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Roles lists every admin role
+var Roles = []string{RoleOwner, RoleEditor, RoleViewer}
+
+// User is an admin account. Owners see every form; editors and viewers only
+// the forms they were granted.
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	// OIDCSubject links accounts created by single sign-on to the identity
+	// provider's issuer and subject
+	OIDCSubject  string    `json:"oidc_subject,omitempty"`
+	Role         string    `json:"role"`
+	Forms        []string  `json:"forms,omitempty"`
+	TOTPSecret   string    `json:"totp_secret,omitempty"`
+	TOTPLastStep int64     `json:"totp_last_step,omitempty"`
+	FailedLogins int       `json:"failed_logins,omitempty"`
+	LockedUntil  time.Time `json:"locked_until,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastLoginAt  time.Time `json:"last_login_at,omitempty"`
+}
+
+// CanAccess reports whether the user may see submissions of the form
+func (u *User) CanAccess(form string) bool {
+	if u.Role == RoleOwner {
+		return true
+	}
+	for _, f := range u.Forms {
+		if f == form {
+			return true
+		}
+	}
+	return false
+}
+
+// CanEdit reports whether the user may change submissions
+func (u *User) CanEdit() bool {
+	return u.Role == RoleOwner || u.Role == RoleEditor
+}
+
+// IsOwner reports whether the user may manage API keys and settings
+func (u *User) IsOwner() bool {
+	return u.Role == RoleOwner
+}
+
+// RoleScopes returns the API scopes a role grants to single sign-on tokens
+func RoleScopes(role string) []string {
+	switch role {
+	case RoleOwner:
+		return Scopes
+	case RoleEditor:
+		return []string{ScopeSubmissionsRead, ScopeSubmissionsWrite, ScopeSubmissionsDelete, ScopeSubmissionsReplay, ScopeFormsRead}
+	case RoleViewer:
+		return []string{ScopeSubmissionsRead, ScopeFormsRead}
+	}
+	return nil
+}
+
+// RoleRank orders roles, higher is more privileged
+func RoleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return len(Roles) - i
+		}
+	}
+	return 0
+}
+
+// ValidRole reports whether role is a known admin role
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Session is a signed-in admin browser session. It is stored under a hash of
+// its cookie value.
+type Session struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -171,3 +172,45 @@ func TestEmailTemplateData(t *testing.T) {
 		}
 	})
 }
+
+func TestSubmission_TicketHistory(t *testing.T) {
+	sub := &Submission{Status: TicketOpen}
+
+	if !sub.SetStatus("alice", TicketInProgress) || sub.SetStatus("alice", TicketInProgress) {
+		t.Error("Expected only a real status change to be recorded")
+	}
+	sub.SetAssignee("alice", "bob")
+	if !sub.SetTags("bob", []string{" VIP", "billing", "vip", ""}) {
+		t.Error("Expected the tags to change")
+	}
+	if sub.SetTags("bob", []string{"billing", "VIP"}) {
+		t.Error("Expected equivalent tags not to be recorded")
+	}
+	sub.SetSpam("bob", true)
+	sub.AddNote("bob", "Called back")
+
+	if strings.Join(sub.Tags, ",") != "billing,vip" || !sub.HasTag("VIP") {
+		t.Errorf("Expected normalized tags, got %v", sub.Tags)
+	}
+
+	expected := []Event{
+		{Kind: EventStatus, Actor: "alice", From: TicketOpen, To: TicketInProgress},
+		{Kind: EventAssignee, Actor: "alice", To: "bob"},
+		{Kind: EventTags, Actor: "bob", To: "billing, vip"},
+		{Kind: EventSpam, Actor: "bob", From: "false", To: "true"},
+		{Kind: EventNote, Actor: "bob", Body: "Called back"},
+	}
+	if len(sub.Events) != len(expected) {
+		t.Fatalf("Expected %d events, got %+v", len(expected), sub.Events)
+	}
+	for i, want := range expected {
+		got := sub.Events[i]
+		if got.At.IsZero() {
+			t.Errorf("Event %d has no time", i)
+		}
+		got.At = want.At
+		if got != want {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+}
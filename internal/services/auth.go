@@ -0,0 +1,266 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"formfling/internal/models"
+	"formfling/internal/store"
+)
+
+const (
+	// SessionCookieName holds the admin session token
+	SessionCookieName = "formfling_session"
+
+	sessionTTL = 12 * time.Hour
+
+	// Accounts lock after maxFailedLogins consecutive failures
+	maxFailedLogins = 5
+	lockoutDuration = 15 * time.Minute
+
+	// Addresses are throttled after maxFailuresPerIP failures in the window,
+	// whichever accounts they tried
+	maxFailuresPerIP = 20
+	ipFailureWindow  = 15 * time.Minute
+
+	minPasswordLength = 10
+)
+
+var (
+	// ErrInvalidCredentials hides whether the username or the password was wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrTOTPRequired asks for the two-factor code of an account that has one
+	ErrTOTPRequired = errors.New("two-factor code required")
+	// ErrInvalidTOTP is returned for a wrong or reused two-factor code
+	ErrInvalidTOTP = errors.New("invalid two-factor code")
+	// ErrTooManyAttempts is returned while an account or address is locked out
+	ErrTooManyAttempts = errors.New("too many failed sign-in attempts, try again later")
+)
+
+// dummyHash is compared against when the account does not exist, so unknown
+// usernames take as long to reject as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("formfling-dummy-password"), bcrypt.DefaultCost)
+
+// AuthService signs admin users in and manages their sessions
+type AuthService struct {
+	store *store.Store
+
+	mu         sync.Mutex
+	ipFailures map[string][]time.Time
+}
+
+// NewAuthService creates a new admin authentication service
+func NewAuthService(submissionStore *store.Store) *AuthService {
+	return &AuthService{
+		store:      submissionStore,
+		ipFailures: make(map[string][]time.Time),
+	}
+}
+
+// HashPassword returns the bcrypt hash of a password
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// Login checks a username, password and, for accounts with two-factor
+// authentication, the TOTP code. Failures are throttled per account and per
+// client address ip; an empty ip, for a client whose address is unknown, is
+// throttled per account only.
+func (a *AuthService) Login(username, password, code, ip string) (*models.User, error) {
+	if a.ipLocked(ip) {
+		return nil, ErrTooManyAttempts
+	}
+
+	user, err := a.store.GetUser(username)
+	if errors.Is(err, store.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		a.recordIPFailure(ip)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.Before(user.LockedUntil) {
+		return nil, ErrTooManyAttempts
+	}
+
+	// Accounts created through single sign-on have no password
+	if user.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, a.fail(user, ip, ErrInvalidCredentials)
+	}
+
+	if user.TOTPSecret != "" {
+		if code == "" {
+			return nil, ErrTOTPRequired
+		}
+		step, ok := VerifyTOTP(user.TOTPSecret, code, now)
+		if !ok || step <= user.TOTPLastStep {
+			return nil, a.fail(user, ip, ErrInvalidTOTP)
+		}
+		user.TOTPLastStep = step
+	}
+
+	// The account is read again in the transaction that updates it, so a
+	// lockout or a code used by a concurrent sign-in is still honoured
+	return a.store.ModifyUser(user.Username, func(stored *models.User) error {
+		if now.Before(stored.LockedUntil) {
+			return ErrTooManyAttempts
+		}
+		if user.TOTPSecret != "" {
+			if user.TOTPLastStep <= stored.TOTPLastStep {
+				return ErrInvalidTOTP
+			}
+			stored.TOTPLastStep = user.TOTPLastStep
+		}
+		stored.FailedLogins = 0
+		stored.LockedUntil = time.Time{}
+		stored.LastLoginAt = now
+		return nil
+	})
+}
+
+// fail counts a failed sign-in, incrementing the stored count in one
+// transaction so concurrent attempts cannot overwrite each other's failures
+func (a *AuthService) fail(user *models.User, ip string, reason error) error {
+	a.recordIPFailure(ip)
+	_, err := a.store.ModifyUser(user.Username, func(stored *models.User) error {
+		stored.FailedLogins++
+		if stored.FailedLogins >= maxFailedLogins {
+			stored.LockedUntil = time.Now().Add(lockoutDuration)
+			stored.FailedLogins = 0
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return reason
+}
+
+func (a *AuthService) ipLocked(ip string) bool {
+	if ip == "" {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.recentFailures(ip)) >= maxFailuresPerIP
+}
+
+func (a *AuthService) recordIPFailure(ip string) {
+	if ip == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ipFailures[ip] = append(a.recentFailures(ip), time.Now())
+}
+
+// recentFailures drops failures outside the window. Callers hold a.mu.
+func (a *AuthService) recentFailures(ip string) []time.Time {
+	cutoff := time.Now().Add(-ipFailureWindow)
+	failures := a.ipFailures[ip]
+	for len(failures) > 0 && failures[0].Before(cutoff) {
+		failures = failures[1:]
+	}
+	if len(failures) == 0 {
+		delete(a.ipFailures, ip)
+		return nil
+	}
+	a.ipFailures[ip] = failures
+	return failures
+}
+
+// StartSession signs the user in on this browser
+func (a *AuthService) StartSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("failed to generate session token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	sess := &models.Session{Username: user.Username, CreatedAt: now, ExpiresAt: now.Add(sessionTTL)}
+	if err := a.store.CreateSession(token, sess); err != nil {
+		return err
+	}
+	if err := a.store.PurgeSessions(); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// CurrentUser returns the signed-in user of the request, or nil
+func (a *AuthService) CurrentUser(r *http.Request) (*models.User, error) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	sess, err := a.store.GetSession(cookie.Value)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.store.GetUser(sess.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// EndSession signs the browser out
+func (a *AuthService) EndSession(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+		if err := a.store.DeleteSession(cookie.Value); err != nil {
+			return err
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// isHTTPS reports whether the browser reached us over HTTPS, directly or
+// through a TLS-terminating proxy
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// CheckPassword reports whether the password matches the user's hash
+func CheckPassword(user *models.User, password string) bool {
+	return user.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
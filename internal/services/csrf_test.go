@@ -0,0 +1,74 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"formfling/internal/config"
+)
+
+func TestCSRFService(t *testing.T) {
+	cs := NewCSRFService(&config.Config{CSRFSecret: "secret"})
+
+	rr := httptest.NewRecorder()
+	token, err := cs.Issue(rr, httptest.NewRequest("GET", "/f/contact", nil), "contact")
+	if err != nil {
+		t.Fatalf("Issue returned error: %v", err)
+	}
+	var cookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == CSRFCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatal("Expected an HttpOnly CSRF cookie")
+	}
+
+	// A browser that has the cookie keeps it
+	again := httptest.NewRequest("GET", "/f/contact", nil)
+	again.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	if _, err := cs.Issue(rr, again, "contact"); err != nil || len(rr.Result().Cookies()) != 0 {
+		t.Errorf("Expected the cookie to be reused, got %v (%v)", rr.Result().Cookies(), err)
+	}
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired += "." + cs.sign("contact", cookie.Value, expired)
+	other := NewCSRFService(&config.Config{CSRFSecret: "other"})
+	otherToken, _ := other.Issue(httptest.NewRecorder(), again, "contact")
+
+	tests := []struct {
+		name    string
+		cs      *CSRFService
+		slug    string
+		cookie  *http.Cookie
+		token   string
+		wantErr bool
+	}{
+		{"valid", cs, "contact", cookie, token, false},
+		{"another form", cs, "support", cookie, token, true},
+		{"no cookie", cs, "contact", nil, token, true},
+		{"another browser", cs, "contact", &http.Cookie{Name: CSRFCookieName, Value: "other"}, token, true},
+		{"malformed", cs, "contact", cookie, "token", true},
+		{"tampered expiry", cs, "contact", cookie, "9999999999" + token[len(token)-44:], true},
+		{"expired", cs, "contact", cookie, expired, true},
+		{"signed with another secret", cs, "contact", cookie, otherToken, true},
+		{"not configured", nil, "contact", cookie, token, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/f/contact", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			err := tt.cs.Verify(req, tt.slug, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
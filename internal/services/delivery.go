@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"formfling/internal/metrics"
+	"formfling/internal/models"
+	"formfling/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ChannelEmail is the delivery channel of SMTP notifications
+const ChannelEmail = "email"
+
+// inFlight counts the deliveries being sent
+var inFlight atomic.Int64
+
+// DeliveriesInFlight is the number of deliveries being sent right now.
+// Deliveries run within their requests, so this is the delivery backlog.
+func DeliveriesInFlight() int {
+	return int(inFlight.Load())
+}
+
+// DeliverEmail sends the notification for a submission and records the
+// outcome on its email delivery record
+func DeliverEmail(ctx context.Context, sender EmailSender, sub *models.Submission) error {
+	delivery := sub.Delivery(ChannelEmail)
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now()
+
+	ctx, span := tracing.Start(ctx, "email.deliver",
+		attribute.String("formfling.form", sub.Form),
+		attribute.Int("formfling.delivery.attempt", delivery.Attempts),
+	)
+	done := metrics.DeliveryStarted(ChannelEmail, delivery.Attempts)
+	inFlight.Add(1)
+	err := sender.SendEmail(ctx, sub.FormData(), sub.Origin, sub.Options)
+	inFlight.Add(-1)
+	done(err)
+	tracing.End(span, err)
+	if err != nil {
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+		return err
+	}
+
+	delivery.Status = models.DeliverySent
+	delivery.LastError = ""
+	return nil
+}
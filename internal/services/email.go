@@ -2,33 +2,84 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"log/slog"
+	"mime/quotedprintable"
+	"net"
 	"net/smtp"
+	"strings"
+	texttemplate "text/template"
 	"time"
 
 	"formfling/internal/config"
+	"formfling/internal/metrics"
 	"formfling/internal/models"
+	"formfling/internal/tracing"
+	"formfling/internal/utils"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// plainTextTemplate renders the notification when a form asks for _format=plain
+var plainTextTemplate = texttemplate.Must(texttemplate.New("plain").Parse(`New form submission
+
+{{with .FormData.Name}}Name: {{.}}
+{{end}}{{with .FormData.Email}}Email: {{.}}
+{{end}}{{with .FormData.Phone}}Phone: {{.}}
+{{end}}{{with .FormData.Website}}Website: {{.}}
+{{end}}{{range .FormData.Extra}}{{if .Value}}{{.Label}}: {{.Value}}
+{{end}}{{end}}{{with .FormData.Subject}}Subject: {{.}}
+{{end}}
+{{.FormData.Message}}
+
+Submitted {{.SubmittedDate}} at {{.SubmittedTime}}{{with .Origin}} from {{.}}{{end}}
+`))
+
+// smtpTimeout bounds a delivery to the SMTP server, from connecting to the
+// end of the message
+var smtpTimeout = 2 * time.Minute
+
 type EmailService struct {
 	config        *config.Config
 	emailTemplate *template.Template
+	transport     MailTransport
 }
 
 func NewEmailService(cfg *config.Config) *EmailService {
+	emailService, err := LoadEmailService(cfg)
+	if err != nil {
+		log.Fatal("Error loading email template:", err)
+	}
+	return emailService
+}
+
+// LoadEmailService is NewEmailService for callers that report a broken
+// email template themselves
+func LoadEmailService(cfg *config.Config) (*EmailService, error) {
 	// Load email template
 	emailTemplate, err := template.ParseFiles(cfg.EmailTemplate)
 	if err != nil {
-		log.Fatal("Error loading email template:", err)
+		return nil, err
 	}
 
 	return &EmailService{
 		config:        cfg,
 		emailTemplate: emailTemplate,
-	}
+	}, nil
+}
+
+// SetTransport hands messages to the transport instead of the SMTP server,
+// as the mail catcher of development mode does
+func (s *EmailService) SetTransport(transport MailTransport) {
+	s.transport = transport
 }
 
 // getLocalTime returns the current time in the timezone set by TZ environment variable
@@ -39,14 +90,160 @@ func (s *EmailService) getLocalTime(cfg *config.Config) time.Time {
 	if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
 		return now.In(loc)
 	} else {
-		log.Printf("Warning: Failed to load timezone '%s': %v. Using system local time.", cfg.Timezone, err)
+		slog.Warn("Failed to load timezone", "timezone", cfg.Timezone, "error", err)
 	}
 
 	// Fall back to system local time
 	return now
 }
 
-func (s *EmailService) SendEmail(formData models.FormData, origin string) error {
+func (s *EmailService) SendEmail(ctx context.Context, formData models.FormData, origin string, opts models.EmailOptions) error {
+	opts = s.withDefaults(opts)
+
+	now := s.getLocalTime(s.config)
+	var emailBody bytes.Buffer
+	contentType, err := s.RenderEmail(&emailBody, formData, origin, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.MessageID == "" {
+		opts.MessageID = NewMessageID(s.config.FromEmail)
+	}
+
+	// Create message
+	mime := fmt.Sprintf("MIME-Version: 1.0\r\nContent-Type: %s; charset=\"UTF-8\"\r\n\r\n", contentType)
+
+	msg := fmt.Sprintf("From: %s <%s>\r\n", utils.EncodeHeader(s.config.FromName), s.config.FromEmail)
+	msg += fmt.Sprintf("To: %s <%s>\r\n", utils.EncodeHeader(opts.ToName), opts.ToEmail)
+	if len(opts.CC) > 0 {
+		msg += fmt.Sprintf("Cc: %s\r\n", strings.Join(opts.CC, ", "))
+	}
+	if opts.ReplyTo != "" {
+		msg += fmt.Sprintf("Reply-To: %s\r\n", utils.StripHeaderBreaks(opts.ReplyTo))
+	}
+	msg += fmt.Sprintf("Subject: %s\r\n", utils.EncodeHeader(opts.Subject))
+	msg += fmt.Sprintf("Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg += fmt.Sprintf("Message-ID: %s\r\n", utils.StripHeaderBreaks(opts.MessageID))
+	msg += mime
+	msg += emailBody.String()
+
+	recipients := append([]string{opts.ToEmail}, opts.CC...)
+	return s.send(ctx, recipients, msg)
+}
+
+// SendReply emails a plain text reply to a submitter, threaded under the
+// earlier messages of the conversation
+func (s *EmailService) SendReply(ctx context.Context, reply models.Reply) error {
+	var body bytes.Buffer
+	qp := quotedprintable.NewWriter(&body)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(reply.Body, "\r\n", "\n"))); err != nil {
+		return fmt.Errorf("error encoding reply: %v", err)
+	}
+	if err := qp.Close(); err != nil {
+		return fmt.Errorf("error encoding reply: %v", err)
+	}
+
+	msg := fmt.Sprintf("From: %s <%s>\r\n", utils.EncodeHeader(s.config.FromName), s.config.FromEmail)
+	msg += fmt.Sprintf("To: %s\r\n", utils.StripHeaderBreaks(reply.To))
+	if reply.ReplyTo != "" {
+		msg += fmt.Sprintf("Reply-To: %s\r\n", utils.StripHeaderBreaks(reply.ReplyTo))
+	}
+	msg += fmt.Sprintf("Subject: %s\r\n", utils.EncodeHeader(reply.Subject))
+	msg += fmt.Sprintf("Date: %s\r\n", s.getLocalTime(s.config).Format(time.RFC1123Z))
+	msg += fmt.Sprintf("Message-ID: %s\r\n", utils.StripHeaderBreaks(reply.MessageID))
+	if reply.InReplyTo != "" {
+		msg += fmt.Sprintf("In-Reply-To: %s\r\n", utils.StripHeaderBreaks(reply.InReplyTo))
+	}
+	if len(reply.References) > 0 {
+		msg += fmt.Sprintf("References: %s\r\n", utils.StripHeaderBreaks(strings.Join(reply.References, " ")))
+	}
+	msg += "MIME-Version: 1.0\r\n"
+	msg += "Content-Type: text/plain; charset=\"UTF-8\"\r\n"
+	msg += "Content-Transfer-Encoding: quoted-printable\r\n\r\n"
+	msg += strings.ReplaceAll(body.String(), "\n", "\r\n")
+
+	return s.send(ctx, []string{reply.To}, msg)
+}
+
+// NewMessageID returns a unique Message-ID in the domain of the sender
+func NewMessageID(fromEmail string) string {
+	domain := "formfling.local"
+	if at := strings.LastIndex(fromEmail, "@"); at >= 0 && at < len(fromEmail)-1 {
+		domain = fromEmail[at+1:]
+	}
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), hex.EncodeToString(raw), domain)
+}
+
+// send delivers a message over the configured SMTP server or transport
+func (s *EmailService) send(ctx context.Context, recipients []string, msg string) (err error) {
+	_, span := tracing.Start(ctx, "smtp.send", attribute.Int("formfling.email.recipients", len(recipients)))
+	defer func() {
+		if class := smtpErrorClass(err); class != "" {
+			span.SetAttributes(attribute.String("formfling.smtp.error_class", class))
+		}
+		tracing.End(span, err)
+	}()
+
+	if s.transport != nil {
+		return s.transport.Send(s.config.FromEmail, recipients, []byte(msg))
+	}
+	span.SetAttributes(
+		attribute.String("server.address", s.config.SMTPHost),
+		attribute.Int("server.port", s.config.SMTPPort),
+	)
+
+	start := time.Now()
+	// A visitor closing the page must not cut off the notification, but a
+	// server that stops answering must not hold the delivery forever
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), smtpTimeout)
+	defer cancel()
+	client, err := s.dial(ctx)
+	if err == nil {
+		err = s.sendEmailData(client, recipients, msg)
+		client.Quit()
+	}
+	metrics.SMTPSend(time.Since(start), smtpErrorClass(err))
+	return err
+}
+
+// smtpStepError is an SMTP failure with the step it happened in
+type smtpStepError struct {
+	step string
+	err  error
+}
+
+func (e *smtpStepError) Error() string { return e.err.Error() }
+func (e *smtpStepError) Unwrap() error { return e.err }
+
+// stepError describes a failed SMTP step the way the messages always have
+// and keeps the step for metrics
+func stepError(step, message string, err error) error {
+	return &smtpStepError{step: step, err: fmt.Errorf("%s: %w", message, err)}
+}
+
+// smtpErrorClass is the step an SMTP send failed in, or timeout. It is empty
+// for a successful send.
+func smtpErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var stepErr *smtpStepError
+	if errors.As(err, &stepErr) {
+		return stepErr.step
+	}
+	return "other"
+}
+
+// RenderEmail writes the body of the notification for a submission and
+// returns its content type
+func (s *EmailService) RenderEmail(w io.Writer, formData models.FormData, origin string, opts models.EmailOptions) (string, error) {
 	now := s.getLocalTime(s.config)
 	templateData := models.EmailTemplateData{
 		FormData:      formData,
@@ -55,111 +252,124 @@ func (s *EmailService) SendEmail(formData models.FormData, origin string) error
 		Origin:        origin,
 	}
 
-	var emailBody bytes.Buffer
-	if err := s.emailTemplate.Execute(&emailBody, templateData); err != nil {
-		return fmt.Errorf("error executing email template: %v", err)
+	if opts.PlainText {
+		if err := plainTextTemplate.Execute(w, templateData); err != nil {
+			return "", fmt.Errorf("error executing plain text template: %v", err)
+		}
+		return "text/plain", nil
 	}
+	if err := s.emailTemplate.Execute(w, templateData); err != nil {
+		return "", fmt.Errorf("error executing email template: %v", err)
+	}
+	return "text/html", nil
+}
 
-	// Set up authentication information
-	auth := smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
-
-	// Create message
-	subject := fmt.Sprintf("New submission from %s", s.config.FormTitle)
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+// withDefaults fills the unset options from the configuration
+func (s *EmailService) withDefaults(opts models.EmailOptions) models.EmailOptions {
+	if opts.FormTitle == "" {
+		opts.FormTitle = s.config.FormTitle
+	}
+	if opts.ToEmail == "" {
+		opts.ToEmail = s.config.ToEmail
+		opts.ToName = s.config.ToName
+	}
+	if opts.Subject == "" {
+		opts.Subject = fmt.Sprintf("New submission from %s", opts.FormTitle)
+	}
+	return opts
+}
 
-	msg := fmt.Sprintf("From: %s <%s>\r\n", s.config.FromName, s.config.FromEmail)
-	msg += fmt.Sprintf("To: %s <%s>\r\n", s.config.ToName, s.config.ToEmail)
-	msg += fmt.Sprintf("Subject: %s\r\n", subject)
-	msg += mime
-	msg += emailBody.String()
+// CheckConnection connects and signs in to the SMTP server without sending
+// anything. There is nothing to check when messages go to a transport, such
+// as the mail catcher of development mode.
+func (s *EmailService) CheckConnection(ctx context.Context) error {
+	if s.transport != nil {
+		return nil
+	}
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
 
-	// Connect to server and send email
+// dial connects and authenticates to the SMTP server: over TLS on port 465,
+// and with STARTTLS when the server offers it on other ports. The deadline
+// of ctx applies to the whole conversation.
+func (s *EmailService) dial(ctx context.Context) (*smtp.Client, error) {
 	addr := fmt.Sprintf("%s:%d", s.config.SMTPHost, s.config.SMTPPort)
+	tlsConfig := &tls.Config{
+		ServerName: s.config.SMTPHost,
+	}
 
-	// Handle different SMTP configurations
+	var dialer net.Dialer
+	var conn net.Conn
+	var err error
 	if s.config.SMTPPort == 465 {
 		// SSL/TLS connection for port 465
-		return s.sendEmailSSL(addr, auth, msg)
+		conn, err = (&tls.Dialer{NetDialer: &dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
 	} else {
 		// STARTTLS connection for port 587 (and others)
-		return s.sendEmailSTARTTLS(addr, auth, msg)
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
 	}
-}
-
-func (s *EmailService) sendEmailSSL(addr string, auth smtp.Auth, msg string) error {
-	conn, err := tls.Dial("tcp", addr, &tls.Config{
-		ServerName: s.config.SMTPHost,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to connect to SMTP server: %v", err)
-	}
-	defer conn.Close()
-
-	client, err := smtp.NewClient(conn, s.config.SMTPHost)
 	if err != nil {
-		return fmt.Errorf("failed to create SMTP client: %v", err)
+		return nil, stepError("connect", "failed to connect to SMTP server", err)
 	}
-	defer client.Quit()
-
-	// Authenticate
-	if err := client.Auth(auth); err != nil {
-		return fmt.Errorf("failed to authenticate: %v", err)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
 	}
 
-	// Send email
-	return s.sendEmailData(client, msg)
-}
-
-func (s *EmailService) sendEmailSTARTTLS(addr string, auth smtp.Auth, msg string) error {
-	conn, err := smtp.Dial(addr)
+	client, err := smtp.NewClient(conn, s.config.SMTPHost)
 	if err != nil {
-		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+		conn.Close()
+		return nil, stepError("connect", "failed to create SMTP client", err)
 	}
-	defer conn.Quit()
 
 	// Start TLS if available
-	if ok, _ := conn.Extension("STARTTLS"); ok {
-		tlsConfig := &tls.Config{
-			ServerName: s.config.SMTPHost,
-		}
-		if err := conn.StartTLS(tlsConfig); err != nil {
-			return fmt.Errorf("failed to start TLS: %v", err)
+	if s.config.SMTPPort != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, stepError("tls", "failed to start TLS", err)
+			}
 		}
 	}
 
 	// Authenticate
-	if err := conn.Auth(auth); err != nil {
-		return fmt.Errorf("failed to authenticate: %v", err)
+	auth := smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
+	if err := client.Auth(auth); err != nil {
+		client.Close()
+		return nil, stepError("auth", "failed to authenticate", err)
 	}
-
-	// Send email
-	return s.sendEmailData(conn, msg)
+	return client, nil
 }
 
-func (s *EmailService) sendEmailData(client *smtp.Client, msg string) error {
+func (s *EmailService) sendEmailData(client *smtp.Client, recipients []string, msg string) error {
 	// Set sender and recipient
 	if err := client.Mail(s.config.FromEmail); err != nil {
-		return fmt.Errorf("failed to set sender: %v", err)
+		return stepError("sender", "failed to set sender", err)
 	}
 
-	if err := client.Rcpt(s.config.ToEmail); err != nil {
-		return fmt.Errorf("failed to set recipient: %v", err)
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return stepError("recipient", "failed to set recipient", err)
+		}
 	}
 
 	// Send email body
 	writer, err := client.Data()
 	if err != nil {
-		return fmt.Errorf("failed to get data writer: %v", err)
+		return stepError("data", "failed to get data writer", err)
 	}
 
 	_, err = writer.Write([]byte(msg))
 	if err != nil {
-		return fmt.Errorf("failed to write email data: %v", err)
+		return stepError("data", "failed to write email data", err)
 	}
 
 	err = writer.Close()
 	if err != nil {
-		return fmt.Errorf("failed to close email writer: %v", err)
+		return stepError("data", "failed to close email writer", err)
 	}
 
 	return nil
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"formfling/internal/config"
+)
+
+func TestEmailService_SendTimeout(t *testing.T) {
+	// A server that accepts connections but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	previous := smtpTimeout
+	smtpTimeout = 200 * time.Millisecond
+	defer func() { smtpTimeout = previous }()
+
+	addr := l.Addr().(*net.TCPAddr)
+	s := &EmailService{config: &config.Config{SMTPHost: "127.0.0.1", SMTPPort: addr.Port, FromEmail: "forms@example.com"}}
+
+	start := time.Now()
+	err = s.send(context.Background(), []string{"owner@example.com"}, "Subject: Hi\r\n\r\nHello\r\n")
+	if err == nil {
+		t.Fatal("Expected the send to fail")
+	}
+	if class := smtpErrorClass(err); class != "timeout" {
+		t.Errorf("Expected a timeout, got %q: %v", class, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the send to give up after smtpTimeout, took %v", elapsed)
+	}
+}
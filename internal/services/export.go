@@ -0,0 +1,421 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/store"
+)
+
+// Export formats
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	ExportXLSX  = "xlsx"
+)
+
+// ExportFormats lists every export format
+var ExportFormats = []string{ExportCSV, ExportJSONL, ExportXLSX}
+
+// exportColumns come before the submitted fields in CSV and XLSX exports
+var exportColumns = []string{"id", "form", "created_at", "spam", "delivery", "origin", "status", "assignee", "tags"}
+
+// builtinColumns orders the built-in fields not named by a form definition
+var builtinColumns = []string{"name", "email", "subject", "phone", "website", "message"}
+
+// xlsxMaxCell is the longest text a spreadsheet cell can hold
+const xlsxMaxCell = 32767
+
+// ExportOptions controls the layout of an export
+type ExportOptions struct {
+	Format string
+	// Delimiter separates CSV columns, a comma when zero
+	Delimiter rune
+	// BOM starts CSV files with a byte order mark so Excel reads them as UTF-8
+	BOM bool
+	// Location is the time zone of the timestamps, UTC when nil
+	Location *time.Location
+	// BeforePage, when set, is called before each page of submissions is
+	// written, so a handler can extend its write deadline
+	BeforePage func()
+}
+
+func (o ExportOptions) beforePage() {
+	if o.BeforePage != nil {
+		o.BeforePage()
+	}
+}
+
+// exportPageSize is how many submissions are read per store transaction.
+// Each page is written after its transaction has ended, so a slow download
+// does not keep the database from reusing pages.
+var exportPageSize = 500
+
+// eachExportPage calls fn with the submissions matching q, newest first, a
+// page at a time
+func eachExportPage(s *store.Store, q store.Query, fn func([]*models.Submission) error) error {
+	q.Limit = exportPageSize
+	q.Cursor = ""
+	for {
+		page, next, err := s.ListSubmissions(q)
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		q.Cursor = next
+	}
+}
+
+// ValidExportFormat reports whether format is a known export format
+func ValidExportFormat(format string) bool {
+	for _, f := range ExportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDelimiter reads a CSV delimiter given as a character or by name
+func ParseDelimiter(value string) (rune, error) {
+	switch value {
+	case "", ",", "comma":
+		return ',', nil
+	case ";", "semicolon":
+		return ';', nil
+	case "\t", "tab":
+		return '\t', nil
+	case "|", "pipe":
+		return '|', nil
+	}
+	return 0, fmt.Errorf("delimiter must be comma, semicolon, tab or pipe")
+}
+
+// ExportContentType returns the MIME type of an export format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "application/x-ndjson"
+}
+
+// ExportLocation returns the configured time zone, UTC when it is unknown
+func ExportLocation(cfg *config.Config) *time.Location {
+	if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// ExportFileName names an export file after the day it was made
+func ExportFileName(format string, t time.Time) string {
+	return "submissions-" + t.Format("2006-01-02") + "." + format
+}
+
+// ExportSubmissions writes every submission matching the query, newest first,
+// holding one page of them in memory at a time. CSV and XLSX get one column per field: those
+// defined by the forms in order, then other built-in fields, then any other
+// submitted fields by name. Finding those takes a first pass over the store.
+func ExportSubmissions(w io.Writer, s *store.Store, q store.Query, forms []*config.Form, opts ExportOptions) error {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.Format == ExportJSONL {
+		encoder := json.NewEncoder(w)
+		return eachExportPage(s, q, func(page []*models.Submission) error {
+			opts.beforePage()
+			for _, sub := range page {
+				if err := encoder.Encode(sub); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	fields, err := exportFields(s, q, forms)
+	if err != nil {
+		return err
+	}
+
+	var rows exportWriter
+	switch opts.Format {
+	case ExportCSV:
+		rows = newCSVExport(w, opts)
+	case ExportXLSX:
+		if rows, err = newXLSXExport(w, opts); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	opts.beforePage()
+	if err := rows.WriteRow(stringCells(append(append([]string{}, exportColumns...), fields...))); err != nil {
+		return err
+	}
+	err = eachExportPage(s, q, func(page []*models.Submission) error {
+		opts.beforePage()
+		for _, sub := range page {
+			if err := rows.WriteRow(exportRow(sub, fields)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	opts.beforePage()
+	return rows.Close()
+}
+
+// exportFields returns the field columns in a stable order
+func exportFields(s *store.Store, q store.Query, forms []*config.Form) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			fields = append(fields, name)
+		}
+	}
+
+	for _, form := range forms {
+		if q.Form != "" && form.Slug != q.Form {
+			continue
+		}
+		for _, field := range form.FieldDefinitions() {
+			add(field.Name)
+		}
+	}
+
+	used := make(map[string]bool)
+	err := eachExportPage(s, q, func(page []*models.Submission) error {
+		for _, sub := range page {
+			data := sub.FormData()
+			for _, name := range builtinColumns {
+				if data.Value(name) != "" {
+					used[name] = true
+				}
+			}
+			for _, extra := range sub.Extra {
+				used[extra.Name] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range builtinColumns {
+		if used[name] {
+			add(name)
+		}
+	}
+	var others []string
+	for name := range used {
+		if !seen[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		add(name)
+	}
+	return fields, nil
+}
+
+// exportRow lays out a submission in the export columns. Cells hold a string,
+// a time or a bool.
+func exportRow(sub *models.Submission, fields []string) []interface{} {
+	var deliveries []string
+	for _, d := range sub.Deliveries {
+		deliveries = append(deliveries, d.Channel+":"+d.Status)
+	}
+
+	row := []interface{}{sub.ID, sub.Form, sub.CreatedAt, sub.Spam, strings.Join(deliveries, " "), sub.Origin,
+		sub.Status, sub.Assignee, strings.Join(sub.Tags, ", ")}
+	data := sub.FormData()
+	for _, name := range fields {
+		row = append(row, data.Value(name))
+	}
+	return row
+}
+
+func stringCells(values []string) []interface{} {
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		cells[i] = v
+	}
+	return cells
+}
+
+// exportWriter writes the rows of a tabular export
+type exportWriter interface {
+	WriteRow(cells []interface{}) error
+	Close() error
+}
+
+type csvExport struct {
+	w        *csv.Writer
+	location *time.Location
+	record   []string
+}
+
+func newCSVExport(w io.Writer, opts ExportOptions) *csvExport {
+	if opts.BOM {
+		io.WriteString(w, "\ufeff")
+	}
+	cw := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		cw.Comma = opts.Delimiter
+	}
+	return &csvExport{w: cw, location: opts.Location}
+}
+
+func (e *csvExport) WriteRow(cells []interface{}) error {
+	e.record = e.record[:0]
+	for _, cell := range cells {
+		switch v := cell.(type) {
+		case time.Time:
+			e.record = append(e.record, v.In(e.location).Format(time.RFC3339))
+		case bool:
+			e.record = append(e.record, strconv.FormatBool(v))
+		case string:
+			e.record = append(e.record, csvSafe(v))
+		}
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvSafe keeps spreadsheets from running submitted values as formulas
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// xlsxExport writes a single-sheet workbook. The sheet is the last part of
+// the archive so rows go straight to the output as they come.
+type xlsxExport struct {
+	zip      *zip.Writer
+	sheet    *bufio.Writer
+	location *time.Location
+	header   bool
+}
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Submissions" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+	// Style 1 is the bold header, style 2 a date and time
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs></styleSheet>`},
+}
+
+const xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`
+
+const xlsxSheetEnd = `</sheetData></worksheet>`
+
+// xlsxEpoch is day zero of spreadsheet dates
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func newXLSXExport(w io.Writer, opts ExportOptions) (*xlsxExport, error) {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	}
+	for _, part := range xlsxParts {
+		f, err := create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	sheet.WriteString(xlsxSheetStart)
+	return &xlsxExport{zip: zw, sheet: sheet, location: opts.Location, header: true}, nil
+}
+
+func (e *xlsxExport) WriteRow(cells []interface{}) error {
+	e.sheet.WriteString("<row>")
+	for _, cell := range cells {
+		switch v := cell.(type) {
+		case time.Time:
+			local := v.In(e.location)
+			wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+			days := wall.Sub(xlsxEpoch).Hours() / 24
+			fmt.Fprintf(e.sheet, `<c s="2"><v>%s</v></c>`, strconv.FormatFloat(days, 'f', -1, 64))
+		case bool:
+			value := "0"
+			if v {
+				value = "1"
+			}
+			fmt.Fprintf(e.sheet, `<c t="b"><v>%s</v></c>`, value)
+		case string:
+			if e.header {
+				e.sheet.WriteString(`<c s="1" t="inlineStr"><is><t>`)
+			} else {
+				e.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			}
+			if utf8.RuneCountInString(v) > xlsxMaxCell {
+				v = string([]rune(v)[:xlsxMaxCell])
+			}
+			xml.EscapeText(e.sheet, []byte(v))
+			e.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	e.header = false
+	_, err := e.sheet.WriteString("</row>")
+	return err
+}
+
+func (e *xlsxExport) Close() error {
+	e.sheet.WriteString(xlsxSheetEnd)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"formfling/internal/models"
+	"formfling/internal/store"
+)
+
+func TestExportSubmissions_Pages(t *testing.T) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "formfling.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	previous := exportPageSize
+	exportPageSize = 2
+	defer func() { exportPageSize = previous }()
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		sub := &models.Submission{Form: "default", CreatedAt: base.Add(time.Duration(i) * time.Hour),
+			Data: models.FormData{Name: string(rune('A' + i))}}
+		if i == 4 {
+			sub.Extra = []models.ExtraField{{Name: "utm_source", Value: "newsletter"}}
+		}
+		if err := s.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		format string
+		pages  int
+	}{
+		// 3 pages of submissions, and the header and end of the file
+		{ExportCSV, 5},
+		{ExportJSONL, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			pages := 0
+			opts := ExportOptions{Format: tt.format, Delimiter: ',', BeforePage: func() { pages++ }}
+			if err := ExportSubmissions(&out, s, store.Query{}, nil, opts); err != nil {
+				t.Fatalf("ExportSubmissions returned error: %v", err)
+			}
+			if pages != tt.pages {
+				t.Errorf("Expected BeforePage to be called %d times, got %d", tt.pages, pages)
+			}
+
+			var names []string
+			if tt.format == ExportJSONL {
+				decoder := json.NewDecoder(&out)
+				for decoder.More() {
+					var sub models.Submission
+					if err := decoder.Decode(&sub); err != nil {
+						t.Fatalf("Invalid JSON line: %v", err)
+					}
+					names = append(names, sub.Data.Name)
+				}
+			} else {
+				records, err := csv.NewReader(&out).ReadAll()
+				if err != nil {
+					t.Fatalf("Invalid CSV: %v", err)
+				}
+				if header := records[0]; header[len(header)-1] != "utm_source" {
+					t.Errorf("Expected a column for a field found on the first page only, got %v", header)
+				}
+				for _, record := range records[1:] {
+					names = append(names, record[9])
+				}
+			}
+			if got := strings.Join(names, ""); got != "EDCBA" {
+				t.Errorf("Expected every submission once, newest first, got %q", got)
+			}
+		})
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"Jane", "Jane"},
+		{"=SUM(A1:A2)", "'=SUM(A1:A2)"},
+		{"+1 555 0100", "'+1 555 0100"},
+		{"-2+3", "'-2+3"},
+		{"@cmd", "'@cmd"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=b", "a=b"},
+		{" =1", " =1"},
+	}
+	for _, tt := range tests {
+		if got := csvSafe(tt.value); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXExport(t *testing.T) {
+	var out bytes.Buffer
+	rows, err := newXLSXExport(&out, ExportOptions{Location: time.FixedZone("UTC+6", 6*60*60)})
+	if err != nil {
+		t.Fatalf("newXLSXExport returned error: %v", err)
+	}
+	long := strings.Repeat("é", xlsxMaxCell+10)
+	for _, cells := range [][]interface{}{
+		stringCells([]string{"created_at", "spam", "message"}),
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true, `<b>"Tom & Jerry"</b>`},
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), false, long},
+	} {
+		if err := rows.WriteRow(cells); err != nil {
+			t.Fatalf("WriteRow returned error: %v", err)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("Invalid XLSX archive: %v", err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if !xmlWellFormed(parts[name]) {
+			t.Errorf("Expected %s to be well-formed XML, got %q", name, parts[name])
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Style  string `xml:"s,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatalf("Invalid sheet: %v", err)
+	}
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(sheet.Rows))
+	}
+	header, first, second := sheet.Rows[0].Cells, sheet.Rows[1].Cells, sheet.Rows[2].Cells
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"bold header", header[0].Style, "1"},
+		{"header text", header[2].Inline, "message"},
+		// 18:00 in the export's time zone, as a fraction of a day
+		{"date", first[0].Value, "45352.75"},
+		{"date style", first[0].Style, "2"},
+		{"boolean type", first[1].Type, "b"},
+		{"true", first[1].Value, "1"},
+		{"false", second[1].Value, "0"},
+		{"escaped text", first[2].Inline, `<b>"Tom & Jerry"</b>`},
+		{"plain text style", first[2].Style, ""},
+		{"truncated text", strconv.Itoa(utf8.RuneCountInString(second[2].Inline)), strconv.Itoa(xlsxMaxCell)},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func xmlWellFormed(data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return len(data) > 0
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
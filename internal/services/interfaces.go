@@ -1,11 +1,24 @@
 package services
 
-import "formfling/internal/models"
+import (
+	"context"
+
+	"formfling/internal/models"
+)
 
 // EmailSender defines the interface for sending emails
 type EmailSender interface {
-	SendEmail(formData models.FormData, origin string) error
+	SendEmail(ctx context.Context, formData models.FormData, origin string, opts models.EmailOptions) error
+	SendReply(ctx context.Context, reply models.Reply) error
+}
+
+// MailTransport delivers a complete message to its recipients
+type MailTransport interface {
+	Send(from string, recipients []string, msg []byte) error
 }
 
 // Ensure EmailService implements EmailSender
 var _ EmailSender = (*EmailService)(nil)
+
+// Ensure MailCatcher implements MailTransport
+var _ MailTransport = (*MailCatcher)(nil)
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// capturedHeaders are the headers kept on captured messages for assertions
+var capturedHeaders = []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-Id", "In-Reply-To", "References", "Content-Type"}
+
+// CapturedMail is a message kept by the mail catcher instead of being sent
+type CapturedMail struct {
+	ID         string            `json:"id"`
+	CapturedAt time.Time         `json:"captured_at"`
+	From       string            `json:"from"`
+	Recipients []string          `json:"recipients"`
+	Subject    string            `json:"subject"`
+	Headers    map[string]string `json:"headers"`
+	Text       string            `json:"text,omitempty"`
+	HTML       string            `json:"html,omitempty"`
+	Raw        string            `json:"raw"`
+}
+
+// MailCatcher replaces SMTP delivery in development. It keeps the most recent
+// messages in memory so they can be looked at in the browser or asserted on
+// by integration tests.
+type MailCatcher struct {
+	mu       sync.RWMutex
+	messages []*CapturedMail
+	limit    int
+	next     int
+}
+
+func NewMailCatcher(limit int) *MailCatcher {
+	return &MailCatcher{limit: limit}
+}
+
+// Send captures a message. It implements MailTransport.
+func (c *MailCatcher) Send(from string, recipients []string, msg []byte) error {
+	captured := &CapturedMail{
+		CapturedAt: time.Now(),
+		From:       from,
+		Recipients: recipients,
+		Headers:    make(map[string]string),
+		Raw:        string(msg),
+	}
+	if m, err := mail.ReadMessage(bytes.NewReader(msg)); err == nil {
+		for _, name := range capturedHeaders {
+			if value := m.Header.Get(name); value != "" {
+				captured.Headers[name] = value
+			}
+		}
+		captured.Subject, _ = new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+		captured.Text, captured.HTML = mailBodies(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next++
+	captured.ID = strconv.Itoa(c.next)
+	c.messages = append(c.messages, captured)
+	if c.limit > 0 && len(c.messages) > c.limit {
+		c.messages = c.messages[len(c.messages)-c.limit:]
+	}
+	return nil
+}
+
+// Messages returns the captured messages, newest first
+func (c *MailCatcher) Messages() []*CapturedMail {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	messages := make([]*CapturedMail, 0, len(c.messages))
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		messages = append(messages, c.messages[i])
+	}
+	return messages
+}
+
+// Message returns a captured message by its ID
+func (c *MailCatcher) Message(id string) *CapturedMail {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, m := range c.messages {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// Clear drops all captured messages
+func (c *MailCatcher) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
+
+// mailBodies returns the decoded plain text and HTML bodies of a message
+func mailBodies(contentType, encoding string, body io.Reader) (string, string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var text, html string
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			partText, partHTML := mailBodies(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if text == "" {
+				text = partText
+			}
+			if html == "" {
+				html = partHTML
+			}
+		}
+		return text, html
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, _ := io.ReadAll(body)
+	switch mediaType {
+	case "text/plain":
+		return string(data), ""
+	case "text/html":
+		return "", string(data)
+	}
+	return "", ""
+}
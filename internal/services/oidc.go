@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/store"
+)
+
+const (
+	// OIDCStateCookieName binds a sign-in attempt to the browser that started it
+	OIDCStateCookieName = "formfling_oidc"
+
+	oidcLoginTTL = 10 * time.Minute
+	// maxPendingLogins bounds the sign-ins started but not finished; past
+	// it the oldest is dropped
+	maxPendingLogins = 1000
+)
+
+var (
+	// ErrOIDCDenied is returned when the identity provider vouches for a user
+	// who is not mapped to any role
+	ErrOIDCDenied = errors.New("your account has no access to this dashboard")
+	// ErrOIDCAccountConflict is returned when the username belongs to a local
+	// password account
+	ErrOIDCAccountConflict = errors.New("a local account with this username already exists")
+)
+
+// pendingLogin holds what the callback needs to finish a sign-in
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// OIDCService signs admin users in through an OpenID Connect provider using
+// the authorization code flow with PKCE, and verifies provider-issued bearer
+// tokens for the API
+type OIDCService struct {
+	config      *config.Config
+	store       *store.Store
+	roleMapping map[string]string
+
+	// client is used for discovery, key sets and the token exchange
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	pending  map[string]pendingLogin
+}
+
+// NewOIDCService creates a new single sign-on service. Discovery happens on
+// first use so an unreachable provider does not stop the server from starting.
+func NewOIDCService(cfg *config.Config, submissionStore *store.Store) (*OIDCService, error) {
+	roleMapping := make(map[string]string)
+	for _, entry := range cfg.OIDCRoleMapping {
+		value, role, ok := strings.Cut(entry, "=")
+		if !ok || !models.ValidRole(role) {
+			return nil, fmt.Errorf("OIDC_ROLE_MAPPING entry %q must be claim-value=owner|editor|viewer", entry)
+		}
+		roleMapping[value] = role
+	}
+	if cfg.OIDCDefaultRole != "" && !models.ValidRole(cfg.OIDCDefaultRole) {
+		return nil, fmt.Errorf("OIDC_DEFAULT_ROLE %q must be owner, editor or viewer", cfg.OIDCDefaultRole)
+	}
+	if cfg.OIDCIssuer != "" && (cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "") {
+		return nil, fmt.Errorf("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	if cfg.OIDCIssuer != "" && cfg.StorePath == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER requires STORE_PATH")
+	}
+
+	return &OIDCService{
+		config:      cfg,
+		store:       submissionStore,
+		roleMapping: roleMapping,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		pending:     make(map[string]pendingLogin),
+	}, nil
+}
+
+// Enabled reports whether single sign-on is configured
+func (o *OIDCService) Enabled() bool {
+	return o != nil && o.config.OIDCIssuer != ""
+}
+
+// SetHTTPClient replaces the client used to reach the provider
+func (o *OIDCService) SetHTTPClient(client *http.Client) {
+	o.client = client
+}
+
+func (o *OIDCService) context(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, o.client)
+}
+
+// discover fetches the provider metadata once
+func (o *OIDCService) discover(ctx context.Context) (*oidc.Provider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	provider, err := oidc.NewProvider(o.context(ctx), o.config.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	o.provider = provider
+	return provider, nil
+}
+
+func (o *OIDCService) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.config.OIDCClientID,
+		ClientSecret: o.config.OIDCClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  o.config.OIDCRedirectURL,
+		Scopes:       o.config.OIDCScopes,
+	}
+}
+
+// AuthURL starts a sign-in and returns the provider URL to send the browser
+// to. next is where the browser returns after signing in.
+func (o *OIDCService) AuthURL(w http.ResponseWriter, r *http.Request, next string) (string, error) {
+	provider, err := o.discover(r.Context())
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	o.addPending(state, pendingLogin{nonce: nonce, verifier: verifier, next: next, expires: time.Now().Add(oidcLoginTTL)})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    state,
+		Path:     "/admin/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		// Lax so the cookie comes back on the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return o.oauth2Config(provider).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)), nil
+}
+
+// addPending remembers a sign-in until its callback, dropping expired ones
+// and, when there are too many, the oldest
+func (o *OIDCService) addPending(state string, login pendingLogin) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	oldest := ""
+	for key, pending := range o.pending {
+		if now.After(pending.expires) {
+			delete(o.pending, key)
+		} else if oldest == "" || pending.expires.Before(o.pending[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(o.pending) >= maxPendingLogins {
+		delete(o.pending, oldest)
+	}
+	o.pending[state] = login
+}
+
+// Callback finishes a sign-in on the redirect from the provider. It returns
+// the signed-in user and the page to continue to.
+func (o *OIDCService) Callback(w http.ResponseWriter, r *http.Request) (*models.User, string, error) {
+	params := r.URL.Query()
+	if e := params.Get("error"); e != "" {
+		return nil, "", fmt.Errorf("provider returned %s: %s", e, params.Get("error_description"))
+	}
+
+	state := params.Get("state")
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil || state == "" || cookie.Value != state {
+		return nil, "", fmt.Errorf("sign-in state does not match this browser")
+	}
+	http.SetCookie(w, &http.Cookie{Name: OIDCStateCookieName, Path: "/admin/oidc", MaxAge: -1, HttpOnly: true, Secure: isHTTPS(r)})
+
+	o.mu.Lock()
+	login, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, "", fmt.Errorf("sign-in attempt expired")
+	}
+
+	provider, err := o.discover(r.Context())
+	if err != nil {
+		return nil, "", err
+	}
+	ctx := o.context(r.Context())
+	token, err := o.oauth2Config(provider).Exchange(ctx, params.Get("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("code exchange failed: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", fmt.Errorf("token response has no id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: o.config.OIDCClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ID token: %v", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return nil, "", fmt.Errorf("ID token nonce does not match")
+	}
+
+	user, err := o.userFromToken(idToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := o.saveUser(user); err != nil {
+		return nil, "", err
+	}
+	return user, login.next, nil
+}
+
+// VerifyBearer checks a provider-issued JWT sent to the API. Tokens must be
+// addressed to OIDC_API_AUDIENCE, or to the client ID when it is unset.
+func (o *OIDCService) VerifyBearer(ctx context.Context, raw string) (*models.User, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	audience := o.config.OIDCAPIAudience
+	if audience == "" {
+		audience = o.config.OIDCClientID
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: audience}).Verify(o.context(ctx), raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	return o.userFromToken(idToken)
+}
+
+// userFromToken maps the token claims to an account, keeping the form grants
+// of an existing account unless the provider sends them
+func (o *OIDCService) userFromToken(idToken *oidc.IDToken) (*models.User, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %v", err)
+	}
+
+	username := strings.ToLower(strings.TrimSpace(firstClaim(claims, o.config.OIDCUsernameClaim)))
+	if username == "" {
+		username = idToken.Subject
+	}
+	subject := idToken.Issuer + "#" + idToken.Subject
+
+	role := o.config.OIDCDefaultRole
+	for _, value := range claimValues(claims, o.config.OIDCRoleClaim) {
+		if mapped, ok := o.roleMapping[value]; ok && models.RoleRank(mapped) > models.RoleRank(role) {
+			role = mapped
+		}
+	}
+	if role == "" {
+		return nil, ErrOIDCDenied
+	}
+
+	user, err := o.store.GetUser(username)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		user = &models.User{Username: username, OIDCSubject: subject}
+	case err != nil:
+		return nil, err
+	case user.OIDCSubject != subject:
+		return nil, ErrOIDCAccountConflict
+	}
+
+	user.Role = role
+	if o.config.OIDCFormsClaim != "" {
+		user.Forms = claimValues(claims, o.config.OIDCFormsClaim)
+	}
+	return user, nil
+}
+
+// saveUser creates or refreshes the local record of a single sign-on account
+func (o *OIDCService) saveUser(user *models.User) error {
+	user.LastLoginAt = time.Now()
+	if user.CreatedAt.IsZero() {
+		return o.store.CreateUser(user)
+	}
+	return o.store.UpdateUser(user)
+}
+
+// claimValues reads a string or string list claim. Dotted names reach into
+// nested objects, such as realm_access.roles.
+func claimValues(claims map[string]interface{}, name string) []string {
+	if name == "" {
+		return nil
+	}
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func firstClaim(claims map[string]interface{}, name string) string {
+	if values := claimValues(claims, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
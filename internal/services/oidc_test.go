@@ -0,0 +1,36 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOIDCService_PendingLogins(t *testing.T) {
+	o := &OIDCService{pending: make(map[string]pendingLogin)}
+	now := time.Now()
+
+	o.addPending("expired", pendingLogin{expires: now.Add(-time.Second)})
+	for i := 0; i <= maxPendingLogins; i++ {
+		o.addPending(fmt.Sprintf("state%d", i), pendingLogin{expires: now.Add(oidcLoginTTL + time.Duration(i)*time.Millisecond)})
+	}
+
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{"expired", false},
+		// The oldest sign-in made room for the last one
+		{"state0", false},
+		{"state1", true},
+		{fmt.Sprintf("state%d", maxPendingLogins), true},
+	}
+	for _, tt := range tests {
+		if _, ok := o.pending[tt.state]; ok != tt.want {
+			t.Errorf("Expected %s to be pending: %v", tt.state, tt.want)
+		}
+	}
+	if len(o.pending) != maxPendingLogins {
+		t.Errorf("Expected %d pending sign-ins, got %d", maxPendingLogins, len(o.pending))
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"formfling/internal/config"
+)
+
+// powChallengeTTL is how long a proof-of-work challenge can be solved and used
+const powChallengeTTL = 10 * time.Minute
+
+// ProofOfWorkService issues and verifies hashcash-style challenges. A solution
+// is a nonce such that SHA-256("<challenge>:<nonce>") starts with the required
+// number of zero bits. Challenges are signed, so no state is kept until they
+// are redeemed.
+type ProofOfWorkService struct {
+	config *config.Config
+	secret []byte
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// PowChallenge is handed to clients before they submit a form
+type PowChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// NewProofOfWorkService creates a new proof-of-work service. Without a
+// configured secret a random one is generated, which invalidates outstanding
+// challenges on restart.
+func NewProofOfWorkService(cfg *config.Config) *ProofOfWorkService {
+	secret := []byte(cfg.PowSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Sprintf("failed to generate proof-of-work secret: %v", err))
+		}
+	}
+
+	return &ProofOfWorkService{
+		config: cfg,
+		secret: secret,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// Enabled reports whether submissions must carry a proof-of-work solution
+func (ps *ProofOfWorkService) Enabled() bool {
+	return ps != nil && ps.config.PowDifficulty > 0
+}
+
+// NewChallenge issues a fresh challenge for the configured difficulty
+func (ps *ProofOfWorkService) NewChallenge() (PowChallenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return PowChallenge{}, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+
+	expires := time.Now().Add(powChallengeTTL).Unix()
+	payload := fmt.Sprintf("%d.%s.%d", expires, hex.EncodeToString(nonce), ps.config.PowDifficulty)
+
+	return PowChallenge{
+		Challenge:  payload + "." + ps.sign(payload),
+		Difficulty: ps.config.PowDifficulty,
+	}, nil
+}
+
+// Verify checks a "<challenge>:<nonce>" solution. Each challenge is accepted
+// only once.
+func (ps *ProofOfWorkService) Verify(solution string) error {
+	if !ps.Enabled() {
+		return nil // proof-of-work is disabled, skip verification
+	}
+
+	sep := strings.LastIndex(solution, ":")
+	if sep < 0 {
+		return fmt.Errorf("proof-of-work solution is required")
+	}
+	challenge, nonce := solution[:sep], solution[sep+1:]
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return fmt.Errorf("malformed proof-of-work challenge")
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(ps.sign(payload))) {
+		return fmt.Errorf("invalid proof-of-work signature")
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return fmt.Errorf("proof-of-work challenge expired")
+	}
+
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil || difficulty < ps.config.PowDifficulty {
+		return fmt.Errorf("proof-of-work difficulty too low")
+	}
+
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	if leadingZeroBits(sum[:]) < difficulty {
+		return fmt.Errorf("proof-of-work solution does not meet difficulty %d", difficulty)
+	}
+
+	return ps.redeem(challenge, time.Unix(expires, 0))
+}
+
+// redeem records a challenge as used, rejecting replays
+func (ps *ProofOfWorkService) redeem(challenge string, expires time.Time) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+	for key, exp := range ps.used {
+		if now.After(exp) {
+			delete(ps.used, key)
+		}
+	}
+
+	if _, seen := ps.used[challenge]; seen {
+		return fmt.Errorf("proof-of-work challenge already used")
+	}
+	ps.used[challenge] = expires
+	return nil
+}
+
+func (ps *ProofOfWorkService) sign(payload string) string {
+	mac := hmac.New(sha256.New, ps.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// leadingZeroBits counts the zero bits at the start of a hash
+func leadingZeroBits(sum []byte) int {
+	count := 0
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
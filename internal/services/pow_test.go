@@ -0,0 +1,105 @@
+package services
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"formfling/internal/config"
+)
+
+// solve finds a nonce meeting the difficulty of a challenge
+func solve(t *testing.T, challenge string, difficulty int) string {
+	t.Helper()
+	for i := 0; i < 1<<20; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+		if leadingZeroBits(sum[:]) >= difficulty {
+			return challenge + ":" + nonce
+		}
+	}
+	t.Fatal("No solution found")
+	return ""
+}
+
+func TestProofOfWorkService_Verify(t *testing.T) {
+	ps := NewProofOfWorkService(&config.Config{PowDifficulty: 8, PowSecret: "secret"})
+	challenge := func(expires time.Time, difficulty int) string {
+		payload := fmt.Sprintf("%d.%s.%d", expires.Unix(), "00112233445566778899aabbccddeeff", difficulty)
+		return payload + "." + ps.sign(payload)
+	}
+
+	issued, err := ps.NewChallenge()
+	if err != nil {
+		t.Fatalf("NewChallenge returned error: %v", err)
+	}
+	if issued.Difficulty != 8 {
+		t.Errorf("Expected difficulty 8, got %d", issued.Difficulty)
+	}
+	valid := solve(t, issued.Challenge, 8)
+	// A nonce that does not meet the difficulty
+	unsolved := ""
+	for i := 0; unsolved == ""; i++ {
+		sum := sha256.Sum256([]byte(issued.Challenge + ":" + strconv.Itoa(i)))
+		if leadingZeroBits(sum[:]) < 8 {
+			unsolved = issued.Challenge + ":" + strconv.Itoa(i)
+		}
+	}
+	other := NewProofOfWorkService(&config.Config{PowDifficulty: 8, PowSecret: "other"})
+	foreign, _ := other.NewChallenge()
+
+	tests := []struct {
+		name     string
+		solution string
+		wantErr  bool
+	}{
+		{"unsolved", unsolved, true},
+		{"valid", valid, false},
+		{"replayed", valid, true},
+		{"missing", "", true},
+		{"malformed", "abc:1", true},
+		{"signed with another secret", solve(t, foreign.Challenge, 8), true},
+		{"expired", solve(t, challenge(time.Now().Add(-time.Minute), 8), 8), true},
+		{"lower difficulty", solve(t, challenge(time.Now().Add(time.Minute), 4), 4), true},
+		{"higher difficulty", solve(t, challenge(time.Now().Add(time.Minute), 10), 10), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ps.Verify(tt.solution)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestProofOfWorkService_Disabled(t *testing.T) {
+	var unset *ProofOfWorkService
+	for _, ps := range []*ProofOfWorkService{unset, NewProofOfWorkService(&config.Config{})} {
+		if ps.Enabled() {
+			t.Error("Expected proof-of-work to be disabled")
+		}
+		if err := ps.Verify(""); err != nil {
+			t.Errorf("Expected no solution to be needed, got %v", err)
+		}
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		sum  []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x40}, 9},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.sum); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.sum, got, tt.want)
+		}
+	}
+}
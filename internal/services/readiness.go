@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Results of readiness checks
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// readinessTimeout bounds each readiness check
+const readinessTimeout = 10 * time.Second
+
+// ReadinessCheck is a dependency /readyz looks at
+type ReadinessCheck struct {
+	Name string
+	// Critical checks make the instance not ready when they fail; others
+	// are reported as warnings
+	Critical bool
+	// Interval is how long a result is reused. Checks that cost a
+	// connection, such as signing in to the SMTP server, are cached so
+	// probes cannot turn into a stream of logins.
+	Interval time.Duration
+	// Check returns a short description of the state, or why it is bad
+	Check func(ctx context.Context) (string, error)
+}
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Status     string    `json:"status"`
+	Detail     string    `json:"detail,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// ReadinessReport is the answer of /readyz
+type ReadinessReport struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Readiness runs the readiness checks
+type Readiness struct {
+	checks []*readinessState
+}
+
+type readinessState struct {
+	ReadinessCheck
+	mu     sync.Mutex
+	result CheckResult
+}
+
+// NewReadiness creates a Readiness for checks
+func NewReadiness(checks ...ReadinessCheck) *Readiness {
+	r := &Readiness{}
+	for _, check := range checks {
+		r.checks = append(r.checks, &readinessState{ReadinessCheck: check})
+	}
+	return r
+}
+
+// Run runs the checks whose cached result is too old, all at once, and
+// reports whether every critical check passed
+func (r *Readiness) Run(ctx context.Context) ReadinessReport {
+	results := make([]CheckResult, len(r.checks))
+	var wg sync.WaitGroup
+	for i, state := range r.checks {
+		wg.Add(1)
+		go func(i int, state *readinessState) {
+			defer wg.Done()
+			results[i] = state.run(ctx)
+		}(i, state)
+	}
+	wg.Wait()
+
+	report := ReadinessReport{Ready: true, Checks: make(map[string]CheckResult)}
+	for i, state := range r.checks {
+		report.Checks[state.Name] = results[i]
+		if results[i].Status == CheckFail {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// run checks a dependency unless the last result is recent enough. Callers
+// arriving while a check runs wait for it and share its result.
+func (s *readinessState) run(ctx context.Context) CheckResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.result.CheckedAt.IsZero() && time.Since(s.result.CheckedAt) < s.Interval {
+		return s.result
+	}
+
+	// The result is shared, so a probe that gives up must not cut it short
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessTimeout)
+	defer cancel()
+	start := time.Now()
+	detail, err := s.Check(ctx)
+	result := CheckResult{
+		Status:     CheckOK,
+		Detail:     detail,
+		CheckedAt:  start,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = CheckWarn
+		if s.Critical {
+			result.Status = CheckFail
+		}
+		result.Error = err.Error()
+	}
+	s.result = result
+	return result
+}
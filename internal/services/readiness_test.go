@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadiness_Report(t *testing.T) {
+	ok := func(context.Context) (string, error) { return "fine", nil }
+	failing := func(context.Context) (string, error) { return "", errors.New("down") }
+
+	tests := []struct {
+		name       string
+		critical   bool
+		check      func(context.Context) (string, error)
+		wantStatus string
+		wantReady  bool
+	}{
+		{"passing critical check", true, ok, CheckOK, true},
+		{"failing critical check", true, failing, CheckFail, false},
+		{"failing check", false, failing, CheckWarn, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readiness := NewReadiness(
+				ReadinessCheck{Name: "other", Critical: true, Check: ok},
+				ReadinessCheck{Name: "tested", Critical: tt.critical, Check: tt.check},
+			)
+			report := readiness.Run(context.Background())
+			if report.Ready != tt.wantReady {
+				t.Errorf("Expected ready %v, got %v", tt.wantReady, report.Ready)
+			}
+			result := report.Checks["tested"]
+			if result.Status != tt.wantStatus {
+				t.Errorf("Expected status %s, got %+v", tt.wantStatus, result)
+			}
+			if tt.wantStatus != CheckOK && result.Error != "down" {
+				t.Errorf("Expected the error to be reported, got %+v", result)
+			}
+			if report.Checks["other"].Detail != "fine" {
+				t.Errorf("Expected every check to be reported, got %+v", report.Checks)
+			}
+		})
+	}
+}
+
+func TestReadiness_Caching(t *testing.T) {
+	var cached, uncached atomic.Int32
+	release := make(chan struct{})
+	readiness := NewReadiness(
+		ReadinessCheck{Name: "cached", Interval: time.Minute, Check: func(context.Context) (string, error) {
+			cached.Add(1)
+			<-release
+			return "", nil
+		}},
+		ReadinessCheck{Name: "uncached", Check: func(context.Context) (string, error) {
+			uncached.Add(1)
+			return "", nil
+		}},
+	)
+
+	// Probes arriving while a check runs share its result
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readiness.Run(context.Background())
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	readiness.Run(context.Background())
+
+	tests := []struct {
+		name string
+		got  int32
+		want int32
+	}{
+		{"cached check", cached.Load(), 1},
+		{"uncached check", uncached.Load(), 4},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("Expected the %s to run %d times, got %d", tt.name, tt.want, tt.got)
+		}
+	}
+
+	// Once the interval has passed the check runs again
+	state := readiness.checks[0]
+	state.mu.Lock()
+	state.result.CheckedAt = time.Now().Add(-2 * time.Minute)
+	state.mu.Unlock()
+	readiness.Run(context.Background())
+	if got := cached.Load(); got != 2 {
+		t.Errorf("Expected the cached check to run again after its interval, ran %d times", got)
+	}
+}
+
+func TestReadiness_ProbeCancelled(t *testing.T) {
+	readiness := NewReadiness(ReadinessCheck{Name: "smtp", Critical: true, Check: func(ctx context.Context) (string, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return "", errors.New("no deadline")
+		}
+		return "", ctx.Err()
+	}})
+
+	// A probe that gave up does not cut the shared check short
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report := readiness.Run(ctx); !report.Ready {
+		t.Errorf("Expected the check to run with its own deadline, got %+v", report.Checks["smtp"])
+	}
+}
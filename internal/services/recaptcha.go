@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,6 +11,10 @@ import (
 	"time"
 
 	"formfling/internal/config"
+	"formfling/internal/metrics"
+	"formfling/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // RecaptchaResponse represents the response from Google's reCAPTCHA verify API
@@ -33,17 +38,21 @@ func NewRecaptchaService(cfg *config.Config) *RecaptchaService {
 	return &RecaptchaService{
 		config: cfg,
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: tracing.Transport(http.DefaultTransport),
 		},
 	}
 }
 
 // VerifyToken verifies a reCAPTCHA v3 token with Google's API
-func (rs *RecaptchaService) VerifyToken(token, remoteIP string) error {
+func (rs *RecaptchaService) VerifyToken(ctx context.Context, token, remoteIP string) (err error) {
 	if !rs.config.RecaptchaEnabled {
 		return nil // reCAPTCHA is disabled, skip verification
 	}
 
+	ctx, span := tracing.Start(ctx, "recaptcha.verify")
+	defer func() { tracing.End(span, err) }()
+
 	if strings.TrimSpace(token) == "" {
 		return fmt.Errorf("reCAPTCHA token is required")
 	}
@@ -60,7 +69,12 @@ func (rs *RecaptchaService) VerifyToken(token, remoteIP string) error {
 	}
 
 	// Make request to Google's verify API
-	resp, err := rs.client.PostForm("https://www.google.com/recaptcha/api/siteverify", data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://www.google.com/recaptcha/api/siteverify", strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to verify reCAPTCHA: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := rs.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to verify reCAPTCHA: %v", err)
 	}
@@ -81,6 +95,17 @@ func (rs *RecaptchaService) VerifyToken(token, remoteIP string) error {
 		return fmt.Errorf(errorMsg)
 	}
 
+	// Actions are chosen by the page, so unexpected ones share a label
+	action := recaptchaResp.Action
+	if rs.config.RecaptchaAction != "" && action != rs.config.RecaptchaAction {
+		action = "other"
+	}
+	metrics.RecaptchaScore(action, recaptchaResp.Score)
+	span.SetAttributes(
+		attribute.String("formfling.recaptcha.action", recaptchaResp.Action),
+		attribute.Float64("formfling.recaptcha.score", recaptchaResp.Score),
+	)
+
 	// Check the score (v3 specific)
 	if recaptchaResp.Score < rs.config.RecaptchaMinScore {
 		return fmt.Errorf("reCAPTCHA score too low: %s (minimum: %s)",
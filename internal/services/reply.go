@@ -0,0 +1,111 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+)
+
+// placeholderPattern matches the {{ field }} placeholders of saved replies
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// RenderReply fills the {{ field }} placeholders of a saved reply from the
+// submission. Besides the submitted fields, {{ id }} and {{ form }} are
+// available. Unknown fields render empty.
+func RenderReply(text string, sub *models.Submission) string {
+	data := sub.FormData()
+	return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		switch name := placeholderPattern.FindStringSubmatch(match)[1]; name {
+		case "id":
+			return sub.ID
+		case "form":
+			return sub.Form
+		default:
+			return data.Value(name)
+		}
+	})
+}
+
+// ReplySubject returns the default subject of a reply to the submitter
+func ReplySubject(cfg *config.Config, sub *models.Submission) string {
+	subject := sub.Data.Subject
+	if subject == "" {
+		title := sub.Options.FormTitle
+		if title == "" {
+			title = cfg.FormTitle
+		}
+		subject = "Your message to " + title
+	}
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// NewReply addresses a reply to the submitter and threads it under the
+// notification and the earlier replies. Answers from the submitter go to the
+// form's notification address, or to the submission's reply address when
+// inbound mail is enabled.
+func NewReply(cfg *config.Config, sub *models.Submission, subject, body string) models.Reply {
+	reply := models.Reply{
+		To:         sub.ReplyAddress(),
+		ReplyTo:    sub.Options.ToEmail,
+		Subject:    subject,
+		Body:       body,
+		MessageID:  NewMessageID(cfg.FromEmail),
+		References: sub.ThreadIDs(),
+	}
+	if address := ReplyAddress(cfg, sub.ID); address != "" {
+		reply.ReplyTo = address
+	} else if reply.ReplyTo == "" {
+		reply.ReplyTo = cfg.ToEmail
+	}
+	if n := len(reply.References); n > 0 {
+		reply.InReplyTo = reply.References[n-1]
+	}
+	return reply
+}
+
+// replyAddressPrefix starts the local part of tokenized reply addresses
+const replyAddressPrefix = "reply+"
+
+// ReplyAddress returns the address in the inbound reply domain that threads
+// mail into the submission: reply+<id>.<signature>@<domain>. It is empty when
+// inbound mail is not enabled.
+func ReplyAddress(cfg *config.Config, id string) string {
+	if cfg.InboundAddr == "" || cfg.InboundDomain == "" || cfg.InboundSecret == "" {
+		return ""
+	}
+	return replyAddressPrefix + id + "." + replySignature(cfg, id) + "@" + strings.ToLower(cfg.InboundDomain)
+}
+
+// ParseReplyAddress returns the submission ID of a tokenized reply address,
+// or false if the address is not one or its signature does not match
+func ParseReplyAddress(cfg *config.Config, address string) (string, bool) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 || cfg.InboundSecret == "" || !strings.EqualFold(address[at+1:], cfg.InboundDomain) {
+		return "", false
+	}
+	local := strings.ToLower(address[:at])
+	if !strings.HasPrefix(local, replyAddressPrefix) {
+		return "", false
+	}
+	id, signature, ok := strings.Cut(strings.TrimPrefix(local, replyAddressPrefix), ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(replySignature(cfg, id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// replySignature keeps reply addresses from being guessed from a submission
+// ID
+func replySignature(cfg *config.Config, id string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.InboundSecret))
+	mac.Write([]byte("reply-address:" + id))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
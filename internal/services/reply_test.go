@@ -0,0 +1,57 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"formfling/internal/config"
+)
+
+func TestParseReplyAddress(t *testing.T) {
+	cfg := &config.Config{InboundAddr: ":2525", InboundDomain: "Reply.Example.com", InboundSecret: "secret"}
+	const id = "17b1d2c3e4f5a6b7deadbeef"
+	address := ReplyAddress(cfg, id)
+	if !strings.HasPrefix(address, "reply+"+id+".") || !strings.HasSuffix(address, "@reply.example.com") {
+		t.Fatalf("Unexpected reply address %q", address)
+	}
+	local, domain, _ := strings.Cut(address, "@")
+	other := ReplyAddress(&config.Config{InboundAddr: ":2525", InboundDomain: "reply.example.com", InboundSecret: "other"}, id)
+
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		address string
+		wantID  string
+		wantOK  bool
+	}{
+		{"valid", cfg, address, id, true},
+		{"upper case", cfg, strings.ToUpper(address), id, true},
+		{"another domain", cfg, local + "@example.com", "", false},
+		{"signed with another secret", cfg, other, "", false},
+		{"another submission", cfg, strings.Replace(address, id, "17b1d2c3e4f5a6b7deadbeee", 1), "", false},
+		{"no signature", cfg, "reply+" + id + "@" + domain, "", false},
+		{"no prefix", cfg, strings.TrimPrefix(address, "reply+"), "", false},
+		{"not an address", cfg, "reply.example.com", "", false},
+		{"no secret", &config.Config{InboundDomain: "reply.example.com"}, address, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotID, ok := ParseReplyAddress(tt.cfg, tt.address)
+			if gotID != tt.wantID || ok != tt.wantOK {
+				t.Errorf("Expected %q and %v, got %q and %v", tt.wantID, tt.wantOK, gotID, ok)
+			}
+		})
+	}
+}
+
+func TestReplyAddress_Disabled(t *testing.T) {
+	for _, cfg := range []*config.Config{
+		{InboundDomain: "reply.example.com", InboundSecret: "secret"},
+		{InboundAddr: ":2525", InboundSecret: "secret"},
+		{InboundAddr: ":2525", InboundDomain: "reply.example.com"},
+	} {
+		if address := ReplyAddress(cfg, "17b1d2c3e4f5a6b7deadbeef"); address != "" {
+			t.Errorf("Expected no reply address without inbound mail, got %q", address)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"formfling/internal/config"
+)
+
+// certificateCheckInterval is how often handshakes look for renewed
+// certificate files
+const certificateCheckInterval = time.Second
+
+// CertificateLoader serves a certificate and key from files and loads them
+// again when they change, so certificates renewed by certbot or similar
+// tools are picked up without a restart
+type CertificateLoader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	stamp   string
+	checked time.Time
+}
+
+// LoadCertificate loads a certificate and key, failing if they do not form
+// a pair
+func LoadCertificate(certFile, keyFile string) (*CertificateLoader, error) {
+	l := &CertificateLoader{certFile: certFile, keyFile: keyFile}
+	l.stamp = l.fileStamp()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+	l.cert = &cert
+	l.checked = time.Now()
+	return l, nil
+}
+
+// GetCertificate returns the current certificate. It implements
+// tls.Config.GetCertificate.
+func (l *CertificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.checked) >= certificateCheckInterval {
+		l.checked = time.Now()
+		if stamp := l.fileStamp(); stamp != l.stamp {
+			// A renewal may have written the certificate but not yet the
+			// key; the old pair is kept and the files are tried again on
+			// the next check
+			if cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile); err != nil {
+				slog.Error("Error reloading TLS certificate, keeping the current one", "error", err)
+			} else {
+				l.cert = &cert
+				l.stamp = stamp
+				slog.Info("Reloaded TLS certificate", "file", l.certFile)
+			}
+		}
+	}
+	return l.cert, nil
+}
+
+// fileStamp summarizes the size and modification time of the files
+func (l *CertificateLoader) fileStamp() string {
+	var stamp string
+	for _, file := range []string{l.certFile, l.keyFile} {
+		if info, err := os.Stat(file); err == nil {
+			stamp += fmt.Sprintf("%d %d;", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return stamp
+}
+
+// NewTLSConfig creates the TLS settings of the HTTP server. With
+// TLS_CLIENT_CA_FILE, client certificates signed by that CA are verified
+// when offered; RequireClientCert decides which routes need one.
+func NewTLSConfig(cfg *config.Config, certificates *CertificateLoader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     config.TLSVersions[cfg.TLSMinVersion],
+		GetCertificate: certificates.GetCertificate,
+	}
+	if cfg.TLSClientCAFile != "" {
+		data, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading TLS_CLIENT_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE %s holds no PEM certificates", cfg.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a new self-signed certificate with the serial
+// number and its key as PEM, skipping a file whose name is empty
+func writeCertificate(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "forms.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certFile != "" {
+		if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keyFile != "" {
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertificateLoader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1)
+
+	loader, err := LoadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadCertificate returned error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		write      func()
+		wantSerial int64
+	}{
+		{"unchanged files", func() {}, 1},
+		{"renewed pair", func() { writeCertificate(t, certFile, keyFile, 2) }, 2},
+		// A renewal that has written the certificate but not yet its key
+		{"half-written renewal", func() { writeCertificate(t, certFile, "", 3) }, 2},
+		{"renewal completed", func() { writeCertificate(t, certFile, keyFile, 4) }, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.write()
+			// Skip the wait for the next check
+			loader.mu.Lock()
+			loader.checked = time.Time{}
+			loader.mu.Unlock()
+
+			cert, err := loader.GetCertificate(&tls.ClientHelloInfo{})
+			if err != nil {
+				t.Fatalf("GetCertificate returned error: %v", err)
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.SerialNumber.Int64() != tt.wantSerial {
+				t.Errorf("Expected certificate %d, got %d", tt.wantSerial, leaf.SerialNumber.Int64())
+			}
+		})
+	}
+}
+
+func TestLoadCertificate_MismatchedPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1)
+	writeCertificate(t, "", keyFile, 2)
+
+	if _, err := LoadCertificate(certFile, keyFile); err == nil {
+		t.Error("Expected a certificate and key that do not match to be refused")
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The last six digits of the RFC 6238 SHA-1 test vectors
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode returned error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+	if _, err := TOTPCode("not base32!", time.Now()); err == nil {
+		t.Error("Expected an invalid secret to be refused")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+	code := func(at time.Time) string {
+		c, _ := TOTPCode(rfc6238Secret, at)
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current code", rfc6238Secret, "005924", step, true},
+		{"with spaces", rfc6238Secret, " 005 924 ", step, true},
+		{"lower-case secret", strings.ToLower(rfc6238Secret), "005924", step, true},
+		{"previous period", rfc6238Secret, code(now.Add(-totpPeriod * time.Second)), step - 1, true},
+		{"next period", rfc6238Secret, code(now.Add(totpPeriod * time.Second)), step + 1, true},
+		{"two periods old", rfc6238Secret, code(now.Add(-2 * totpPeriod * time.Second)), 0, false},
+		{"wrong code", rfc6238Secret, "123456", 0, false},
+		{"too short", rfc6238Secret, "00592", 0, false},
+		{"invalid secret", "not base32!", "005924", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := VerifyTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Expected step %d and %v, got %d and %v", tt.wantStep, tt.wantOK, gotStep, ok)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret returned error: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("Expected 160 bits in 32 base32 characters, got %q", secret)
+	}
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := VerifyTOTP(secret, code, now); !ok {
+		t.Error("Expected a code of a new secret to verify")
+	}
+
+	uri, err := url.Parse(TOTPURI("FormFling", "jane@example.com", secret))
+	if err != nil {
+		t.Fatalf("Invalid otpauth URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/FormFling:jane@example.com" ||
+		uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "FormFling" {
+		t.Errorf("Unexpected otpauth URI %s", uri)
+	}
+}
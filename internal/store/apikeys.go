@@ -0,0 +1,147 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"formfling/internal/models"
+)
+
+var apiKeysBucket = []byte("api_keys")
+
+// APIKeyPrefix marks FormFling API keys so they are easy to spot in leaks
+const APIKeyPrefix = "ff_"
+
+// hashToken returns the stored form of an API key or session token
+func hashToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey generates and stores a new key. The plain key is returned only
+// here and cannot be recovered later.
+func (s *Store) CreateAPIKey(name string, scopes []string) (*models.APIKey, string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	plain := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	key := &models.APIKey{
+		ID:        NewID(now),
+		Name:      name,
+		Prefix:    plain[:len(APIKeyPrefix)+6],
+		Hash:      hashToken(plain),
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if err := s.putAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	return key, plain, nil
+}
+
+func (s *Store) putAPIKey(key *models.APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode API key: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(key.Hash), data)
+	})
+}
+
+// AuthenticateAPIKey looks up a plain API key, recording when it was last
+// used
+func (s *Store) AuthenticateAPIKey(plain string) (*models.APIKey, error) {
+	hash := []byte(hashToken(plain))
+	key, err := s.getAPIKey(hash)
+	if err != nil {
+		return nil, err
+	}
+	// Avoid a write for every request
+	if time.Since(key.LastUsedAt) <= time.Minute {
+		return key, nil
+	}
+
+	// The key is looked up again in the transaction that records its use, so
+	// a key revoked in the meantime is refused rather than stored again
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(apiKeysBucket)
+		data := bucket.Get(hash)
+		if data == nil {
+			return ErrNotFound
+		}
+		key = &models.APIKey{}
+		if err := json.Unmarshal(data, key); err != nil {
+			return err
+		}
+		key.LastUsedAt = time.Now()
+		data, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("failed to encode API key: %v", err)
+		}
+		return bucket.Put(hash, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *Store) getAPIKey(hash []byte) (*models.APIKey, error) {
+	var key *models.APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(apiKeysBucket).Get(hash)
+		if data == nil {
+			return ErrNotFound
+		}
+		key = &models.APIKey{}
+		return json.Unmarshal(data, key)
+	})
+	return key, err
+}
+
+// ListAPIKeys returns all keys, newest first
+func (s *Store) ListAPIKeys() ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(k, v []byte) error {
+			key := &models.APIKey{}
+			if err := json.Unmarshal(v, key); err != nil {
+				return fmt.Errorf("failed to decode API key: %v", err)
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, err
+}
+
+// DeleteAPIKey revokes a key by its ID
+func (s *Store) DeleteAPIKey(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(apiKeysBucket)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var key models.APIKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("failed to decode API key: %v", err)
+			}
+			if key.ID == id {
+				return bucket.Delete(k)
+			}
+		}
+		return ErrNotFound
+	})
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"formfling/internal/models"
+)
+
+func TestStore_APIKeys(t *testing.T) {
+	s := openTestStore(t)
+
+	key, plain, err := s.CreateAPIKey("ci", []string{models.ScopeSubmissionsRead})
+	if err != nil {
+		t.Fatalf("CreateAPIKey returned error: %v", err)
+	}
+	if !strings.HasPrefix(plain, "ff_") || !strings.HasPrefix(plain, key.Prefix) {
+		t.Errorf("Unexpected key %q with prefix %q", plain, key.Prefix)
+	}
+	if key.Hash == plain || strings.Contains(key.Hash, plain) {
+		t.Error("Expected only a hash of the key to be stored")
+	}
+
+	got, err := s.AuthenticateAPIKey(plain)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey returned error: %v", err)
+	}
+	if got.ID != key.ID || !got.HasScope(models.ScopeSubmissionsRead) || got.HasScope(models.ScopeFormsWrite) {
+		t.Errorf("Unexpected key: %+v", got)
+	}
+	if got.LastUsedAt.IsZero() {
+		t.Error("Expected LastUsedAt to be recorded")
+	}
+
+	if _, err := s.AuthenticateAPIKey(plain + "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a wrong key, got %v", err)
+	}
+
+	keys, err := s.ListAPIKeys()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Expected 1 key, got %d (%v)", len(keys), err)
+	}
+
+	if err := s.DeleteAPIKey(key.ID); err != nil {
+		t.Fatalf("DeleteAPIKey returned error: %v", err)
+	}
+	if _, err := s.AuthenticateAPIKey(plain); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected revoked key to be rejected, got %v", err)
+	}
+	if err := s.DeleteAPIKey(key.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking twice, got %v", err)
+	}
+}
+
+func TestStore_APIKeyRevokedBetweenAuthentications(t *testing.T) {
+	s := openTestStore(t)
+
+	key, plain, err := s.CreateAPIKey("ci", []string{models.ScopeSubmissionsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(plain); err != nil {
+		t.Fatalf("AuthenticateAPIKey returned error: %v", err)
+	}
+
+	// Make the next authentication record its use again
+	key.LastUsedAt = time.Now().Add(-time.Hour)
+	if err := s.putAPIKey(key); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.AuthenticateAPIKey(plain)
+		}()
+	}
+	if err := s.DeleteAPIKey(key.ID); err != nil {
+		t.Fatalf("DeleteAPIKey returned error: %v", err)
+	}
+	wg.Wait()
+
+	if _, err := s.AuthenticateAPIKey(plain); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the revoked key to be rejected, got %v", err)
+	}
+	if keys, err := s.ListAPIKeys(); err != nil || len(keys) != 0 {
+		t.Errorf("Expected the revoked key to stay deleted, got %d keys (%v)", len(keys), err)
+	}
+}
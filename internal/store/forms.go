@@ -0,0 +1,50 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"formfling/internal/config"
+)
+
+var formsBucket = []byte("forms")
+
+// LoadForms returns all forms kept in the database, keyed by slug
+func (s *Store) LoadForms() (map[string]*config.Form, error) {
+	forms := make(map[string]*config.Form)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(formsBucket).ForEach(func(k, v []byte) error {
+			form := &config.Form{}
+			if err := json.Unmarshal(v, form); err != nil {
+				return fmt.Errorf("failed to decode form %s: %v", k, err)
+			}
+			forms[form.Slug] = form
+			return nil
+		})
+	})
+	return forms, err
+}
+
+// PutForm creates or replaces a form
+func (s *Store) PutForm(form *config.Form) error {
+	data, err := json.Marshal(form)
+	if err != nil {
+		return fmt.Errorf("failed to encode form: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(formsBucket).Put([]byte(form.Slug), data)
+	})
+}
+
+// DeleteForm removes a form. Its submissions are kept.
+func (s *Store) DeleteForm(slug string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(formsBucket)
+		if bucket.Get([]byte(slug)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(slug))
+	})
+}
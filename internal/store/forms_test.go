@@ -0,0 +1,34 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"formfling/internal/config"
+)
+
+func TestStore_Forms(t *testing.T) {
+	s := openTestStore(t)
+
+	if err := s.PutForm(&config.Form{Slug: "support", Title: "Support"}); err != nil {
+		t.Fatalf("PutForm returned error: %v", err)
+	}
+	if err := s.PutForm(&config.Form{Slug: "support", Title: "Help"}); err != nil {
+		t.Fatalf("PutForm returned error: %v", err)
+	}
+
+	forms, err := s.LoadForms()
+	if err != nil {
+		t.Fatalf("LoadForms returned error: %v", err)
+	}
+	if len(forms) != 1 || forms["support"].Title != "Help" {
+		t.Errorf("Unexpected forms: %+v", forms)
+	}
+
+	if err := s.DeleteForm("support"); err != nil {
+		t.Fatalf("DeleteForm returned error: %v", err)
+	}
+	if err := s.DeleteForm("support"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
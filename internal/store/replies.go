@@ -0,0 +1,82 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"formfling/internal/models"
+)
+
+var repliesBucket = []byte("reply_templates")
+
+// CreateReplyTemplate stores a new saved reply
+func (s *Store) CreateReplyTemplate(name, subject, body string) (*models.ReplyTemplate, error) {
+	now := time.Now()
+	tmpl := &models.ReplyTemplate{
+		ID:        NewID(now),
+		Name:      name,
+		Subject:   subject,
+		Body:      body,
+		CreatedAt: now,
+	}
+	data, err := json.Marshal(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode reply template: %v", err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(repliesBucket).Put([]byte(tmpl.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// GetReplyTemplate returns a saved reply by its ID
+func (s *Store) GetReplyTemplate(id string) (*models.ReplyTemplate, error) {
+	var tmpl *models.ReplyTemplate
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(repliesBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		tmpl = &models.ReplyTemplate{}
+		return json.Unmarshal(data, tmpl)
+	})
+	return tmpl, err
+}
+
+// ListReplyTemplates returns all saved replies sorted by name
+func (s *Store) ListReplyTemplates() ([]*models.ReplyTemplate, error) {
+	var templates []*models.ReplyTemplate
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(repliesBucket).ForEach(func(k, v []byte) error {
+			tmpl := &models.ReplyTemplate{}
+			if err := json.Unmarshal(v, tmpl); err != nil {
+				return fmt.Errorf("failed to decode reply template: %v", err)
+			}
+			templates = append(templates, tmpl)
+			return nil
+		})
+	})
+	sort.Slice(templates, func(i, j int) bool {
+		return strings.ToLower(templates[i].Name) < strings.ToLower(templates[j].Name)
+	})
+	return templates, err
+}
+
+// DeleteReplyTemplate removes a saved reply
+func (s *Store) DeleteReplyTemplate(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repliesBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
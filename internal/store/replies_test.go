@@ -0,0 +1,44 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStore_ReplyTemplates(t *testing.T) {
+	s := openTestStore(t)
+
+	thanks, err := s.CreateReplyTemplate("Thanks", "Re: {{ subject }}", "Hi {{ name }}, thanks!")
+	if err != nil {
+		t.Fatalf("CreateReplyTemplate returned error: %v", err)
+	}
+	if _, err := s.CreateReplyTemplate("already fixed", "Fixed", "This is fixed."); err != nil {
+		t.Fatalf("CreateReplyTemplate returned error: %v", err)
+	}
+
+	got, err := s.GetReplyTemplate(thanks.ID)
+	if err != nil {
+		t.Fatalf("GetReplyTemplate returned error: %v", err)
+	}
+	if got.Name != "Thanks" || got.Subject != "Re: {{ subject }}" || got.Body != "Hi {{ name }}, thanks!" {
+		t.Errorf("Unexpected template: %+v", got)
+	}
+
+	templates, err := s.ListReplyTemplates()
+	if err != nil {
+		t.Fatalf("ListReplyTemplates returned error: %v", err)
+	}
+	if len(templates) != 2 || templates[0].Name != "already fixed" || templates[1].Name != "Thanks" {
+		t.Errorf("Expected templates sorted by name, got %+v", templates)
+	}
+
+	if err := s.DeleteReplyTemplate(thanks.ID); err != nil {
+		t.Fatalf("DeleteReplyTemplate returned error: %v", err)
+	}
+	if _, err := s.GetReplyTemplate(thanks.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := s.DeleteReplyTemplate(thanks.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"formfling/internal/models"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("not found")
+
+var submissionsBucket = []byte("submissions")
+
+// messageIDsBucket maps the Message-IDs of email conversations to submissions
+var messageIDsBucket = []byte("message_ids")
+
+// Spam filter values for Query.Spam
+const (
+	SpamExclude = ""
+	SpamOnly    = "spam"
+	SpamAll     = "all"
+)
+
+// Query selects submissions. Results are ordered newest first.
+type Query struct {
+	Form string
+	// Forms restricts results to these forms when not nil
+	Forms  []string
+	Search string
+	Spam   string
+	Failed bool
+	// Status, Assignee and Tag filter on the ticket fields. Unassigned
+	// selects submissions nobody handles.
+	Status     string
+	Assignee   string
+	Unassigned bool
+	Tag        string
+	Since      time.Time
+	Until      time.Time
+	// Cursor is the ID of the last submission of the previous page
+	Cursor string
+	Limit  int
+}
+
+// Store persists submissions in a single bbolt database file
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database at path
+func Open(path string) (*Store, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %v", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{submissionsBucket, apiKeysBucket, formsBucket, usersBucket, sessionsBucket, repliesBucket, messageIDsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database can be read
+func (s *Store) Ping() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(submissionsBucket) == nil {
+			return fmt.Errorf("submissions bucket missing")
+		}
+		return nil
+	})
+}
+
+// NewID returns a unique ID that sorts by creation time
+func NewID(t time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%016x%s", t.UnixNano(), hex.EncodeToString(suffix))
+}
+
+// CreateSubmission stores a new submission, assigning its ID if unset
+func (s *Store) CreateSubmission(sub *models.Submission) error {
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = time.Now()
+	}
+	if sub.ID == "" {
+		sub.ID = NewID(sub.CreatedAt)
+	}
+	if sub.Status == "" {
+		sub.Status = models.TicketOpen
+	}
+	return s.putSubmission(sub)
+}
+
+// UpdateSubmission replaces a stored submission
+func (s *Store) UpdateSubmission(sub *models.Submission) error {
+	return s.ModifySubmission(sub.ID, func(stored *models.Submission) error {
+		*stored = *sub
+		return nil
+	})
+}
+
+// ModifySubmission applies fn to a stored submission and saves the result in
+// one transaction, so concurrent changes to the same submission are not lost.
+// Nothing is saved if fn fails.
+func (s *Store) ModifySubmission(id string, fn func(*models.Submission) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(submissionsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		sub := &models.Submission{}
+		if err := decodeSubmission(data, sub); err != nil {
+			return err
+		}
+		if err := fn(sub); err != nil {
+			return err
+		}
+		return writeSubmission(tx, sub)
+	})
+}
+
+func (s *Store) putSubmission(sub *models.Submission) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return writeSubmission(tx, sub)
+	})
+}
+
+// writeSubmission writes a submission and indexes its email conversation
+func writeSubmission(tx *bolt.Tx, sub *models.Submission) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("failed to encode submission: %v", err)
+	}
+	index := tx.Bucket(messageIDsBucket)
+	for _, id := range sub.ThreadIDs() {
+		if err := index.Put([]byte(id), []byte(sub.ID)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(submissionsBucket).Put([]byte(sub.ID), data)
+}
+
+// FindSubmissionByMessageID returns the submission whose email conversation
+// contains the Message-ID
+func (s *Store) FindSubmissionByMessageID(messageID string) (*models.Submission, error) {
+	var sub *models.Submission
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(messageIDsBucket).Get([]byte(messageID))
+		if id == nil {
+			return ErrNotFound
+		}
+		data := tx.Bucket(submissionsBucket).Get(id)
+		if data == nil {
+			return ErrNotFound
+		}
+		sub = &models.Submission{}
+		return decodeSubmission(data, sub)
+	})
+	return sub, err
+}
+
+// GetSubmission loads a submission by ID
+func (s *Store) GetSubmission(id string) (*models.Submission, error) {
+	var sub *models.Submission
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(submissionsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		sub = &models.Submission{}
+		return decodeSubmission(data, sub)
+	})
+	return sub, err
+}
+
+// decodeSubmission reads a stored submission. Submissions stored before the
+// ticket workflow existed are open.
+func decodeSubmission(data []byte, sub *models.Submission) error {
+	if err := json.Unmarshal(data, sub); err != nil {
+		return err
+	}
+	if sub.Status == "" {
+		sub.Status = models.TicketOpen
+	}
+	return nil
+}
+
+// DeleteSubmission removes a submission
+func (s *Store) DeleteSubmission(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(submissionsBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var sub models.Submission
+		if err := decodeSubmission(data, &sub); err != nil {
+			return err
+		}
+		for _, messageID := range sub.ThreadIDs() {
+			if err := tx.Bucket(messageIDsBucket).Delete([]byte(messageID)); err != nil {
+				return err
+			}
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// EachSubmission calls fn for every submission matching the query, newest
+// first, without loading them all into memory. Returning false from fn stops
+// the iteration. The query limit is ignored.
+func (s *Store) EachSubmission(q Query, fn func(*models.Submission) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(submissionsBucket).Cursor()
+
+		var k, v []byte
+		if q.Cursor != "" {
+			k, v = c.Seek([]byte(q.Cursor))
+			if k == nil {
+				k, v = c.Last()
+			} else if string(k) >= q.Cursor {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Last()
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			var sub models.Submission
+			if err := decodeSubmission(v, &sub); err != nil {
+				return fmt.Errorf("failed to decode submission %s: %v", k, err)
+			}
+			if !q.Since.IsZero() && sub.CreatedAt.Before(q.Since) {
+				// IDs sort by creation time, so nothing older can match
+				break
+			}
+			if !q.matches(&sub) {
+				continue
+			}
+			if !fn(&sub) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// ListSubmissions returns a page of submissions and the cursor of the next
+// page, which is empty on the last page
+func (s *Store) ListSubmissions(q Query) ([]*models.Submission, string, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var subs []*models.Submission
+	more := false
+	err := s.EachSubmission(q, func(sub *models.Submission) bool {
+		if len(subs) == limit {
+			more = true
+			return false
+		}
+		subs = append(subs, sub)
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if more {
+		next = subs[len(subs)-1].ID
+	}
+	return subs, next, nil
+}
+
+func (q Query) matches(sub *models.Submission) bool {
+	if q.Form != "" && sub.Form != q.Form {
+		return false
+	}
+	if q.Forms != nil && !contains(q.Forms, sub.Form) {
+		return false
+	}
+	switch q.Spam {
+	case SpamExclude:
+		if sub.Spam {
+			return false
+		}
+	case SpamOnly:
+		if !sub.Spam {
+			return false
+		}
+	}
+	if q.Failed && !sub.Failed() {
+		return false
+	}
+	if q.Status != "" && sub.Status != q.Status {
+		return false
+	}
+	if q.Assignee != "" && sub.Assignee != q.Assignee {
+		return false
+	}
+	if q.Unassigned && sub.Assignee != "" {
+		return false
+	}
+	if q.Tag != "" && !sub.HasTag(q.Tag) {
+		return false
+	}
+	if !q.Until.IsZero() && !sub.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.Search != "" && !containsFold(sub, q.Search) {
+		return false
+	}
+	return true
+}
+
+func containsFold(sub *models.Submission, search string) bool {
+	search = strings.ToLower(search)
+	values := []string{sub.Data.Name, sub.Data.Email, sub.Data.Subject, sub.Data.Message, sub.Data.Phone, sub.Data.Website}
+	for _, extra := range sub.Extra {
+		values = append(values, extra.Value)
+	}
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), search) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
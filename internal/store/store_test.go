@@ -0,0 +1,224 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"formfling/internal/models"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "data", "formfling.db"))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStore_CRUD(t *testing.T) {
+	s := openTestStore(t)
+
+	if err := s.Ping(); err != nil {
+		t.Fatalf("Ping returned error: %v", err)
+	}
+
+	sub := &models.Submission{
+		Form:  "contact",
+		Data:  models.FormData{Name: "Jane", Email: "jane@example.com"},
+		Extra: []models.ExtraField{{Name: "company", Label: "Company", Value: "Acme"}},
+	}
+	if err := s.CreateSubmission(sub); err != nil {
+		t.Fatalf("CreateSubmission returned error: %v", err)
+	}
+	if sub.ID == "" || sub.CreatedAt.IsZero() || sub.Status != models.TicketOpen {
+		t.Fatal("Expected ID, CreatedAt and an open status to be assigned")
+	}
+
+	got, err := s.GetSubmission(sub.ID)
+	if err != nil {
+		t.Fatalf("GetSubmission returned error: %v", err)
+	}
+	if got.Data.Name != "Jane" || got.FormData().Value("company") != "Acme" {
+		t.Errorf("Unexpected submission: %+v", got)
+	}
+
+	got.Spam = true
+	if err := s.UpdateSubmission(got); err != nil {
+		t.Fatalf("UpdateSubmission returned error: %v", err)
+	}
+	if got, _ := s.GetSubmission(sub.ID); !got.Spam {
+		t.Error("Expected update to be persisted")
+	}
+
+	if err := s.DeleteSubmission(sub.ID); err != nil {
+		t.Fatalf("DeleteSubmission returned error: %v", err)
+	}
+	if _, err := s.GetSubmission(sub.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := s.DeleteSubmission(sub.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+	if err := s.UpdateSubmission(sub); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound updating a deleted submission, got %v", err)
+	}
+}
+
+func TestStore_ModifySubmission(t *testing.T) {
+	s := openTestStore(t)
+
+	sub := &models.Submission{Form: "contact"}
+	if err := s.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each change applies on top of the last one saved
+	for _, change := range []func(*models.Submission) error{
+		func(sub *models.Submission) error {
+			sub.SetStatus("alice", models.TicketClosed)
+			return nil
+		},
+		func(sub *models.Submission) error {
+			sub.AddNote("bob", "Called them back")
+			return nil
+		},
+	} {
+		if err := s.ModifySubmission(sub.ID, change); err != nil {
+			t.Fatalf("ModifySubmission returned error: %v", err)
+		}
+	}
+	got, _ := s.GetSubmission(sub.ID)
+	if got.Status != models.TicketClosed || len(got.Events) != 2 {
+		t.Errorf("Expected both changes to be kept, got status %q and %d events", got.Status, len(got.Events))
+	}
+
+	failed := errors.New("refused")
+	err := s.ModifySubmission(sub.ID, func(sub *models.Submission) error {
+		sub.SetSpam("alice", true)
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("Expected the error of the change, got %v", err)
+	}
+	if got, _ := s.GetSubmission(sub.ID); got.Spam {
+		t.Error("Expected a failed change not to be saved")
+	}
+
+	if err := s.ModifySubmission("missing", func(*models.Submission) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown submission, got %v", err)
+	}
+}
+
+func TestStore_FindSubmissionByMessageID(t *testing.T) {
+	s := openTestStore(t)
+
+	sub := &models.Submission{Form: "contact", Options: models.EmailOptions{MessageID: "<notification@example.com>"}}
+	if err := s.CreateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+	sub.AddReply("admin", models.Reply{To: "jane@example.com", MessageID: "<reply@example.com>"})
+	if err := s.UpdateSubmission(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"<notification@example.com>", "<reply@example.com>"} {
+		got, err := s.FindSubmissionByMessageID(id)
+		if err != nil || got.ID != sub.ID {
+			t.Errorf("Expected %s to find the submission, got %v", id, err)
+		}
+	}
+	if _, err := s.FindSubmissionByMessageID("<other@example.com>"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown Message-ID, got %v", err)
+	}
+
+	if err := s.DeleteSubmission(sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FindSubmissionByMessageID("<reply@example.com>"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestStore_ListSubmissions(t *testing.T) {
+	s := openTestStore(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 7; i++ {
+		sub := &models.Submission{
+			Form:      "contact",
+			CreatedAt: start.Add(time.Duration(i) * time.Hour),
+			Data:      models.FormData{Name: "Person", Message: "hello"},
+		}
+		if i%2 == 1 {
+			sub.Form = "sales"
+		}
+		if i == 3 {
+			sub.Spam = true
+		}
+		if i == 4 {
+			sub.Data.Message = "Looking for a QUOTE"
+			sub.Deliveries = []models.Delivery{{Channel: "email", Status: models.DeliveryFailed}}
+		}
+		if i == 5 {
+			sub.SetStatus("alice", models.TicketClosed)
+			sub.SetAssignee("alice", "alice")
+			sub.SetTags("alice", []string{"Billing", "vip"})
+		}
+		if err := s.CreateSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pages are newest first and exclude spam by default
+	page, next, err := s.ListSubmissions(Query{Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 4 || next == "" {
+		t.Fatalf("Expected 4 results and a next cursor, got %d and %q", len(page), next)
+	}
+	if !page[0].CreatedAt.Equal(start.Add(6 * time.Hour)) {
+		t.Errorf("Expected newest submission first, got %v", page[0].CreatedAt)
+	}
+
+	page, next, err = s.ListSubmissions(Query{Limit: 4, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || next != "" {
+		t.Errorf("Expected last page with 2 results, got %d and cursor %q", len(page), next)
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected int
+	}{
+		{"form filter", Query{Form: "sales"}, 2},
+		{"spam only", Query{Spam: SpamOnly}, 1},
+		{"all", Query{Spam: SpamAll}, 7},
+		{"failed delivery", Query{Failed: true}, 1},
+		{"search", Query{Search: "quote"}, 1},
+		{"since", Query{Spam: SpamAll, Since: start.Add(5 * time.Hour)}, 2},
+		{"until", Query{Spam: SpamAll, Until: start.Add(2 * time.Hour)}, 2},
+		{"ticket status", Query{Status: models.TicketOpen}, 5},
+		{"assignee", Query{Assignee: "alice"}, 1},
+		{"unassigned", Query{Unassigned: true}, 5},
+		{"tag", Query{Tag: "billing"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs, _, err := s.ListSubmissions(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subs) != tt.expected {
+				t.Errorf("Expected %d results, got %d", tt.expected, len(subs))
+			}
+		})
+	}
+}
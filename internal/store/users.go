@@ -0,0 +1,208 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"formfling/internal/models"
+)
+
+var (
+	usersBucket    = []byte("users")
+	sessionsBucket = []byte("sessions")
+)
+
+// ErrExists is returned when creating a record that already exists
+var ErrExists = errors.New("already exists")
+
+// CreateUser stores a new admin account
+func (s *Store) CreateUser(user *models.User) error {
+	user.Username = strings.ToLower(user.Username)
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket.Get([]byte(user.Username)) != nil {
+			return ErrExists
+		}
+		return bucket.Put([]byte(user.Username), data)
+	})
+}
+
+// UpdateUser replaces a stored account
+func (s *Store) UpdateUser(user *models.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket.Get([]byte(user.Username)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Put([]byte(user.Username), data)
+	})
+}
+
+// ModifyUser loads an account, lets fn change it and stores the result in a
+// single transaction, so concurrent changes are not lost. Nothing is stored
+// when fn returns an error.
+func (s *Store) ModifyUser(username string, fn func(*models.User) error) (*models.User, error) {
+	var user *models.User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		key := []byte(strings.ToLower(username))
+		data := bucket.Get(key)
+		if data == nil {
+			return ErrNotFound
+		}
+		user = &models.User{}
+		if err := json.Unmarshal(data, user); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		data, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("failed to encode user: %v", err)
+		}
+		return bucket.Put(key, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUser loads an account by username
+func (s *Store) GetUser(username string) (*models.User, error) {
+	var user *models.User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get([]byte(strings.ToLower(username)))
+		if data == nil {
+			return ErrNotFound
+		}
+		user = &models.User{}
+		return json.Unmarshal(data, user)
+	})
+	return user, err
+}
+
+// ListUsers returns all accounts sorted by username
+func (s *Store) ListUsers() ([]*models.User, error) {
+	var users []*models.User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			user := &models.User{}
+			if err := json.Unmarshal(v, user); err != nil {
+				return fmt.Errorf("failed to decode user %s: %v", k, err)
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, err
+}
+
+// DeleteUser removes an account and signs out its sessions
+func (s *Store) DeleteUser(username string) error {
+	username = strings.ToLower(username)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket.Get([]byte(username)) == nil {
+			return ErrNotFound
+		}
+		if err := bucket.Delete([]byte(username)); err != nil {
+			return err
+		}
+		return deleteSessions(tx, func(sess *models.Session) bool { return sess.Username == username })
+	})
+}
+
+// CreateSession stores a session under the hash of its token
+func (s *Store) CreateSession(token string, sess *models.Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(hashToken(token)), data)
+	})
+}
+
+// GetSession loads an unexpired session by its token
+func (s *Store) GetSession(token string) (*models.Session, error) {
+	var sess *models.Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(hashToken(token)))
+		if data == nil {
+			return ErrNotFound
+		}
+		sess = &models.Session{}
+		return json.Unmarshal(data, sess)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+// DeleteSession signs out a single session
+func (s *Store) DeleteSession(token string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(hashToken(token)))
+	})
+}
+
+// DeleteUserSessions signs out every session of an account
+func (s *Store) DeleteUserSessions(username string) error {
+	username = strings.ToLower(username)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteSessions(tx, func(sess *models.Session) bool { return sess.Username == username })
+	})
+}
+
+// PurgeSessions removes expired sessions
+func (s *Store) PurgeSessions() error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteSessions(tx, func(sess *models.Session) bool { return now.After(sess.ExpiresAt) })
+	})
+}
+
+func deleteSessions(tx *bolt.Tx, match func(*models.Session) bool) error {
+	bucket := tx.Bucket(sessionsBucket)
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		var sess models.Session
+		if err := json.Unmarshal(v, &sess); err != nil || match(&sess) {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"formfling/internal/models"
+)
+
+func TestStore_UsersAndSessions(t *testing.T) {
+	s := openTestStore(t)
+
+	if err := s.CreateUser(&models.User{Username: "Jane", Role: models.RoleEditor}); err != nil {
+		t.Fatalf("CreateUser returned error: %v", err)
+	}
+	if err := s.CreateUser(&models.User{Username: "jane", Role: models.RoleViewer}); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists for a username differing in case, got %v", err)
+	}
+
+	user, err := s.GetUser("JANE")
+	if err != nil || user.Username != "jane" || user.Role != models.RoleEditor {
+		t.Fatalf("Unexpected user %+v (%v)", user, err)
+	}
+
+	now := time.Now()
+	if err := s.CreateSession("live", &models.Session{Username: "jane", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+	if err := s.CreateSession("stale", &models.Session{Username: "jane", ExpiresAt: now.Add(-time.Hour)}); err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+	if _, err := s.GetSession("live"); err != nil {
+		t.Errorf("Expected live session, got %v", err)
+	}
+	if _, err := s.GetSession("stale"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected expired session to be rejected, got %v", err)
+	}
+
+	if err := s.DeleteUser("jane"); err != nil {
+		t.Fatalf("DeleteUser returned error: %v", err)
+	}
+	if _, err := s.GetSession("live"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected sessions to end with the account, got %v", err)
+	}
+	if users, _ := s.ListUsers(); len(users) != 0 {
+		t.Errorf("Expected no users, got %d", len(users))
+	}
+}
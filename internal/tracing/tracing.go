@@ -0,0 +1,118 @@
+// Package tracing records OpenTelemetry spans for requests, reCAPTCHA checks
+// and email deliveries and exports them over OTLP/HTTP. Trace context sent by
+// clients and proxies in W3C traceparent headers is continued.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"formfling/internal/logging"
+)
+
+// ServiceName names FormFling in traces unless OTEL_SERVICE_NAME is set
+const ServiceName = "formfling"
+
+// tracesPath is where OTLP/HTTP collectors take traces, relative to the
+// endpoint
+const tracesPath = "/v1/traces"
+
+// Setup exports traces to an OTLP/HTTP collector at endpoint, such as
+// http://localhost:4318, and returns a function that flushes the spans not
+// yet sent. Without an endpoint no spans are recorded, but trace context is
+// still passed on.
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(TracesURL(endpoint)))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// TracesURL is the URL traces are posted to for an OTLP endpoint, following
+// OTEL_EXPORTER_OTLP_ENDPOINT: the traces path is added to the base URL
+func TracesURL(endpoint string) string {
+	return strings.TrimSuffix(endpoint, "/") + tracesPath
+}
+
+// Start begins a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span, marking it failed if err is not nil. Error messages are
+// redacted like the logs, as SMTP errors often quote addresses.
+func End(span trace.Span, err error) {
+	if err != nil {
+		message := logging.Redact(err.Error())
+		span.RecordError(errorMessage(message))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
+
+type errorMessage string
+
+func (e errorMessage) Error() string { return string(e) }
+
+// Handler starts a span for every request, continuing the trace of the
+// client when it sent a traceparent header. Route names the span once the
+// router has matched the request.
+func Handler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// Route names the span of a request after its route template, so traces of
+// /f/contact and /f/support group under /f/{slug}. It is router middleware.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if current := mux.CurrentRoute(r); current != nil {
+			if route, err := current.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Transport traces outgoing requests made with base
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// record makes spans go to an in-memory exporter for the test
+func record(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	previous := otel.GetTracerProvider()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func TestHandler(t *testing.T) {
+	exporter := record(t)
+
+	r := mux.NewRouter()
+	r.Use(Route)
+	r.HandleFunc("/f/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "smtp.send")
+		End(span, errors.New("550 no such user jane@example.com"))
+	}).Methods("POST")
+
+	req := httptest.NewRequest("POST", "/f/contact", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Handler(r).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name != "POST /f/{slug}" {
+		t.Errorf("Expected the request span to be named after the route, got %q", server.Name)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace of the traceparent header, got %s", got)
+	}
+	if server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the request span to continue the remote span, got parent %s", server.Parent.SpanID())
+	}
+	if child.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("Expected %q to be a child of the request span", child.Name)
+	}
+	if child.Status.Code != codes.Error || child.Status.Description != "550 no such user ***@example.com" {
+		t.Errorf("Expected a failed span with a redacted error, got %+v", child.Status)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	shutdown, err := Setup(context.Background(), collector.URL+"/")
+	if err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+	_, span := Start(context.Background(), "recaptcha.verify")
+	End(span, nil)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected the spans to be exported, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != "/v1/traces" {
+		t.Errorf("Expected one export to /v1/traces, got %v", paths)
+	}
+}
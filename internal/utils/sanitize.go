@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"mime"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"formfling/internal/models"
+)
+
+// Context describes where a sanitized value ends up once the email is built
+type Context int
+
+const (
+	// ContextBody values are rendered in the message body and are escaped by
+	// html/template, so they are kept verbatim
+	ContextBody Context = iota
+	// ContextHeader values may be written into a mail header and must never
+	// contain line breaks
+	ContextHeader
+)
+
+// Normalization is a set of optional per-field clean-ups
+type Normalization uint8
+
+const (
+	// Trim removes leading and trailing whitespace
+	Trim Normalization = 1 << iota
+	// CollapseWhitespace replaces every run of whitespace with a single space
+	CollapseWhitespace
+	// NFC applies Unicode canonical composition
+	NFC
+)
+
+// FieldRule tells the sanitizer how to treat a single form field
+type FieldRule struct {
+	Context   Context
+	Normalize Normalization
+}
+
+// DefaultFieldRules holds the rules for the built-in form fields. Fields that
+// are not listed here are treated as body values that are only trimmed.
+var DefaultFieldRules = map[string]FieldRule{
+	"name":    {Context: ContextHeader, Normalize: Trim | CollapseWhitespace | NFC},
+	"email":   {Context: ContextHeader, Normalize: Trim},
+	"subject": {Context: ContextHeader, Normalize: Trim | CollapseWhitespace | NFC},
+	"message": {Context: ContextBody, Normalize: Trim | NFC},
+	"phone":   {Context: ContextBody, Normalize: Trim | CollapseWhitespace},
+	"website": {Context: ContextBody, Normalize: Trim},
+
+	"_replyto": {Context: ContextHeader, Normalize: Trim},
+	"_subject": {Context: ContextHeader, Normalize: Trim | CollapseWhitespace | NFC},
+	"_cc":      {Context: ContextHeader, Normalize: Trim},
+}
+
+var defaultRule = FieldRule{Context: ContextBody, Normalize: Trim}
+
+// RuleFor returns the sanitization rule for the named field
+func RuleFor(field string) FieldRule {
+	if rule, ok := DefaultFieldRules[field]; ok {
+		return rule
+	}
+	return defaultRule
+}
+
+// Sanitize applies a rule to a value. Case and wording are always preserved.
+func Sanitize(value string, rule FieldRule) string {
+	if rule.Normalize&NFC != 0 {
+		value = norm.NFC.String(value)
+	}
+	if rule.Context == ContextHeader {
+		value = StripHeaderBreaks(value)
+	}
+	if rule.Normalize&CollapseWhitespace != 0 {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	if rule.Normalize&Trim != 0 {
+		value = strings.TrimSpace(value)
+	}
+	return value
+}
+
+// SanitizeField sanitizes a value using the rule registered for the field
+func SanitizeField(field, value string) string {
+	return Sanitize(value, RuleFor(field))
+}
+
+// SanitizeForm sanitizes all user supplied fields of a form. The reCAPTCHA
+// token is left untouched.
+func SanitizeForm(form models.FormData) models.FormData {
+	form.Name = SanitizeField("name", form.Name)
+	form.Email = SanitizeField("email", form.Email)
+	form.Subject = SanitizeField("subject", form.Subject)
+	form.Message = SanitizeField("message", form.Message)
+	form.Phone = SanitizeField("phone", form.Phone)
+	form.Website = SanitizeField("website", form.Website)
+	return form
+}
+
+// StripHeaderBreaks replaces CR, LF and other control characters with spaces
+// so a value cannot start a new header line
+func StripHeaderBreaks(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '\u2028' || r == '\u2029' {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// EncodeHeader makes a value safe to write into a mail header. Line breaks are
+// removed and non-ASCII text is RFC 2047 encoded.
+func EncodeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", strings.TrimSpace(StripHeaderBreaks(value)))
+}
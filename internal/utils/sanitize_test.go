@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"formfling/internal/models"
+)
+
+func TestSanitizeField(t *testing.T) {
+	tests := []struct {
+		field    string
+		input    string
+		expected string
+	}{
+		{"name", "  John   Doe  ", "John Doe"},
+		{"name", "Jane\r\nBcc: evil@example.com", "Jane Bcc: evil@example.com"},
+		{"subject", "Photo: Toronto trip", "Photo: Toronto trip"},
+		{"subject", "Hello\nTo: victim@example.com", "Hello To: victim@example.com"},
+		{"email", " John@Example.com ", "John@Example.com"},
+		{"message", "  Line one\n\nLine two  ", "Line one\n\nLine two"},
+		{"message", "<a href=\"x\">Content-Type</a>", "<a href=\"x\">Content-Type</a>"},
+		{"phone", " +1  555\t0100 ", "+1 555 0100"},
+		{"unknown", "  Keep\nAs Is  ", "Keep\nAs Is"},
+	}
+
+	for _, test := range tests {
+		result := SanitizeField(test.field, test.input)
+		if result != test.expected {
+			t.Errorf("SanitizeField(%q, %q) = %q, expected %q", test.field, test.input, result, test.expected)
+		}
+	}
+}
+
+func TestSanitize_NFC(t *testing.T) {
+	decomposed := "Jose\u0301"
+	composed := "Jos\u00e9"
+
+	if result := Sanitize(decomposed, FieldRule{Normalize: NFC}); result != composed {
+		t.Errorf("Expected NFC form %q, got %q", composed, result)
+	}
+
+	if result := Sanitize(decomposed, FieldRule{}); result != decomposed {
+		t.Errorf("Expected value without NFC rule to be unchanged, got %q", result)
+	}
+}
+
+func TestSanitizeForm(t *testing.T) {
+	form := SanitizeForm(models.FormData{
+		Name:              " Jane Doe ",
+		Email:             "jane@example.com\r\n",
+		Subject:           "Re: Toronto",
+		Message:           "Hello,\nSee you in Toronto: soon.",
+		RecaptchaResponse: " token ",
+	})
+
+	if form.Name != "Jane Doe" {
+		t.Errorf("Expected name 'Jane Doe', got %q", form.Name)
+	}
+	if form.Email != "jane@example.com" {
+		t.Errorf("Expected email without line break, got %q", form.Email)
+	}
+	if form.Subject != "Re: Toronto" {
+		t.Errorf("Expected subject to keep its case, got %q", form.Subject)
+	}
+	if form.Message != "Hello,\nSee you in Toronto: soon." {
+		t.Errorf("Expected message to be kept verbatim, got %q", form.Message)
+	}
+	if form.RecaptchaResponse != " token " {
+		t.Errorf("Expected reCAPTCHA token to be untouched, got %q", form.RecaptchaResponse)
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	if result := EncodeHeader("Contact Me"); result != "Contact Me" {
+		t.Errorf("Expected ASCII header to be unchanged, got %q", result)
+	}
+
+	result := EncodeHeader("Zoë\r\nBcc: evil@example.com")
+	if strings.ContainsAny(result, "\r\n") {
+		t.Errorf("Encoded header must not contain line breaks, got %q", result)
+	}
+	if !strings.HasPrefix(result, "=?utf-8?q?") {
+		t.Errorf("Expected non-ASCII header to be RFC 2047 encoded, got %q", result)
+	}
+}
@@ -1,30 +1,23 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"formfling/internal/models"
 )
 
-func CleanString(input string) string {
-	// Remove potentially dangerous content
-	bad := []string{
-		"content-type",
-		"bcc:",
-		"to:",
-		"cc:",
-		"href",
-	}
-
-	result := input
-	for _, badWord := range bad {
-		result = strings.ReplaceAll(strings.ToLower(result), badWord, "")
-	}
-
-	return strings.TrimSpace(result)
-}
+// Field error codes, matching the ones used by Formspree
+const (
+	CodeRequired  = "REQUIRED_FIELD_EMPTY"
+	CodeTypeEmail = "TYPE_EMAIL"
+	CodeTypeText  = "TYPE_TEXT"
+)
 
 func ValidateEmail(email string) bool {
 	// RFC 5322 compliant email regex (simplified)
@@ -32,22 +25,109 @@ func ValidateEmail(email string) bool {
 	return emailRegex.MatchString(email)
 }
 
-func ValidateForm(form models.FormData) error {
+// ValidateFormFields returns every problem found in the form, in field order
+func ValidateFormFields(form models.FormData) []models.FieldError {
+	var fieldErrors []models.FieldError
+
 	if strings.TrimSpace(form.Name) == "" {
-		return fmt.Errorf("name is required")
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "name", Code: CodeRequired, Message: "name is required"})
 	}
 
 	if strings.TrimSpace(form.Email) == "" {
-		return fmt.Errorf("email is required")
-	}
-
-	if !ValidateEmail(form.Email) {
-		return fmt.Errorf("email not valid")
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "email", Code: CodeRequired, Message: "email is required"})
+	} else if !ValidateEmail(form.Email) {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "email", Code: CodeTypeEmail, Message: "email not valid"})
 	}
 
 	if len(strings.TrimSpace(form.Message)) < 300 {
-		return fmt.Errorf("message not valid")
+		fieldErrors = append(fieldErrors, models.FieldError{Field: "message", Code: CodeTypeText, Message: "message not valid"})
 	}
 
+	return fieldErrors
+}
+
+func ValidateForm(form models.FormData) error {
+	if fieldErrors := ValidateFormFields(form); len(fieldErrors) > 0 {
+		return errors.New(fieldErrors[0].Message)
+	}
 	return nil
 }
+
+var urlRegex = regexp.MustCompile(`^https?://[^\s/$.?#].[^\s]*$`)
+
+// ValidateDefinitions checks a submission against the form's field definitions
+func ValidateDefinitions(defs []models.FieldDefinition, form models.FormData) []models.FieldError {
+	var fieldErrors []models.FieldError
+
+	for _, def := range defs {
+		value := strings.TrimSpace(form.Value(def.Name))
+		if value == "" {
+			if def.Required {
+				fieldErrors = append(fieldErrors, models.FieldError{Field: def.Name, Code: CodeRequired, Message: fmt.Sprintf("%s is required", def.Name)})
+			}
+			continue
+		}
+
+		if message := validateValue(def, value); message != "" {
+			code := CodeTypeText
+			if def.Type == "email" {
+				code = CodeTypeEmail
+			}
+			fieldErrors = append(fieldErrors, models.FieldError{Field: def.Name, Code: code, Message: message})
+		}
+	}
+
+	return fieldErrors
+}
+
+func validateValue(def models.FieldDefinition, value string) string {
+	length := utf8.RuneCountInString(value)
+	if def.MinLength > 0 && length < def.MinLength {
+		return fmt.Sprintf("%s must be at least %d characters", def.Name, def.MinLength)
+	}
+	if def.MaxLength > 0 && length > def.MaxLength {
+		return fmt.Sprintf("%s must be at most %d characters", def.Name, def.MaxLength)
+	}
+
+	switch def.Type {
+	case "email":
+		if !ValidateEmail(value) {
+			return fmt.Sprintf("%s not valid", def.Name)
+		}
+	case "url":
+		if !urlRegex.MatchString(value) {
+			return fmt.Sprintf("%s should be a URL", def.Name)
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Sprintf("%s should be a number", def.Name)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Sprintf("%s should be a date", def.Name)
+		}
+	case "select", "radio":
+		if !contains(def.Options, value) {
+			return fmt.Sprintf("%s has an unknown option", def.Name)
+		}
+	case "checkbox":
+		if len(def.Options) > 0 {
+			for _, choice := range strings.Split(value, ", ") {
+				if !contains(def.Options, choice) {
+					return fmt.Sprintf("%s has an unknown option", def.Name)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -6,27 +6,6 @@ import (
 	"formfling/internal/models"
 )
 
-func TestCleanString(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"normal text", "normal text"},
-		{"content-type: text/html", ": text/html"},
-		{"bcc:evil@example.com", "evil@example.com"},
-		{"  whitespace  ", "whitespace"},
-		{"TO:someone@test.com", "someone@test.com"},
-		{"href=\"malicious\"", "=\"malicious\""},
-	}
-
-	for _, test := range tests {
-		result := CleanString(test.input)
-		if result != test.expected {
-			t.Errorf("CleanString(%q) = %q, expected %q", test.input, result, test.expected)
-		}
-	}
-}
-
 func TestValidateEmail(t *testing.T) {
 	validEmails := []string{
 		"test@example.com",
@@ -115,3 +94,70 @@ func TestValidateForm(t *testing.T) {
 		t.Error("ValidateForm should fail for message too short")
 	}
 }
+
+func TestValidateFormFields(t *testing.T) {
+	fieldErrors := ValidateFormFields(models.FormData{Email: "not-an-email", Message: "short"})
+
+	if len(fieldErrors) != 3 {
+		t.Fatalf("Expected 3 field errors, got %d", len(fieldErrors))
+	}
+
+	expected := []struct{ field, code string }{
+		{"name", CodeRequired},
+		{"email", CodeTypeEmail},
+		{"message", CodeTypeText},
+	}
+	for i, e := range expected {
+		if fieldErrors[i].Field != e.field || fieldErrors[i].Code != e.code {
+			t.Errorf("Expected error %d to be %s/%s, got %s/%s", i, e.field, e.code, fieldErrors[i].Field, fieldErrors[i].Code)
+		}
+	}
+}
+
+func TestValidateDefinitions(t *testing.T) {
+	defs := []models.FieldDefinition{
+		{Name: "name", Type: "text", Required: true},
+		{Name: "email", Type: "email", Required: true},
+		{Name: "website", Type: "url"},
+		{Name: "guests", Type: "number"},
+		{Name: "date", Type: "date"},
+		{Name: "plan", Type: "select", Options: []string{"Basic", "Pro"}},
+		{Name: "topics", Type: "checkbox", Options: []string{"News", "Events"}},
+		{Name: "bio", Type: "textarea", MinLength: 5, MaxLength: 10},
+	}
+
+	valid := models.FormData{
+		Name:    "Jane",
+		Email:   "jane@example.com",
+		Website: "https://example.com",
+		Extra: []models.ExtraField{
+			{Name: "guests", Value: "2"},
+			{Name: "date", Value: "2024-05-01"},
+			{Name: "plan", Value: "Pro"},
+			{Name: "topics", Value: "News, Events"},
+			{Name: "bio", Value: "Hello!"},
+		},
+	}
+	if fieldErrors := ValidateDefinitions(defs, valid); len(fieldErrors) != 0 {
+		t.Errorf("Expected no errors for valid form, got %+v", fieldErrors)
+	}
+
+	invalid := models.FormData{
+		Email:   "nope",
+		Website: "example",
+		Extra: []models.ExtraField{
+			{Name: "guests", Value: "two"},
+			{Name: "date", Value: "May 1st"},
+			{Name: "plan", Value: "Enterprise"},
+			{Name: "topics", Value: "News, Sports"},
+			{Name: "bio", Value: "Hi"},
+		},
+	}
+	fieldErrors := ValidateDefinitions(defs, invalid)
+	if len(fieldErrors) != len(defs) {
+		t.Fatalf("Expected %d errors, got %+v", len(defs), fieldErrors)
+	}
+	if fieldErrors[0].Code != CodeRequired || fieldErrors[1].Code != CodeTypeEmail {
+		t.Errorf("Unexpected error codes: %+v", fieldErrors[:2])
+	}
+}
@@ -1,66 +1,120 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
 
 	"formfling/internal/config"
-	"formfling/internal/handlers"
-	"formfling/internal/middleware"
-	"formfling/internal/services"
-
-	"github.com/gorilla/mux"
+	"formfling/internal/store"
 )
 
-func main() {
-	// Load configuration
-	cfg := config.Load()
+const usage = `Usage: formfling [-config file] [-set key=value]... [command] [arguments]
 
-	// Validate required environment variables
-	if cfg.SMTPUsername == "" || cfg.SMTPPassword == "" {
-		log.Fatal("SMTP_USERNAME and SMTP_PASSWORD are required")
-	}
-	if cfg.FromEmail == "" || cfg.ToEmail == "" {
-		log.Fatal("FROM_EMAIL and TO_EMAIL are required")
-	}
+Options:
+  -config file                  Read settings from a YAML or TOML file
+                                (default: $FORMFLING_CONFIG)
+  -set key=value                Override a setting; may be repeated
+
+Commands:
+  serve [-dev]                  Run the server (the default)
+  check-config [-dev]           Validate the settings, templates and forms
+  print-config [-dev]           Print the resolved settings, secrets redacted
+  send-test [-form slug] [-to address]
+                                Email a sample submission over SMTP
+  render-template [-plain] [-type success|error] email|status
+                                Render a template with sample data
+  users <command>               Manage admin accounts
+  api-keys <command>            Manage API keys
+  submissions <command>         List, show, delete and export submissions
 
-	// Initialize services
-	emailService := services.NewEmailService(cfg)
-	recaptchaService := services.NewRecaptchaService(cfg)
+Flags take precedence over environment variables, which take precedence over
+the config file. See .env.example and formfling.example.yaml. Run
+formfling <command> -h for the arguments of a command.
+`
 
-	// Log reCAPTCHA status
-	if cfg.RecaptchaEnabled {
-		log.Printf("reCAPTCHA v3 enabled (min score: %.2f, action: %s)",
-			cfg.RecaptchaMinScore, cfg.RecaptchaAction)
-	} else {
-		log.Printf("reCAPTCHA v3 disabled (no secret key provided)")
+func main() {
+	global := flag.NewFlagSet("formfling", flag.ContinueOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	var opts config.LoadOptions
+	global.StringVar(&opts.File, "config", "", "YAML or TOML config file")
+	overrides := setFlags{}
+	global.Var(overrides, "set", "override a setting as key=value")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
 	}
+	opts.Flags = overrides
 
-	// Setup handlers
-	submitHandler := handlers.NewSubmitHandler(cfg, emailService, recaptchaService)
-	healthHandler := handlers.NewHealthHandler()
-	statusHandler := handlers.NewStatusHandler(cfg)
+	// Load configuration
+	cfg, errs := config.Load(opts)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "- %v\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "%d problem(s) found in the configuration\n", len(errs))
+		os.Exit(1)
+	}
 
-	// Setup router
-	r := mux.NewRouter()
-	r.Use(middleware.CORS(cfg))
+	// Without a command the server runs, so existing setups keep working
+	command, args := "serve", global.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
 
-	r.HandleFunc("/submit", submitHandler.Handle).Methods("POST", "OPTIONS")
-	r.HandleFunc("/health", healthHandler.Handle).Methods("GET")
-	r.HandleFunc("/status", statusHandler.Handle).Methods("GET")
+	switch command {
+	case "serve":
+		runServe(cfg, opts, args)
+	case "check-config":
+		os.Exit(runCheckConfig(cfg, args))
+	case "print-config":
+		os.Exit(runPrintConfig(cfg, args))
+	case "send-test":
+		os.Exit(runSendTest(cfg, args))
+	case "render-template":
+		os.Exit(runRenderTemplate(cfg, args))
+	case "users":
+		os.Exit(runUsers(cfg, args))
+	case "api-keys":
+		os.Exit(runAPIKeys(cfg, args))
+	case "submissions":
+		os.Exit(runSubmissions(cfg, args))
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
 
-	if cfg.EnableTestForm {
-		testFormHandler := handlers.NewTestFormHandler(cfg)
-		r.HandleFunc("/test_form", testFormHandler.Handle).Methods("GET")
+// openStore opens the database for a command. Only one process can open it,
+// so this fails while the server is running.
+func openStore(cfg *config.Config, purpose string) (*store.Store, error) {
+	if cfg.StorePath == "" {
+		return nil, fmt.Errorf("STORE_PATH must be set to %s", purpose)
 	}
+	s, err := store.Open(cfg.StorePath)
+	if err != nil {
+		return nil, fmt.Errorf("%v (is the server running?)", err)
+	}
+	return s, nil
+}
 
-	// Static file serving
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web/static/")))
+// setFlags collects repeated -set key=value flags
+type setFlags map[string]string
 
-	log.Printf("FormFling server starting on port %s", cfg.Port)
-	log.Printf("Allowed origins: %v", cfg.AllowedOrigins)
+func (f setFlags) String() string {
+	return ""
+}
 
-	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
-		log.Fatal("Server failed to start:", err)
+func (f setFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected key=value")
 	}
+	f[strings.TrimSpace(key)] = val
+	return nil
 }
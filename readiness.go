@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+// readinessChecks are the dependencies /readyz reports on. A failed SMTP
+// sign-in, an unreadable store or a delivery backlog over its limit makes
+// the instance not ready; a rejected reload is only a warning, as the
+// configuration loaded before it keeps serving.
+func (s *server) readinessChecks(cfg *config.Config, emailService *services.EmailService) []services.ReadinessCheck {
+	checks := []services.ReadinessCheck{
+		{
+			Name:     "smtp",
+			Critical: true,
+			Interval: cfg.ReadyCheckInterval,
+			Check: func(ctx context.Context) (string, error) {
+				if s.mailCatcher != nil {
+					return "development mode, email is captured", nil
+				}
+				if err := emailService.CheckConnection(ctx); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("signed in to %s:%d", cfg.SMTPHost, cfg.SMTPPort), nil
+			},
+		},
+		{
+			Name: "templates",
+			Check: func(context.Context) (string, error) {
+				if failure := s.failedReload.Load(); failure != nil {
+					return "", fmt.Errorf("the last reload was rejected, the templates and settings loaded before it are served: %s", *failure)
+				}
+				return "loaded", nil
+			},
+		},
+		{
+			Name:     "deliveries",
+			Critical: true,
+			Check: func(context.Context) (string, error) {
+				inFlight := services.DeliveriesInFlight()
+				if cfg.ReadyMaxInFlight > 0 && inFlight > cfg.ReadyMaxInFlight {
+					return "", fmt.Errorf("%d deliveries in flight, more than READY_MAX_IN_FLIGHT (%d)", inFlight, cfg.ReadyMaxInFlight)
+				}
+				return fmt.Sprintf("%d in flight", inFlight), nil
+			},
+		},
+	}
+
+	if s.store == nil {
+		return checks
+	}
+	checks = append(checks, services.ReadinessCheck{
+		Name:     "store",
+		Critical: true,
+		Check: func(context.Context) (string, error) {
+			if err := s.store.Ping(); err != nil {
+				return "", err
+			}
+			return "reachable", nil
+		},
+	})
+	if cfg.ReadyMaxFailed > 0 {
+		// Counting means reading the submissions, so it is cached like the
+		// SMTP check and stops once the limit is passed
+		checks = append(checks, services.ReadinessCheck{
+			Name:     "failed_deliveries",
+			Critical: true,
+			Interval: cfg.ReadyCheckInterval,
+			Check: func(context.Context) (string, error) {
+				failed := 0
+				err := s.store.EachSubmission(store.Query{Failed: true}, func(*models.Submission) bool {
+					failed++
+					return failed <= cfg.ReadyMaxFailed
+				})
+				if err != nil {
+					return "", err
+				}
+				if failed > cfg.ReadyMaxFailed {
+					return "", fmt.Errorf("more than READY_MAX_FAILED (%d) submissions wait for a delivery to be retried", cfg.ReadyMaxFailed)
+				}
+				return fmt.Sprintf("%d awaiting a retry", failed), nil
+			},
+		})
+	}
+	return checks
+}
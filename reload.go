@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/logging"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+// server holds what outlives a reload: the database, the dev mail inbox, the
+// sign-in rate limits and the random secrets. Everything built from the
+// configuration is rebuilt and swapped in as a whole.
+type server struct {
+	opts        config.LoadOptions
+	dev         bool
+	store       *store.Store
+	mailCatcher *services.MailCatcher
+	authService *services.AuthService
+	powSecret   string
+	csrfSecret  string
+
+	reloadMu sync.Mutex // one reload at a time
+	current  atomic.Pointer[site]
+	// failedReload is why the last reload was rejected, or nil
+	failedReload atomic.Pointer[string]
+}
+
+// site is the routers built from one configuration
+type site struct {
+	cfg     *config.Config
+	handler http.Handler
+	// admin serves the ADMIN_LISTEN addresses
+	admin http.Handler
+}
+
+// ServeHTTP serves with the last good configuration. Requests in flight
+// during a reload finish with the one they started with.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.current.Load().handler.ServeHTTP(w, r)
+}
+
+// adminHandler serves the admin listener with the last good configuration
+func (s *server) adminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.current.Load().admin.ServeHTTP(w, r)
+	})
+}
+
+// handleReloads reloads on SIGHUP and, when WATCH_INTERVAL is set, when a
+// watched file changes
+func (s *server) handleReloads() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			s.reload("SIGHUP")
+		}
+	}()
+
+	if interval := s.current.Load().cfg.WatchInterval; interval > 0 {
+		go s.watch(interval)
+		log.Printf("Watching configuration, forms and template files every %v", interval)
+	}
+}
+
+// watch polls the files the configuration was built from. Polling also sees
+// the symlink swaps Kubernetes uses to update mounted files.
+func (s *server) watch(interval time.Duration) {
+	last := fingerprint(s.current.Load().cfg.WatchedFiles())
+	for range time.Tick(interval) {
+		if fingerprint(s.current.Load().cfg.WatchedFiles()) == last {
+			continue
+		}
+		s.reload("files changed")
+		// After a failed reload the broken files are not retried until
+		// they change again
+		last = fingerprint(s.current.Load().cfg.WatchedFiles())
+	}
+}
+
+// fingerprint summarizes the size and modification time of files
+func fingerprint(files []string) string {
+	var b strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", file, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s missing\n", file)
+		}
+	}
+	return b.String()
+}
+
+// reload loads the configuration, forms and templates again and swaps them
+// in. If anything is wrong the running configuration stays active.
+func (s *server) reload(reason string) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, errs := config.Load(s.opts)
+	if len(errs) == 0 {
+		if s.dev {
+			cfg.UseDevMode()
+		}
+		for _, env := range keepRestartSettings(s.current.Load().cfg, cfg) {
+			log.Printf("Reload (%s): %s cannot change without a restart, keeping the running value", reason, env)
+		}
+		next, err := s.build(cfg)
+		if err == nil {
+			s.current.Store(next)
+			s.failedReload.Store(nil)
+			logging.SetLevel(cfg.Level())
+			log.Printf("Reloaded configuration (%s)", reason)
+			return
+		}
+		errs = append(errs, err)
+	}
+
+	failure := errors.Join(errs...).Error()
+	s.failedReload.Store(&failure)
+	log.Printf("Reload (%s) failed, keeping the last good configuration:", reason)
+	for _, err := range errs {
+		log.Print(err)
+	}
+}
+
+// keepRestartSettings copies the settings that the listeners and the database
+// were started with from the running configuration into the next one, and
+// returns the names of those that were changed
+func keepRestartSettings(running, next *config.Config) []string {
+	var changed []string
+	keepString := func(env string, from string, to *string) {
+		if *to != from {
+			changed = append(changed, env)
+			*to = from
+		}
+	}
+	keepInt := func(env string, from int, to *int) {
+		if *to != from {
+			changed = append(changed, env)
+			*to = from
+		}
+	}
+	keepDuration := func(env string, from time.Duration, to *time.Duration) {
+		if *to != from {
+			changed = append(changed, env)
+			*to = from
+		}
+	}
+	keepString("PORT", running.Port, &next.Port)
+	keepString("STORE_PATH", running.StorePath, &next.StorePath)
+	keepString("INBOUND_ADDR", running.InboundAddr, &next.InboundAddr)
+	keepString("INBOUND_PROTOCOL", running.InboundProtocol, &next.InboundProtocol)
+	keepString("INBOUND_DOMAIN", running.InboundDomain, &next.InboundDomain)
+	keepString("INBOUND_SECRET", running.InboundSecret, &next.InboundSecret)
+	keepInt("INBOUND_MAX_BYTES", running.InboundMaxBytes, &next.InboundMaxBytes)
+	keepString("TLS_CERT_FILE", running.TLSCertFile, &next.TLSCertFile)
+	keepString("TLS_KEY_FILE", running.TLSKeyFile, &next.TLSKeyFile)
+	keepString("TLS_MIN_VERSION", running.TLSMinVersion, &next.TLSMinVersion)
+	keepString("TLS_CLIENT_CA_FILE", running.TLSClientCAFile, &next.TLSClientCAFile)
+	keepString("HTTP_REDIRECT_PORT", running.HTTPRedirectPort, &next.HTTPRedirectPort)
+	keepString("UNIX_SOCKET_MODE", running.UnixSocketMode, &next.UnixSocketMode)
+	keepString("UNIX_SOCKET_GROUP", running.UnixSocketGroup, &next.UnixSocketGroup)
+	keepString("LOG_FORMAT", running.LogFormat, &next.LogFormat)
+	keepString("OTEL_EXPORTER_OTLP_ENDPOINT", running.OTLPEndpoint, &next.OTLPEndpoint)
+	keepList := func(env string, from []string, to *[]string) {
+		if !slices.Equal(*to, from) {
+			changed = append(changed, env)
+			*to = from
+		}
+	}
+	keepList("LISTEN", running.Listen, &next.Listen)
+	keepList("ADMIN_LISTEN", running.AdminListen, &next.AdminListen)
+	keepDuration("WATCH_INTERVAL", running.WatchInterval, &next.WatchInterval)
+	keepDuration("READ_HEADER_TIMEOUT", running.ReadHeaderTimeout, &next.ReadHeaderTimeout)
+	keepDuration("READ_TIMEOUT", running.ReadTimeout, &next.ReadTimeout)
+	keepDuration("WRITE_TIMEOUT", running.WriteTimeout, &next.WriteTimeout)
+	keepDuration("IDLE_TIMEOUT", running.IdleTimeout, &next.IdleTimeout)
+	return changed
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+
+	"formfling/internal/config"
+	"formfling/internal/handlers"
+	"formfling/internal/models"
+	"formfling/internal/services"
+)
+
+const renderTemplateUsage = `Usage: formfling render-template [-plain] [-type success|error] email|status
+
+Renders EMAIL_TEMPLATE or STATUS_TEMPLATE with sample data to standard output,
+so a template can be previewed in a browser without submitting a form.
+`
+
+// runRenderTemplate renders a template with sample data and returns the exit
+// code
+func runRenderTemplate(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("render-template", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, renderTemplateUsage) }
+	plain := flags.Bool("plain", false, "render the plain text email instead of EMAIL_TEMPLATE")
+	status := flags.String("type", "success", "status page to render, success or error")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var err error
+	switch flags.Arg(0) {
+	case "email":
+		var emailService *services.EmailService
+		if emailService, err = services.LoadEmailService(cfg); err == nil {
+			_, err = emailService.RenderEmail(os.Stdout, sampleFormData(), "https://example.com/contact", models.EmailOptions{PlainText: *plain})
+		}
+	case "status":
+		if *status != "success" && *status != "error" {
+			fmt.Fprintln(os.Stderr, "-type must be success or error")
+			return 2
+		}
+		var statusTemplate *template.Template
+		if statusTemplate, err = template.ParseFiles(cfg.StatusTemplate); err == nil {
+			err = statusTemplate.Execute(os.Stdout, sampleStatusPage(cfg, *status))
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// sampleFormData is the submission used to preview and test templates
+func sampleFormData() models.FormData {
+	return models.FormData{
+		Name:    "Jane Doe",
+		Email:   "jane@example.com",
+		Subject: "Hello from FormFling",
+		Message: "This is a sample submission.\nIt shows how a message looks in your template.",
+		Phone:   "+1 555 0100",
+		Website: "https://example.com",
+		Extra: []models.ExtraField{
+			{Name: "company", Label: "Company", Value: "Example Inc."},
+		},
+	}
+}
+
+// sampleStatusPage is the data of the status page shown after a submission
+func sampleStatusPage(cfg *config.Config, status string) handlers.StatusPageData {
+	return handlers.StatusPageData{
+		Status:      status,
+		FormTitle:   cfg.FormTitle,
+		Message:     handlers.StatusMessage(status),
+		RedirectURL: "https://example.com/contact",
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+	"formfling/internal/utils"
+)
+
+// runSendTest emails a sample submission the way a form would, to check the
+// SMTP settings and the recipient of a form, and returns the exit code
+func runSendTest(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("send-test", flag.ContinueOnError)
+	slug := flags.String("form", config.DefaultFormSlug, "form whose recipient and title to use")
+	to := flags.String("to", "", "send to this address instead of the form's recipient")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *to != "" && !utils.ValidateEmail(*to) {
+		fmt.Fprintf(os.Stderr, "-to %q is not an email address\n", *to)
+		return 2
+	}
+
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "- %v\n", err)
+		}
+		return 1
+	}
+
+	// Forms from the database need the store, which the server may hold
+	var s *store.Store
+	if cfg.FormsBackend == config.FormsBackendDB {
+		var err error
+		if s, err = openStore(cfg, "load forms"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer s.Close()
+	}
+	if _, err := loadForms(cfg, s); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	form, ok := cfg.Form(*slug)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown form %q\n", *slug)
+		return 1
+	}
+
+	emailService, err := services.LoadEmailService(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading email template:", err)
+		return 1
+	}
+	opts := models.EmailOptions{
+		FormTitle: form.Title,
+		ToEmail:   form.ToEmail,
+		ToName:    form.ToName,
+		Subject:   fmt.Sprintf("[Test] New submission from %s", form.Title),
+	}
+	if *to != "" {
+		opts.ToEmail, opts.ToName = *to, ""
+	}
+	if opts.ToEmail == "" {
+		opts.ToEmail, opts.ToName = cfg.ToEmail, cfg.ToName
+	}
+
+	if err := emailService.SendEmail(context.Background(), sampleFormData(), "formfling send-test", opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Error sending email:", err)
+		return 1
+	}
+	fmt.Printf("Sent a test submission to %s\n", opts.ToEmail)
+	return 0
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"formfling/internal/config"
+	"formfling/internal/handlers"
+	"formfling/internal/inbound"
+	"formfling/internal/listener"
+	"formfling/internal/logging"
+	"formfling/internal/metrics"
+	"formfling/internal/middleware"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+	"formfling/internal/tracing"
+
+	"github.com/gorilla/mux"
+)
+
+// runServe runs the HTTP server and, when configured, the inbound mail
+// listener until the process is stopped
+func runServe(cfg *config.Config, opts config.LoadOptions, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	dev := flags.Bool("dev", false, "capture outgoing email at /dev/mail instead of sending it over SMTP")
+	flags.Parse(args)
+	if *dev {
+		cfg.UseDevMode()
+	}
+	logging.Setup(os.Stderr, cfg.LogFormat, cfg.Level())
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.OTLPEndpoint)
+	if err != nil {
+		log.Fatal("Error setting up tracing: ", err)
+	}
+	if cfg.OTLPEndpoint != "" {
+		log.Printf("Exporting traces to %s", tracing.TracesURL(cfg.OTLPEndpoint))
+	}
+
+	srv := &server{
+		opts:       opts,
+		dev:        *dev,
+		powSecret:  randomSecret(),
+		csrfSecret: randomSecret(),
+	}
+
+	// Open the submission store
+	if cfg.StorePath != "" {
+		var err error
+		srv.store, err = store.Open(cfg.StorePath)
+		if err != nil {
+			log.Fatal("Error opening store: ", err)
+		}
+		defer srv.store.Close()
+		log.Printf("Storing submissions in %s", cfg.StorePath)
+
+		srv.authService = services.NewAuthService(srv.store)
+		if cfg.AdminPassword != "" {
+			bootstrapOwner(cfg, srv.store)
+		}
+	}
+	if cfg.DevMode {
+		srv.mailCatcher = services.NewMailCatcher(200)
+	}
+
+	current, err := srv.build(cfg)
+	if err != nil {
+		log.Print(err)
+		log.Fatal("Invalid configuration, run formfling check-config for details")
+	}
+	srv.current.Store(current)
+	srv.handleReloads()
+
+	var inboundServer *inbound.Server
+	if cfg.InboundAddr != "" {
+		inboundServer, err = inbound.NewServer(cfg, inbound.NewIngester(cfg, srv.store))
+		if err != nil {
+			log.Fatal("Invalid inbound mail configuration: ", err)
+		}
+		go func() {
+			if err := inboundServer.ListenAndServe(); !errors.Is(err, net.ErrClosed) {
+				log.Fatal("Inbound mail listener failed: ", err)
+			}
+		}()
+		log.Printf("Receiving %s replies for %s on %s", strings.ToUpper(cfg.InboundProtocol), cfg.InboundDomain, cfg.InboundAddr)
+	}
+
+	httpServer := newHTTPServer(cfg, srv)
+	if cfg.TLSEnabled() {
+		certificates, err := services.LoadCertificate(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if httpServer.TLSConfig, err = services.NewTLSConfig(cfg, certificates); err != nil {
+			log.Fatal(err)
+		}
+	}
+	serve(httpServer, cfg, cfg.ListenAddrs())
+
+	var adminServer *http.Server
+	if len(cfg.AdminListen) > 0 {
+		adminServer = newHTTPServer(cfg, srv.adminHandler())
+		adminServer.TLSConfig = httpServer.TLSConfig
+		serve(adminServer, cfg, cfg.AdminListen)
+		log.Printf("Serving the admin dashboard, API and tools on the admin addresses only")
+	}
+
+	var redirectServer *http.Server
+	if cfg.HTTPRedirectPort != "" {
+		redirectServer = newHTTPServer(cfg, http.HandlerFunc(handlers.NewRedirectHandler(cfg.Port).Handle))
+		redirectServer.Addr = ":" + cfg.HTTPRedirectPort
+		go func() {
+			if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal("HTTP redirect listener failed: ", err)
+			}
+		}()
+		log.Printf("Redirecting HTTP on port %s to HTTPS", cfg.HTTPRedirectPort)
+	}
+
+	if cfg.TLSEnabled() {
+		log.Printf("FormFling server started with HTTPS")
+	} else {
+		log.Printf("FormFling server started")
+	}
+	log.Printf("Allowed origins: %v", cfg.AllowedOrigins)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	signal.Stop(stop)
+
+	// Deliveries run within their requests, so draining the requests also
+	// finishes the email being sent
+	timeout := srv.current.Load().cfg.ShutdownTimeout
+	log.Printf("Received %v, finishing requests in flight (up to %v)", sig, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Requests still in flight were cut off: %v", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Admin requests still in flight were cut off: %v", err)
+		}
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+	if inboundServer != nil {
+		if err := inboundServer.Shutdown(ctx); err != nil {
+			log.Printf("Mail sessions still open were cut off: %v", err)
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error sending the last traces: %v", err)
+	}
+	log.Print("FormFling server stopped")
+}
+
+// serve runs an HTTP server on addrs, with HTTPS when configured
+func serve(httpServer *http.Server, cfg *config.Config, addrs []string) {
+	opts := listener.Options{SocketMode: cfg.SocketMode(), SocketGroup: cfg.UnixSocketGroup}
+	for _, addr := range addrs {
+		l, err := listener.Listen(addr, opts)
+		if err != nil {
+			log.Fatalf("Server failed to listen on %s: %v", addr, err)
+		}
+		go func() {
+			var err error
+			if httpServer.TLSConfig != nil {
+				err = httpServer.ServeTLS(l, "", "")
+			} else {
+				err = httpServer.Serve(l)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal("Server failed:", err)
+			}
+		}()
+		log.Printf("Listening on %s", addr)
+	}
+}
+
+// newHTTPServer creates an HTTP server with the configured timeouts
+func newHTTPServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// build validates a configuration and creates the services, handlers and
+// routes for it. It changes nothing that is serving, so a broken
+// configuration can be rejected on reload.
+func (s *server) build(cfg *config.Config) (*site, error) {
+	if errs := checkConfig(cfg); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Without configured secrets the random ones of this process are used,
+	// so a reload does not invalidate open forms and challenges
+	if cfg.PowSecret == "" {
+		cfg.PowSecret = s.powSecret
+	}
+	if cfg.CSRFSecret == "" {
+		cfg.CSRFSecret = s.csrfSecret
+	}
+
+	// Load per-form settings
+	switch cfg.FormsBackend {
+	case config.FormsBackendFile:
+		if cfg.FormsFile != "" {
+			forms, err := config.LoadForms(cfg.FormsFile)
+			if err != nil {
+				return nil, fmt.Errorf("error loading forms: %v", err)
+			}
+			cfg.SetForms(forms)
+			log.Printf("Loaded %d form(s) from %s", len(forms), cfg.FormsFile)
+		}
+	case config.FormsBackendDB:
+		forms, err := s.store.LoadForms()
+		if err != nil {
+			return nil, fmt.Errorf("error loading forms: %v", err)
+		}
+		cfg.SetForms(forms)
+		log.Printf("Loaded %d form(s) from the database", len(forms))
+	}
+
+	// Initialize services
+	emailService, err := services.LoadEmailService(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error loading email template: %v", err)
+	}
+	if s.mailCatcher != nil {
+		emailService.SetTransport(s.mailCatcher)
+	}
+	recaptchaService := services.NewRecaptchaService(cfg)
+	powService := services.NewProofOfWorkService(cfg)
+	csrfService := services.NewCSRFService(cfg)
+	oidcService, err := services.NewOIDCService(cfg, s.store)
+	if err != nil {
+		return nil, err
+	}
+
+	// Log reCAPTCHA status
+	if cfg.RecaptchaEnabled {
+		log.Printf("reCAPTCHA v3 enabled (min score: %.2f, action: %s)",
+			cfg.RecaptchaMinScore, cfg.RecaptchaAction)
+	} else {
+		log.Printf("reCAPTCHA v3 disabled (no secret key provided)")
+	}
+	if powService.Enabled() {
+		log.Printf("Proof-of-work enabled (difficulty: %d bits)", cfg.PowDifficulty)
+	}
+
+	// Setup handlers
+	submitHandler := handlers.NewSubmitHandler(cfg, emailService, recaptchaService, powService, csrfService, s.store)
+	hostedFormHandler, err := handlers.LoadHostedFormHandler(cfg, csrfService, powService)
+	if err != nil {
+		return nil, err
+	}
+	embedHandler := handlers.NewEmbedHandler(cfg, powService)
+	healthHandler := handlers.NewHealthHandler()
+	statusHandler, err := handlers.LoadStatusHandler(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error loading status template: %v", err)
+	}
+
+	// Setup routers. The public one serves forms and submissions, the admin
+	// one the dashboard, the API and the development tools. Without
+	// ADMIN_LISTEN both are served on the same addresses.
+	r := mux.NewRouter()
+	r.Use(tracing.Route, metrics.HTTP, middleware.CORS(cfg))
+	admin := mux.NewRouter()
+	admin.Use(tracing.Route, metrics.HTTP, middleware.CORS(cfg))
+	if networks := cfg.AdminNetworks(); len(networks) > 0 {
+		admin.Use(middleware.AllowIPs(networks))
+	}
+	if cfg.TLSClientCAFile != "" {
+		// The liveness checks are public routes too, which the admin router
+		// matches first when both share an address; they reveal nothing
+		admin.Use(middleware.RequireClientCert("/health", "/livez"))
+	}
+	// Submissions apply their form's limit themselves
+	limitBody := middleware.MaxBytes(int64(cfg.MaxBodyBytes))
+
+	r.HandleFunc("/submit", submitHandler.Handle).Methods("POST", "OPTIONS")
+	r.HandleFunc("/f/{slug}", submitHandler.HandleFormspree).Methods("POST", "OPTIONS")
+	r.HandleFunc("/f/{slug}", hostedFormHandler.Handle).Methods("GET")
+	r.HandleFunc("/f/{slug}/config", embedHandler.Handle).Methods("GET")
+	r.HandleFunc("/health", healthHandler.Handle).Methods("GET")
+	r.HandleFunc("/livez", healthHandler.Handle).Methods("GET")
+	r.HandleFunc("/status", statusHandler.Handle).Methods("GET")
+	admin.HandleFunc("/health", healthHandler.Handle).Methods("GET")
+	admin.HandleFunc("/livez", healthHandler.Handle).Methods("GET")
+	// Readiness details name the SMTP server and database, so they stay
+	// with the admin routes
+	readinessHandler := handlers.NewReadinessHandler(services.NewReadiness(s.readinessChecks(cfg, emailService)...))
+	admin.HandleFunc("/readyz", readinessHandler.Handle).Methods("GET")
+
+	if s.mailCatcher != nil {
+		devMailHandler, err := handlers.LoadDevMailHandler(cfg, s.mailCatcher)
+		if err != nil {
+			return nil, fmt.Errorf("error loading dev mail template: %v", err)
+		}
+		admin.HandleFunc("/dev/mail", devMailHandler.Inbox).Methods("GET")
+		admin.HandleFunc("/dev/mail/clear", devMailHandler.Clear).Methods("POST")
+		admin.HandleFunc("/dev/mail/api/messages", devMailHandler.ListJSON).Methods("GET")
+		admin.HandleFunc("/dev/mail/api/messages", devMailHandler.Clear).Methods("DELETE")
+		admin.HandleFunc("/dev/mail/api/messages/{id}", devMailHandler.GetJSON).Methods("GET")
+		admin.HandleFunc("/dev/mail/{id}", devMailHandler.Inbox).Methods("GET")
+		admin.HandleFunc("/dev/mail/{id}/html", devMailHandler.HTML).Methods("GET")
+		scheme := "http"
+		if cfg.TLSEnabled() {
+			scheme = "https"
+		}
+		inbox := fmt.Sprintf("%s://localhost:%s/dev/mail", scheme, cfg.Port)
+		if len(cfg.AdminListen) > 0 {
+			inbox = "/dev/mail on " + strings.Join(cfg.AdminListen, ", ")
+		}
+		log.Printf("Development mode: email is not sent but captured at %s", inbox)
+	}
+
+	if cfg.EnableTestForm {
+		testFormHandler, err := handlers.LoadTestFormHandler(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error loading test form template: %v", err)
+		}
+		admin.HandleFunc("/test_form", testFormHandler.Handle).Methods("GET")
+	}
+
+	if s.store != nil {
+		adminHandler, err := handlers.LoadAdminHandler(cfg, s.store, emailService, csrfService, s.authService)
+		if err != nil {
+			return nil, fmt.Errorf("error loading admin template: %v", err)
+		}
+		admin.HandleFunc("/admin/login", adminHandler.LoginPage).Methods("GET")
+		admin.Handle("/admin/login", limitBody(http.HandlerFunc(adminHandler.Login))).Methods("POST")
+		if oidcService.Enabled() {
+			oidcHandler := handlers.NewOIDCHandler(oidcService, s.authService)
+			admin.HandleFunc("/admin/oidc/login", oidcHandler.Login).Methods("GET")
+			admin.HandleFunc("/admin/oidc/callback", oidcHandler.Callback).Methods("GET")
+			log.Printf("Single sign-on enabled with %s", cfg.OIDCIssuer)
+		}
+		dashboard := admin.PathPrefix("/admin").Subrouter()
+		dashboard.Use(limitBody, adminHandler.RequireAuth)
+		dashboard.Handle("", http.RedirectHandler("/admin/submissions", http.StatusFound)).Methods("GET")
+		dashboard.Handle("/", http.RedirectHandler("/admin/submissions", http.StatusFound)).Methods("GET")
+		dashboard.HandleFunc("/logout", adminHandler.Logout).Methods("POST")
+		dashboard.HandleFunc("/submissions", adminHandler.List).Methods("GET")
+		dashboard.HandleFunc("/submissions/export", adminHandler.Export).Methods("GET")
+		dashboard.HandleFunc("/submissions/{id}", adminHandler.Detail).Methods("GET")
+		dashboard.HandleFunc("/submissions/{id}/{action}", adminHandler.Action).Methods("POST")
+		dashboard.HandleFunc("/replies", adminHandler.Replies).Methods("GET")
+		dashboard.HandleFunc("/replies", adminHandler.CreateReply).Methods("POST")
+		dashboard.HandleFunc("/replies/{id}/delete", adminHandler.DeleteReply).Methods("POST")
+		dashboard.HandleFunc("/api-keys", adminHandler.APIKeys).Methods("GET")
+		dashboard.HandleFunc("/api-keys", adminHandler.CreateAPIKey).Methods("POST")
+		dashboard.HandleFunc("/api-keys/{id}/delete", adminHandler.RevokeAPIKey).Methods("POST")
+		dashboard.HandleFunc("/account", adminHandler.Account).Methods("GET")
+		dashboard.HandleFunc("/account/{action}", adminHandler.AccountAction).Methods("POST")
+		log.Printf("Admin dashboard enabled at /admin")
+		if cfg.TLSClientCAFile != "" {
+			log.Printf("Admin dashboard requires a client certificate signed by %s", cfg.TLSClientCAFile)
+		}
+	}
+
+	if s.store != nil {
+		apiHandler := handlers.NewAPIHandler(cfg, s.store, emailService, oidcService)
+		api := admin.PathPrefix("/api/v1").Subrouter()
+		api.Use(limitBody)
+		api.HandleFunc("/openapi.json", apiHandler.OpenAPI).Methods("GET")
+		api.HandleFunc("/submissions", apiHandler.Authorize(models.ScopeSubmissionsRead, apiHandler.ListSubmissions)).Methods("GET")
+		api.HandleFunc("/submissions/export", apiHandler.Authorize(models.ScopeSubmissionsRead, apiHandler.ExportSubmissions)).Methods("GET")
+		api.HandleFunc("/submissions/{id}", apiHandler.Authorize(models.ScopeSubmissionsRead, apiHandler.GetSubmission)).Methods("GET")
+		api.HandleFunc("/submissions/{id}", apiHandler.Authorize(models.ScopeSubmissionsWrite, apiHandler.UpdateSubmission)).Methods("PATCH")
+		api.HandleFunc("/submissions/{id}", apiHandler.Authorize(models.ScopeSubmissionsDelete, apiHandler.DeleteSubmission)).Methods("DELETE")
+		api.HandleFunc("/submissions/{id}/notes", apiHandler.Authorize(models.ScopeSubmissionsWrite, apiHandler.AddNote)).Methods("POST")
+		api.HandleFunc("/submissions/{id}/replay", apiHandler.Authorize(models.ScopeSubmissionsReplay, apiHandler.ReplaySubmission)).Methods("POST")
+		api.HandleFunc("/forms", apiHandler.Authorize(models.ScopeFormsRead, apiHandler.ListForms)).Methods("GET")
+		api.HandleFunc("/forms", apiHandler.Authorize(models.ScopeFormsWrite, apiHandler.CreateForm)).Methods("POST")
+		api.HandleFunc("/forms/{slug}", apiHandler.Authorize(models.ScopeFormsRead, apiHandler.GetForm)).Methods("GET")
+		api.HandleFunc("/forms/{slug}", apiHandler.Authorize(models.ScopeFormsWrite, apiHandler.UpdateForm)).Methods("PUT")
+		api.HandleFunc("/forms/{slug}", apiHandler.Authorize(models.ScopeFormsWrite, apiHandler.DeleteForm)).Methods("DELETE")
+		log.Printf("Admin API enabled at /api/v1")
+	}
+
+	if cfg.EnableMetrics {
+		admin.Handle("/metrics", metrics.Handler()).Methods("GET")
+		log.Printf("Prometheus metrics enabled at /metrics")
+	}
+
+	if cfg.EnablePprof {
+		admin.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		admin.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		admin.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		admin.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		admin.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+		log.Printf("Profiling enabled at /debug/pprof/")
+	}
+
+	if len(cfg.AdminListen) == 0 {
+		r.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			var match mux.RouteMatch
+			return admin.Match(req, &match)
+		}).Handler(admin)
+	}
+
+	// Static file serving
+	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web/static/")))
+
+	// Spans and request IDs come first so the access log and handlers can
+	// use them
+	wrap := func(h http.Handler) http.Handler {
+		if cfg.AccessLog {
+			h = middleware.AccessLog(h)
+		}
+		return tracing.Handler(middleware.RequestID(h))
+	}
+	return &site{cfg: cfg, handler: wrap(r), admin: wrap(admin)}, nil
+}
+
+// randomSecret stands in for an unset POW_SECRET or CSRF_SECRET for the
+// lifetime of the process
+func randomSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal("Error generating secret: ", err)
+	}
+	return hex.EncodeToString(secret)
+}
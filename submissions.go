@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+const submissionsUsage = `Usage: formfling submissions <command> [arguments]
+
+Commands:
+  list [-form slug] [-spam exclude|only|all] [-status status] [-search text]
+       [-limit n]                       List submissions, newest first
+  show <id>                             Print a submission with its timeline
+  delete <id>                           Delete a submission
+  export [-format csv|jsonl|xlsx] [-form slug] [-since date] [-until date]
+         [-spam exclude|only|all] [-delimiter comma|semicolon|tab|pipe] [-bom]
+         [-o file]                      Export submissions, newest first
+
+Dates are YYYY-MM-DD or RFC 3339 timestamps; -until is exclusive. The export is
+written to standard output unless -o is given. The server must be stopped, as
+only one process can open the database.
+`
+
+// runSubmissions works with stored submissions from the command line and
+// returns the exit code
+func runSubmissions(cfg *config.Config, args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, submissionsUsage)
+		return 2
+	}
+
+	var run func(*config.Config, *store.Store, []string) error
+	switch args[0] {
+	case "list":
+		run = listSubmissions
+	case "show":
+		run = showSubmission
+	case "delete":
+		run = deleteSubmission
+	case "export":
+		run = exportSubmissions
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], submissionsUsage)
+		return 2
+	}
+
+	s, err := openStore(cfg, "work with submissions")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	if err := run(cfg, s, args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func listSubmissions(cfg *config.Config, s *store.Store, args []string) error {
+	flags := flag.NewFlagSet("submissions list", flag.ContinueOnError)
+	form := flags.String("form", "", "only submissions of this form")
+	spam := flags.String("spam", "exclude", "exclude, only or all")
+	status := flags.String("status", "", "only tickets with this status")
+	search := flags.String("search", "", "only submissions containing this text")
+	limit := flags.Int("limit", 50, "how many submissions to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *status != "" && !models.ValidTicketStatus(*status) {
+		return fmt.Errorf("-status must be one of %s", strings.Join(models.TicketStatuses, ", "))
+	}
+
+	query := store.Query{Form: *form, Status: *status, Search: *search, Limit: *limit}
+	var err error
+	if query.Spam, err = parseSpam(*spam); err != nil {
+		return err
+	}
+	subs, _, err := s.ListSubmissions(query)
+	if err != nil {
+		return err
+	}
+
+	loc := services.ExportLocation(cfg)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRECEIVED\tFORM\tSTATUS\tFROM\tSUBJECT")
+	for _, sub := range subs {
+		from := sub.Data.Email
+		if sub.Data.Name != "" {
+			from = sub.Data.Name + " <" + sub.Data.Email + ">"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", sub.ID, sub.CreatedAt.In(loc).Format("2006-01-02 15:04"), sub.Form, sub.Status, from, sub.Data.Subject)
+	}
+	return w.Flush()
+}
+
+func showSubmission(cfg *config.Config, s *store.Store, args []string) error {
+	sub, err := findSubmission(s, args)
+	if err != nil {
+		return err
+	}
+
+	loc := services.ExportLocation(cfg)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", sub.ID)
+	fmt.Fprintf(w, "Form:\t%s\n", sub.Form)
+	fmt.Fprintf(w, "Received:\t%s\n", sub.CreatedAt.In(loc).Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Status:\t%s\n", sub.Status)
+	if sub.Assignee != "" {
+		fmt.Fprintf(w, "Assignee:\t%s\n", sub.Assignee)
+	}
+	if len(sub.Tags) > 0 {
+		fmt.Fprintf(w, "Tags:\t%s\n", strings.Join(sub.Tags, ", "))
+	}
+	if sub.Spam {
+		fmt.Fprintf(w, "Spam:\tyes\n")
+	}
+	for _, d := range sub.Deliveries {
+		fmt.Fprintf(w, "Delivery (%s):\t%s after %d attempt(s) %s\n", d.Channel, d.Status, d.Attempts, d.LastError)
+	}
+	data := sub.FormData()
+	for _, field := range []struct{ label, value string }{
+		{"Name", data.Name}, {"Email", data.Email}, {"Phone", data.Phone}, {"Website", data.Website}, {"Subject", data.Subject},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", field.label, field.value)
+		}
+	}
+	for _, extra := range data.Extra {
+		fmt.Fprintf(w, "%s:\t%s\n", extra.Label, extra.Value)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if data.Message != "" {
+		fmt.Printf("\n%s\n", data.Message)
+	}
+
+	for _, event := range sub.Events {
+		fmt.Printf("\n%s %s %s", event.At.In(loc).Format("2006-01-02 15:04"), event.Actor, event.Kind)
+		if event.From != "" || event.To != "" {
+			fmt.Printf(" %q -> %q", event.From, event.To)
+		}
+		if event.Subject != "" {
+			fmt.Printf(": %s", event.Subject)
+		}
+		fmt.Println()
+		if event.Body != "" {
+			fmt.Println(event.Body)
+		}
+	}
+	return nil
+}
+
+func deleteSubmission(cfg *config.Config, s *store.Store, args []string) error {
+	sub, err := findSubmission(s, args)
+	if err != nil {
+		return err
+	}
+	if err := s.DeleteSubmission(sub.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %s\n", sub.ID)
+	return nil
+}
+
+// findSubmission loads the submission named by the only argument
+func findSubmission(s *store.Store, args []string) (*models.Submission, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected exactly one submission ID")
+	}
+	sub, err := s.GetSubmission(args[0])
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("no submission with ID %q", args[0])
+	}
+	return sub, err
+}
+
+func exportSubmissions(cfg *config.Config, s *store.Store, args []string) error {
+	flags := flag.NewFlagSet("submissions export", flag.ContinueOnError)
+	format := flags.String("format", services.ExportCSV, "csv, jsonl or xlsx")
+	form := flags.String("form", "", "only submissions of this form")
+	since := flags.String("since", "", "only submissions received at or after this date")
+	until := flags.String("until", "", "only submissions received before this date")
+	spam := flags.String("spam", "exclude", "exclude, only or all")
+	delimiter := flags.String("delimiter", "comma", "CSV delimiter: comma, semicolon, tab or pipe")
+	bom := flags.Bool("bom", false, "start CSV files with a byte order mark for Excel")
+	output := flags.String("o", "", "write to this file instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !services.ValidExportFormat(*format) {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	opts := services.ExportOptions{Format: *format, BOM: *bom, Location: services.ExportLocation(cfg)}
+	var err error
+	if opts.Delimiter, err = services.ParseDelimiter(*delimiter); err != nil {
+		return err
+	}
+
+	query := store.Query{Form: *form}
+	if query.Spam, err = parseSpam(*spam); err != nil {
+		return err
+	}
+	if query.Since, err = parseDate(*since, opts.Location); err != nil {
+		return fmt.Errorf("-since: %v", err)
+	}
+	if query.Until, err = parseDate(*until, opts.Location); err != nil {
+		return fmt.Errorf("-until: %v", err)
+	}
+
+	forms, err := loadForms(cfg, s)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	err = services.ExportSubmissions(out, s, query, forms, opts)
+	if *output != "" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// parseSpam maps the -spam flag to the store's spam filter
+func parseSpam(value string) (string, error) {
+	switch value {
+	case "exclude":
+		return store.SpamExclude, nil
+	case "only":
+		return store.SpamOnly, nil
+	case "all":
+		return store.SpamAll, nil
+	}
+	return "", fmt.Errorf("-spam must be exclude, only or all")
+}
+
+// loadForms returns the configured forms for the field columns of an export
+func loadForms(cfg *config.Config, s *store.Store) ([]*config.Form, error) {
+	var forms map[string]*config.Form
+	var err error
+	switch {
+	case cfg.FormsBackend == config.FormsBackendDB:
+		forms, err = s.LoadForms()
+	case cfg.FormsFile != "":
+		forms, err = config.LoadForms(cfg.FormsFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading forms: %v", err)
+	}
+	cfg.SetForms(forms)
+	return cfg.AllForms(), nil
+}
+
+// parseDate accepts RFC 3339 timestamps and plain dates in the configured
+// time zone
+func parseDate(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or an RFC 3339 timestamp")
+}
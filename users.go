@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"formfling/internal/config"
+	"formfling/internal/models"
+	"formfling/internal/services"
+	"formfling/internal/store"
+)
+
+const usersUsage = `Usage: formfling users <command> [arguments]
+
+Commands:
+  list                                  List admin accounts
+  add [-role owner|editor|viewer] [-forms a,b] <username>
+                                        Create an account, prompting for its password
+  passwd <username>                     Set a new password
+  role <username> <role>                Change the role
+  grant <username> <form>...            Give access to forms
+  revoke <username> <form>...           Remove access to forms
+  reset-2fa <username>                  Turn off two-factor authentication
+  unlock <username>                     Clear a sign-in lockout
+  delete <username>                     Delete the account and end its sessions
+
+Passwords are read from the terminal, or from the first line of standard input
+when it is not a terminal. The server must be stopped while the database is
+changed, as only one process can open it.
+`
+
+// runUsers manages admin accounts from the command line and returns the exit
+// code
+func runUsers(cfg *config.Config, args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, usersUsage)
+		return 2
+	}
+	s, err := openStore(cfg, "manage admin accounts")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	if err := usersCommand(s, args[0], args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func usersCommand(s *store.Store, command string, args []string) error {
+	if command == "list" {
+		return listUsers(s)
+	}
+	if command == "add" {
+		return addUser(s, args)
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("%s needs a username", command)
+	}
+	user, err := s.GetUser(args[0])
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("no account named %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "passwd":
+		hash, err := readPassword()
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+		if err := s.DeleteUserSessions(user.Username); err != nil {
+			return err
+		}
+	case "role":
+		if len(args) != 2 || !models.ValidRole(args[1]) {
+			return fmt.Errorf("role needs a username and one of %s", strings.Join(models.Roles, ", "))
+		}
+		user.Role = args[1]
+	case "grant":
+		for _, form := range args[1:] {
+			if !contains(user.Forms, form) {
+				user.Forms = append(user.Forms, form)
+			}
+		}
+	case "revoke":
+		var kept []string
+		for _, form := range user.Forms {
+			if !contains(args[1:], form) {
+				kept = append(kept, form)
+			}
+		}
+		user.Forms = kept
+	case "reset-2fa":
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+	case "unlock":
+		user.FailedLogins = 0
+		user.LockedUntil = time.Time{}
+	case "delete":
+		if err := s.DeleteUser(user.Username); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s\n", user.Username)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usersUsage)
+	}
+
+	if err := s.UpdateUser(user); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s\n", user.Username)
+	return nil
+}
+
+func addUser(s *store.Store, args []string) error {
+	flags := flag.NewFlagSet("users add", flag.ContinueOnError)
+	role := flags.String("role", models.RoleViewer, "owner, editor or viewer")
+	forms := flags.String("forms", "", "comma-separated form slugs the account may see")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("add needs exactly one username")
+	}
+	if !models.ValidRole(*role) {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+
+	hash, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{Username: flags.Arg(0), PasswordHash: hash, Role: *role}
+	for _, form := range strings.Split(*forms, ",") {
+		if form = strings.TrimSpace(form); form != "" {
+			user.Forms = append(user.Forms, form)
+		}
+	}
+	if err := s.CreateUser(user); errors.Is(err, store.ErrExists) {
+		return fmt.Errorf("an account named %q already exists", user.Username)
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s (%s)\n", user.Username, user.Role)
+	return nil
+}
+
+func listUsers(s *store.Store) error {
+	users, err := s.ListUsers()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tROLE\tFORMS\t2FA\tLAST SIGN-IN")
+	for _, user := range users {
+		forms := strings.Join(user.Forms, ",")
+		if user.IsOwner() {
+			forms = "*"
+		}
+		twoFactor := "off"
+		if user.TOTPSecret != "" {
+			twoFactor = "on"
+		}
+		lastLogin := "never"
+		if !user.LastLoginAt.IsZero() {
+			lastLogin = user.LastLoginAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", user.Username, user.Role, forms, twoFactor, lastLogin)
+	}
+	return w.Flush()
+}
+
+// readPassword prompts twice on a terminal, or reads one line from a pipe
+func readPassword() (string, error) {
+	var password string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		first, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprint(os.Stderr, "Repeat password: ")
+		second, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(first) != string(second) {
+			return "", fmt.Errorf("passwords do not match")
+		}
+		password = string(first)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	return services.HashPassword(password)
+}
+
+// bootstrapOwner creates the ADMIN_USERNAME owner account from ADMIN_PASSWORD
+// the first time the server starts with it
+func bootstrapOwner(cfg *config.Config, s *store.Store) {
+	if _, err := s.GetUser(cfg.AdminUsername); err == nil {
+		return
+	} else if !errors.Is(err, store.ErrNotFound) {
+		log.Fatal("Error loading admin account: ", err)
+	}
+
+	hash, err := services.HashPassword(cfg.AdminPassword)
+	if err != nil {
+		log.Fatal("ADMIN_PASSWORD: ", err)
+	}
+	user := &models.User{Username: cfg.AdminUsername, PasswordHash: hash, Role: models.RoleOwner}
+	if err := s.CreateUser(user); err != nil {
+		log.Fatal("Error creating admin account: ", err)
+	}
+	log.Printf("Created owner account %s from ADMIN_PASSWORD", user.Username)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
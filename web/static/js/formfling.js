@@ -0,0 +1,243 @@
+/*
+ * FormFling embed script
+ *
+ * Include once per page:
+ *   <script src="https://your-formfling-domain.com/js/formfling.js" defer></script>
+ *
+ * and mark forms with the slug of a FormFling form:
+ *   <form data-formfling="contact" action="https://your-formfling-domain.com/f/contact" method="POST">
+ *
+ * The action attribute keeps the form working as a normal POST when
+ * JavaScript is disabled. With JavaScript the form is submitted with fetch,
+ * after running reCAPTCHA or proof-of-work when the server asks for it, and
+ * the result is shown inline.
+ */
+(function () {
+  'use strict';
+
+  var script = document.currentScript;
+  var base = script && script.src ? new URL(script.src).origin : window.location.origin;
+  var recaptchaLoading = null;
+
+  function endpoint(path) {
+    return base + path;
+  }
+
+  function loadRecaptcha(siteKey) {
+    if (window.grecaptcha && window.grecaptcha.execute) {
+      return Promise.resolve(window.grecaptcha);
+    }
+    if (!recaptchaLoading) {
+      recaptchaLoading = new Promise(function (resolve, reject) {
+        var tag = document.createElement('script');
+        tag.src = 'https://www.google.com/recaptcha/api.js?render=' + encodeURIComponent(siteKey);
+        tag.async = true;
+        tag.onload = function () { window.grecaptcha.ready(function () { resolve(window.grecaptcha); }); };
+        tag.onerror = function () { reject(new Error('failed to load reCAPTCHA')); };
+        document.head.appendChild(tag);
+      });
+    }
+    return recaptchaLoading;
+  }
+
+  function recaptchaToken(settings) {
+    return loadRecaptcha(settings.siteKey).then(function (grecaptcha) {
+      return grecaptcha.execute(settings.siteKey, { action: settings.action });
+    });
+  }
+
+  function leadingZeroBits(bytes) {
+    var count = 0;
+    for (var i = 0; i < bytes.length; i++) {
+      if (bytes[i] === 0) {
+        count += 8;
+        continue;
+      }
+      return count + Math.clz32(bytes[i]) - 24;
+    }
+    return count;
+  }
+
+  // solvePow finds a nonce such that SHA-256("<challenge>:<nonce>") starts
+  // with the requested number of zero bits
+  function solvePow(pow) {
+    var encoder = new TextEncoder();
+    var nonce = 0;
+
+    function attempt() {
+      var batch = [];
+      for (var i = 0; i < 256; i++, nonce++) {
+        batch.push(attemptNonce(nonce));
+      }
+      return Promise.all(batch).then(function (results) {
+        for (var j = 0; j < results.length; j++) {
+          if (results[j] !== null) {
+            return pow.challenge + ':' + results[j];
+          }
+        }
+        return attempt();
+      });
+    }
+
+    function attemptNonce(n) {
+      var data = encoder.encode(pow.challenge + ':' + n);
+      return crypto.subtle.digest('SHA-256', data).then(function (digest) {
+        return leadingZeroBits(new Uint8Array(digest)) >= pow.difficulty ? String(n) : null;
+      });
+    }
+
+    return attempt();
+  }
+
+  function statusElement(form) {
+    var status = form.querySelector('.formfling-status');
+    if (!status) {
+      status = document.createElement('div');
+      status.className = 'formfling-status';
+      status.setAttribute('role', 'status');
+      status.setAttribute('aria-live', 'polite');
+      form.appendChild(status);
+    }
+    return status;
+  }
+
+  function clearErrors(form) {
+    var messages = form.querySelectorAll('.formfling-field-error');
+    for (var i = 0; i < messages.length; i++) {
+      messages[i].parentNode.removeChild(messages[i]);
+    }
+    var invalid = form.querySelectorAll('[aria-invalid="true"]');
+    for (var j = 0; j < invalid.length; j++) {
+      invalid[j].removeAttribute('aria-invalid');
+      invalid[j].removeAttribute('aria-describedby');
+    }
+  }
+
+  function showFieldErrors(form, errors) {
+    var unmatched = [];
+    errors.forEach(function (error) {
+      var field = error.field && form.elements.namedItem(error.field);
+      if (!field || !field.parentNode) {
+        unmatched.push(error.message);
+        return;
+      }
+      var message = document.createElement('span');
+      message.className = 'formfling-field-error';
+      message.id = 'formfling-error-' + error.field;
+      message.textContent = error.message;
+      field.setAttribute('aria-invalid', 'true');
+      field.setAttribute('aria-describedby', message.id);
+      field.parentNode.insertBefore(message, field.nextSibling);
+    });
+    return unmatched;
+  }
+
+  function showStatus(form, type, text) {
+    var status = statusElement(form);
+    status.className = 'formfling-status formfling-' + type;
+    status.textContent = text;
+  }
+
+  function setBusy(form, busy) {
+    form.setAttribute('aria-busy', busy ? 'true' : 'false');
+    var buttons = form.querySelectorAll('button[type="submit"], input[type="submit"]');
+    for (var i = 0; i < buttons.length; i++) {
+      buttons[i].disabled = busy;
+    }
+  }
+
+  function fetchSettings(slug) {
+    return fetch(endpoint('/f/' + encodeURIComponent(slug) + '/config'), {
+      headers: { 'Accept': 'application/json' },
+      cache: 'no-store'
+    }).then(function (response) {
+      if (!response.ok) {
+        throw new Error('form not found');
+      }
+      return response.json();
+    });
+  }
+
+  function submit(form, slug) {
+    var data = new FormData(form);
+
+    return fetchSettings(slug).then(function (settings) {
+      var checks = [];
+      if (settings.recaptcha) {
+        checks.push(recaptchaToken(settings.recaptcha).then(function (token) {
+          data.set('g-recaptcha-response', token);
+        }));
+      }
+      if (settings.pow) {
+        checks.push(solvePow(settings.pow).then(function (solution) {
+          data.set('_pow', solution);
+        }));
+      }
+      return Promise.all(checks).then(function () {
+        return fetch(endpoint(settings.action), {
+          method: 'POST',
+          body: data,
+          headers: {
+            'Accept': 'application/json',
+            'X-Requested-With': 'XMLHttpRequest'
+          }
+        });
+      });
+    }).then(function (response) {
+      return response.json().catch(function () { return {}; }).then(function (body) {
+        return { ok: response.ok, body: body };
+      });
+    });
+  }
+
+  function enhance(form) {
+    var slug = form.getAttribute('data-formfling');
+    if (!slug || form.getAttribute('data-formfling-ready')) {
+      return;
+    }
+    form.setAttribute('data-formfling-ready', 'true');
+    form.setAttribute('novalidate', '');
+
+    form.addEventListener('submit', function (event) {
+      event.preventDefault();
+      clearErrors(form);
+      setBusy(form, true);
+      showStatus(form, 'pending', form.getAttribute('data-formfling-pending') || 'Sending…');
+
+      submit(form, slug).then(function (result) {
+        if (result.ok && result.body.ok) {
+          form.reset();
+          showStatus(form, 'success', form.getAttribute('data-formfling-success') || 'Thanks! Your message has been sent.');
+          form.dispatchEvent(new CustomEvent('formfling:success', { detail: result.body }));
+          return;
+        }
+        var errors = result.body.errors || [];
+        var unmatched = showFieldErrors(form, errors);
+        var text = form.getAttribute('data-formfling-error') || 'There was an error sending your message. Please try again.';
+        if (unmatched.length) {
+          text += ' ' + unmatched.join(' ');
+        }
+        showStatus(form, 'error', text);
+        form.dispatchEvent(new CustomEvent('formfling:error', { detail: result.body }));
+      }).catch(function (err) {
+        showStatus(form, 'error', 'There was an error sending your message. Please try again.');
+        form.dispatchEvent(new CustomEvent('formfling:error', { detail: { error: err.message } }));
+      }).then(function () {
+        setBusy(form, false);
+      });
+    });
+  }
+
+  function init() {
+    var forms = document.querySelectorAll('form[data-formfling]');
+    for (var i = 0; i < forms.length; i++) {
+      enhance(forms[i]);
+    }
+  }
+
+  if (document.readyState === 'loading') {
+    document.addEventListener('DOMContentLoaded', init);
+  } else {
+    init();
+  }
+})();